@@ -12,6 +12,7 @@ import (
 
 	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/api"
 	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/api/middleware"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository"
 	"github.com/Cheertaboi/Billing-system-coupon-microservice/pkg/db"
 )
 
@@ -25,8 +26,13 @@ func main() {
 	}
 	defer conn.Close()
 
+	// codes are normalized the way the stored ones were
+	if err := repository.LoadCodeNormalization(context.Background(), conn); err != nil {
+		log.Fatal(err)
+	}
+
 	// create handler with repos & services
-	handler := api.NewRouter(conn)
+	handler := api.NewRouter(conn, cfg.DSN())
 
 	// add middleware if needed (example: logger)
 	r := chi.NewRouter()
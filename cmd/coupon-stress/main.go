@@ -0,0 +1,259 @@
+// coupon-stress fires concurrent /coupons/validate calls (each one redeems) at a
+// running coupon-service and checks that usage limits hold under contention.
+//
+// Every user gets -requests attempts at the same coupon, spread over -workers
+// goroutines. Afterwards:
+//   - no user may have more successful redemptions than the per-user limit
+//     (1 for one_time coupons, else max_usage_per_user; 0 = unlimited)
+//   - the total across users may not exceed -global-limit, when set
+//   - with -verify-db, each user's coupon_usage row in the Postgres from the
+//     DB_* env vars must have grown by exactly their successful redemptions
+//   - no request may fail with a non-200 status (internal_error)
+//
+// It then prints latency percentiles for all requests.
+//
+// usage: coupon-stress -url http://localhost:8080 -request validate.json -users 20 -requests 50 -workers 64
+//
+// validate.json holds one /coupons/validate request body used as the template;
+// user ids are <user_id>-<run>-<n> so each run starts without usage rows.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/api/handlers"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/pkg/db"
+)
+
+type validateResult struct {
+	IsValid bool   `json:"is_valid"`
+	Message string `json:"message"`
+}
+
+type outcome struct {
+	user    int
+	latency time.Duration
+	status  int
+	result  validateResult
+	err     error
+}
+
+func main() {
+	baseURL := flag.String("url", "http://localhost:8080", "coupon-service base URL")
+	requestPath := flag.String("request", "validate.json", "validate request body template (JSON)")
+	users := flag.Int("users", 20, "distinct users")
+	requests := flag.Int("requests", 50, "redemption attempts per user")
+	workers := flag.Int("workers", 64, "concurrent requests in flight")
+	perUser := flag.Int("per-user-limit", -1, "max redemptions per user; -1 = read the coupon from the database")
+	globalLimit := flag.Int("global-limit", 0, "max redemptions across all users, 0 = don't check")
+	verifyDB := flag.Bool("verify-db", true, "cross-check coupon_usage in Postgres (DB_* env)")
+	flag.Parse()
+
+	raw, err := os.ReadFile(*requestPath)
+	if err != nil {
+		log.Fatalf("read request: %v", err)
+	}
+	var tmpl handlers.ValidateRequestBody
+	if err := json.Unmarshal(raw, &tmpl); err != nil {
+		log.Fatalf("parse request: %v", err)
+	}
+
+	run := time.Now().UTC().Format("20060102T150405")
+	userIDs := make([]string, *users)
+	for i := range userIDs {
+		userIDs[i] = fmt.Sprintf("%s-%s-%d", tmpl.UserID, run, i)
+	}
+
+	// limits and usage rows come from the database when available
+	var usage *usageChecker
+	if *verifyDB || *perUser < 0 {
+		usage, err = newUsageChecker(tmpl.Coupon)
+		if err != nil {
+			log.Fatalf("database: %v", err)
+		}
+		defer usage.close()
+		if *perUser < 0 {
+			*perUser = usage.perUserLimit
+		}
+	}
+
+	outcomes := fire(*baseURL, tmpl, userIDs, *requests, *workers)
+
+	redeemed := make([]int, len(userIDs))
+	reasons := map[string]int{}
+	latencies := make([]time.Duration, 0, len(outcomes))
+	failures, total := 0, 0
+	for _, o := range outcomes {
+		latencies = append(latencies, o.latency)
+		switch {
+		case o.err != nil:
+			failures++
+			fmt.Printf("FAIL user %d: %v\n", o.user, o.err)
+		case o.status != http.StatusOK:
+			failures++
+			fmt.Printf("FAIL user %d: status %d\n", o.user, o.status)
+		case o.result.IsValid:
+			redeemed[o.user]++
+			total++
+		default:
+			reasons[o.result.Message]++
+		}
+	}
+
+	for i, n := range redeemed {
+		if *perUser > 0 && n > *perUser {
+			failures++
+			fmt.Printf("FAIL %s: %d redemptions, per-user limit %d\n", userIDs[i], n, *perUser)
+		}
+	}
+	if *globalLimit > 0 && total > *globalLimit {
+		failures++
+		fmt.Printf("FAIL: %d redemptions in total, global limit %d\n", total, *globalLimit)
+	}
+	if *verifyDB {
+		for i, uid := range userIDs {
+			n, err := usage.count(uid)
+			if err != nil {
+				log.Fatalf("usage %s: %v", uid, err)
+			}
+			if n != redeemed[i] {
+				failures++
+				fmt.Printf("FAIL %s: %d successful responses but usage_count %d\n", uid, redeemed[i], n)
+			}
+		}
+	}
+
+	fmt.Printf("%d users x %d attempts, %d workers: redeemed=%d rejected=%v failures=%d\n",
+		len(userIDs), *requests, *workers, total, reasons, failures)
+	printLatencies(latencies)
+	if failures > 0 {
+		os.Exit(1)
+	}
+}
+
+// fire sends requests attempts per user, interleaving users so every user sees
+// concurrent redemptions
+func fire(baseURL string, tmpl handlers.ValidateRequestBody, userIDs []string, requests, workers int) []outcome {
+	client := &http.Client{
+		Timeout:   15 * time.Second,
+		Transport: &http.Transport{MaxIdleConnsPerHost: workers},
+	}
+
+	jobs := make(chan int)
+	results := make(chan outcome)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for user := range jobs {
+				body := tmpl
+				body.UserID = userIDs[user]
+				results <- validate(client, baseURL, user, body)
+			}
+		}()
+	}
+	go func() {
+		for r := 0; r < requests; r++ {
+			for u := range userIDs {
+				jobs <- u
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	var out []outcome
+	for o := range results {
+		out = append(out, o)
+	}
+	return out
+}
+
+func validate(client *http.Client, baseURL string, user int, body handlers.ValidateRequestBody) outcome {
+	o := outcome{user: user}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		o.err = err
+		return o
+	}
+
+	start := time.Now()
+	resp, err := client.Post(baseURL+"/coupons/validate", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		o.latency, o.err = time.Since(start), err
+		return o
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	o.latency, o.status = time.Since(start), resp.StatusCode
+	if err != nil {
+		o.err = err
+		return o
+	}
+	if resp.StatusCode == http.StatusOK {
+		o.err = json.Unmarshal(raw, &o.result)
+	}
+	return o
+}
+
+func printLatencies(ls []time.Duration) {
+	if len(ls) == 0 {
+		return
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i] < ls[j] })
+	pct := func(p float64) time.Duration {
+		return ls[int(p*float64(len(ls)-1))]
+	}
+	fmt.Printf("latency p50=%v p90=%v p95=%v p99=%v max=%v\n",
+		pct(0.50), pct(0.90), pct(0.95), pct(0.99), ls[len(ls)-1])
+}
+
+// usageChecker reads the coupon's limits and usage rows straight from Postgres
+type usageChecker struct {
+	repo         *repository.UsageRepo
+	close        func() error
+	couponID     int
+	perUserLimit int
+}
+
+func newUsageChecker(code string) (*usageChecker, error) {
+	cfg, _ := db.LoadPostgresConfig()
+	conn, err := db.NewPostgresConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := repository.NewCouponRepo(conn).GetCouponMeta(context.Background(), code)
+	if err != nil || meta == nil {
+		conn.Close()
+		return nil, fmt.Errorf("coupon %q not found (%v)", code, err)
+	}
+
+	limit := meta.MaxUsagePerUser
+	if meta.UsageType == "one_time" {
+		limit = 1
+	}
+	return &usageChecker{
+		repo:         repository.NewUsageRepo(conn),
+		close:        conn.Close,
+		couponID:     meta.ID,
+		perUserLimit: limit,
+	}, nil
+}
+
+func (u *usageChecker) count(userID string) (int, error) {
+	return u.repo.GetUsageCount(context.Background(), u.couponID, userID)
+}
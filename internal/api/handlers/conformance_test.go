@@ -0,0 +1,157 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/api/handlers"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// conformanceCoupons covers every discount type and eligibility rule; fields
+// common to all coupons are added by conformanceCoupon
+var conformanceCoupons = map[string]string{
+	"PCT10":      `"discount_type":"percentage","discount_value":10,"target_type":"inventory"`,
+	"FLAT50":     `"discount_type":"flat","discount_value":50,"target_type":"inventory","min_order_value":300,"max_usage_per_user":1`,
+	"CHARGES5":   `"discount_type":"percentage","discount_value":5,"target_type":"charges","usage_type":"one_time","max_usage_per_user":1`,
+	"PAIN20":     `"discount_type":"percentage","discount_value":20,"target_type":"inventory","applicable_categories":["painkillers"],"min_eligible_qty":3`,
+	"VITSUB":     `"discount_type":"flat","discount_value":30,"target_type":"inventory","applicable_categories":["vitamins"],"min_eligible_subtotal":100`,
+	"BOGO21":     `"discount_type":"bogo","discount_value":100,"target_type":"inventory","buy_quantity":2,"get_quantity":1,"applicable_medicine_ids":["med1","med2"]`,
+	"TIERTOTAL":  `"discount_type":"tiered","tier_basis":"order_total","target_type":"inventory","tiers":[{"threshold":500,"discount_type":"percentage","discount_value":10},{"threshold":200,"discount_type":"flat","discount_value":20}]`,
+	"TIERQTY":    `"discount_type":"tiered","tier_basis":"eligible_qty","target_type":"inventory","applicable_categories":["antibiotics"],"tiers":[{"threshold":2,"discount_type":"flat","discount_value":5},{"threshold":5,"discount_type":"flat","discount_value":15}]`,
+	"UNIT2":      `"discount_type":"per_unit_flat","discount_value":2,"target_type":"inventory","max_units_discounted":3`,
+	"FIXED":      `"discount_type":"fixed_price","discount_value":9.99,"target_type":"inventory","applicable_medicine_ids":["med3"]`,
+	"GOLDAPP":    `"discount_type":"percentage","discount_value":15,"target_type":"inventory","eligibility_conditions":[{"attribute":"tier","operator":"in","values":["gold"]},{"attribute":"channel","operator":"eq","values":["app"]}]`,
+	"FIRSTORDER": `"discount_type":"flat","discount_value":25,"target_type":"inventory","max_prior_orders":0`,
+	"EXPR":       `"discount_type":"percentage","discount_value":12,"target_type":"inventory","rule_expression":"cart.qty >= 4 && any(cart.items, .category == \"vitamins\")"`,
+	"VIP":        `"discount_type":"percentage","discount_value":30,"target_type":"inventory","audience":"assigned","assigned_user_ids":["vip"]`,
+	"LATER":      `"discount_type":"percentage","discount_value":40,"target_type":"inventory","usage_type":"time_based","valid_from":"` + time.Now().Add(24*time.Hour).UTC().Format(time.RFC3339) + `","valid_to":"` + time.Now().Add(48*time.Hour).UTC().Format(time.RFC3339) + `"`,
+}
+
+func conformanceCoupon(code, fields string) string {
+	common := map[string]string{
+		"usage_type":         `"multi_use"`,
+		"max_usage_per_user": `3`,
+		"expiry_date":        `"` + time.Now().Add(30*24*time.Hour).UTC().Format(time.RFC3339) + `"`,
+	}
+	body := `{"coupon_code":"` + code + `",` + fields
+	for k, v := range common {
+		if !strings.Contains(fields, `"`+k+`"`) {
+			body += `,"` + k + `":` + v
+		}
+	}
+	return body + "}"
+}
+
+var conformanceItems = []models.CartItem{
+	{ID: "med1", Category: "painkillers", Price: 12},
+	{ID: "med2", Category: "painkillers", Price: 30},
+	{ID: "med3", Category: "antibiotics", Price: 45},
+	{ID: "med4", Category: "antibiotics", Price: 80},
+	{ID: "med5", Category: "vitamins", Price: 25},
+	{ID: "med6", Category: "vitamins", Price: 150},
+}
+
+func randomCart(rng *rand.Rand) handlers.ApplicableRequestBody {
+	users := []string{"u1", "u2", "vip", "newbie"}
+	tiers := []string{"", "silver", "gold"}
+	channels := []string{"", "app", "web"}
+
+	cart := handlers.ApplicableRequestBody{UserID: users[rng.Intn(len(users))]}
+	priorOrders := rng.Intn(3)
+	// prior_orders is always given so validating one coupon can't change the
+	// order history seen by the next
+	cart.User = models.UserContext{Tier: tiers[rng.Intn(len(tiers))], Channel: channels[rng.Intn(len(channels))], PriorOrders: &priorOrders}
+	for _, i := range rng.Perm(len(conformanceItems))[:1+rng.Intn(4)] {
+		it := conformanceItems[i]
+		it.Qty = 1 + rng.Intn(5)
+		cart.CartItems = append(cart.CartItems, it)
+		cart.OrderTotal += it.Price * float64(it.Qty)
+	}
+	cart.OrderTotal += float64(rng.Intn(3) * 20) // delivery charges
+	return cart
+}
+
+// TestApplicableConformsToValidate checks that every coupon listed as
+// applicable validates with the same discount, and every rejected coupon fails
+// validation with the same reason. Users repeat across carts, so usage limits
+// are reached along the way.
+func TestApplicableConformsToValidate(t *testing.T) {
+	h := newRouter(t)
+	for code, fields := range conformanceCoupons {
+		if status := do(t, h, http.MethodPost, "/admin/coupons", conformanceCoupon(code, fields), nil); status != http.StatusCreated {
+			t.Fatalf("create %s: %d", code, status)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(7))
+	applicable, rejected := map[string]int{}, map[string]int{}
+	for i := range 300 {
+		cart := randomCart(rng)
+		cartJSON, _ := json.Marshal(cart)
+
+		var listing handlers.ApplicableResponse
+		if status := do(t, h, http.MethodGet, "/coupons/applicable?explain=true", string(cartJSON), &listing); status != http.StatusOK {
+			t.Fatalf("cart %d: applicable: %d", i, status)
+		}
+
+		// rejected first: they consume nothing, so they see the same usage
+		for _, c := range listing.Rejected {
+			resp := validate(t, h, cart, c.CouponCode)
+			if resp.IsValid || resp.Message != c.Reason {
+				t.Errorf("cart %d %s: rejected for %s but validate says %+v\n%s", i, c.CouponCode, c.Reason, resp, cartJSON)
+			}
+			rejected[c.Reason]++
+		}
+		for _, c := range listing.Coupons {
+			resp := validate(t, h, cart, c.CouponCode)
+			if !resp.IsValid || math.Abs(resp.Discount-c.Discount) > 1e-9 {
+				t.Errorf("cart %d %s: applicable with discount %v but validate says %+v\n%s", i, c.CouponCode, c.Discount, resp, cartJSON)
+			}
+			applicable[c.CouponCode]++
+		}
+	}
+
+	// the carts must exercise the catalogue, or the test proves little
+	for code := range conformanceCoupons {
+		if code != "LATER" && applicable[code] == 0 {
+			t.Errorf("%s never applicable", code)
+		}
+	}
+	for _, reason := range []string{"usage_limit_reached", "coupon_already_used", "min_eligible_qty_not_met", "not_eligible_segment", "rule_not_satisfied", "order_history_not_eligible"} {
+		if rejected[reason] == 0 {
+			t.Errorf("no coupon rejected for %s", reason)
+		}
+	}
+	if t.Failed() {
+		t.Logf("applicable %v\nrejected %v", applicable, rejected)
+	}
+}
+
+type validation struct {
+	IsValid  bool    `json:"is_valid"`
+	Discount float64 `json:"discount"`
+	Message  string  `json:"message"`
+}
+
+// validate redeems code against the cart
+func validate(t *testing.T, h http.Handler, cart handlers.ApplicableRequestBody, code string) validation {
+	t.Helper()
+	body, _ := json.Marshal(handlers.ValidateRequestBody{
+		UserID:     cart.UserID,
+		User:       cart.User,
+		Coupon:     code,
+		CartItems:  cart.CartItems,
+		OrderTotal: cart.OrderTotal,
+	})
+	var resp validation
+	if status := do(t, h, http.MethodPost, "/coupons/validate", string(body), &resp); status != http.StatusOK {
+		t.Fatalf("validate %s: %d", code, status)
+	}
+	return resp
+}
@@ -1,77 +1,114 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/cache"
 	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
 	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository"
 	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/service"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/uow"
 )
 
 // --- Request / Response DTOs ---
 
 type CreateCouponRequest struct {
-	CouponCode      string   `json:"coupon_code"`
-	ExpiryDate      string   `json:"expiry_date"` // RFC3339 string
-	UsageType       string   `json:"usage_type"`
-	MinOrderValue   float64  `json:"min_order_value"`
-	ValidFrom       string   `json:"valid_from,omitempty"`
-	ValidTo         string   `json:"valid_to,omitempty"`
-	DiscountType    string   `json:"discount_type"`
-	DiscountValue   float64  `json:"discount_value"`
-	MaxUsagePerUser int      `json:"max_usage_per_user"`
-	TargetType      string   `json:"target_type"`
-	Terms           string   `json:"terms_and_conditions,omitempty"`
-	Items           []string `json:"applicable_medicine_ids,omitempty"`
-	Categories      []string `json:"applicable_categories,omitempty"`
+	CouponCode          string                        `json:"coupon_code"`
+	ExpiryDate          string                        `json:"expiry_date"` // RFC3339 string
+	UsageType           string                        `json:"usage_type"`
+	MinOrderValue       float64                       `json:"min_order_value"`
+	MinEligibleQty      int                           `json:"min_eligible_qty,omitempty"`      // over matching lines only
+	MinEligibleSubtotal float64                       `json:"min_eligible_subtotal,omitempty"` // over matching lines only
+	ValidFrom           string                        `json:"valid_from,omitempty"`
+	ValidTo             string                        `json:"valid_to,omitempty"`
+	DiscountType        string                        `json:"discount_type"`
+	DiscountValue       float64                       `json:"discount_value"`
+	BuyQty              int                           `json:"buy_quantity,omitempty"`         // bogo only
+	GetQty              int                           `json:"get_quantity,omitempty"`         // bogo only
+	MaxUnitsDiscounted  int                           `json:"max_units_discounted,omitempty"` // per-item types, 0 = no cap
+	MaxUsagePerUser     int                           `json:"max_usage_per_user"`
+	Audience            string                        `json:"audience,omitempty"`          // public (default) or assigned
+	AssignedUsers       []string                      `json:"assigned_user_ids,omitempty"` // assigned only; more can be uploaded later
+	MinPriorOrders      *int                          `json:"min_prior_orders,omitempty"`  // order history window
+	MaxPriorOrders      *int                          `json:"max_prior_orders,omitempty"`  // 0 = first order only
+	RuleExpression      string                        `json:"rule_expression,omitempty"`   // see internal/expr
+	IsTemplate          bool                          `json:"is_template,omitempty"`       // redeemable only via generated codes; fixed on create
+	TargetType          string                        `json:"target_type"`
+	Terms               string                        `json:"terms_and_conditions,omitempty"`
+	Items               []string                      `json:"applicable_medicine_ids,omitempty"`
+	Categories          []string                      `json:"applicable_categories,omitempty"`
+	RewardItems         []string                      `json:"reward_medicine_ids,omitempty"`    // bogo "get" side
+	RewardCategories    []string                      `json:"reward_categories,omitempty"`      // bogo "get" side
+	TierBasis           string                        `json:"tier_basis,omitempty"`             // tiered: order_total or eligible_qty
+	Tiers               []models.DiscountTier         `json:"tiers,omitempty"`                  // tiered only
+	Conditions          []models.EligibilityCondition `json:"eligibility_conditions,omitempty"` // over the user context
 }
 
 type ValidateRequestBody struct {
-	UserID     string            `json:"user_id"`
-	Coupon     string            `json:"coupon_code"`
-	CartItems  []models.CartItem `json:"cart_items"`
-	OrderTotal float64           `json:"order_total"`
-	Timestamp  string            `json:"timestamp"` // optional, RFC3339
+	UserID     string             `json:"user_id"`
+	User       models.UserContext `json:"user"` // optional attributes for segment conditions
+	Coupon     string             `json:"coupon_code"`
+	CartItems  []models.CartItem  `json:"cart_items"`
+	OrderTotal float64            `json:"order_total"`
+	Timestamp  string             `json:"timestamp"` // optional, RFC3339
+}
+
+type AssignUsersRequest struct {
+	UserIDs []string `json:"user_ids"`
 }
 
 type ApplicableRequestBody struct {
-	UserID     string            `json:"user_id"`
-	CartItems  []models.CartItem `json:"cart_items"`
-	OrderTotal float64           `json:"order_total"`
-	Timestamp  string            `json:"timestamp"` // optional, RFC3339
+	UserID     string             `json:"user_id"`
+	User       models.UserContext `json:"user"` // optional attributes for segment conditions
+	CartItems  []models.CartItem  `json:"cart_items"`
+	OrderTotal float64            `json:"order_total"`
+	Timestamp  string             `json:"timestamp"` // optional, RFC3339
 }
 
 type ApplicableResponse struct {
-	ApplicableCoupons []string `json:"applicable_coupons"`
+	ApplicableCoupons []string                   `json:"applicable_coupons"`
+	Coupons           []service.ApplicableCoupon `json:"coupons"`            // priced, same order as applicable_coupons
+	Rejected          []service.RejectedCoupon   `json:"rejected,omitempty"` // only with ?explain=true
 }
 
 // --- Handler struct & constructor ---
 
+// CouponHandler translates HTTP to CouponService calls; all coupon logic,
+// validation and transactions live in the service
 type CouponHandler struct {
-	db         *sql.DB
-	couponRepo *repository.CouponRepo
-	usageRepo  *repository.UsageRepo
-	service    *service.CouponService
+	service *service.CouponService
 }
 
 func NewCouponHandler(db *sql.DB) *CouponHandler {
 	cRepo := repository.NewCouponRepo(db)
 	uRepo := repository.NewUsageRepo(db)
+	aRepo := repository.NewAssignmentRepo(db)
+	ccRepo := repository.NewChildCodeRepo(db)
 
 	// service expects interfaces; pass repository implementations
-	svc := service.NewCouponService(db, cRepo, uRepo)
+	return NewCouponHandlerWith(service.NewCouponService(uow.NewSQL(db), cRepo, uRepo, aRepo, ccRepo))
+}
 
-	return &CouponHandler{
-		db:         db,
-		couponRepo: cRepo,
-		usageRepo:  uRepo,
-		service:    svc,
+// NewCouponHandlerWith builds a handler on a service wired to any repository
+// implementation, e.g. internal/repository/memory
+func NewCouponHandlerWith(svc *service.CouponService) *CouponHandler {
+	// without the index /coupons/applicable still works via the repository
+	if err := svc.LoadIndex(context.Background()); err != nil {
+		log.Printf("coupon index not loaded: %v", err)
 	}
+	return &CouponHandler{service: svc}
 }
 
 // --- Helpers ---
@@ -93,109 +130,173 @@ func parseTimeOrEmpty(s string) (*time.Time, error) {
 	return &t, nil
 }
 
+// writeValidation writes a validate/quote result
+func writeValidation(w http.ResponseWriter, resp models.ValidationResponse, err error) {
+	if err != nil {
+		// internal error
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal_error", "detail": err.Error()})
+		return
+	}
+
+	if !resp.IsValid {
+		out := map[string]interface{}{
+			"is_valid": false,
+			"message":  resp.Message,
+		}
+		if resp.NextTier != nil {
+			out["next_tier"] = resp.NextTier
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+
+	out := map[string]interface{}{
+		"is_valid": true,
+		"discount": resp.Discount,
+		"message":  resp.Message,
+	}
+	if len(resp.FreeItems) > 0 {
+		out["free_items"] = resp.FreeItems
+	}
+	if resp.NextTier != nil {
+		out["next_tier"] = resp.NextTier
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// writeAdminError maps service errors of admin operations to HTTP
+func writeAdminError(w http.ResponseWriter, err error, fallback string) {
+	var invalid *service.InvalidCouponError
+	switch {
+	case errors.As(err, &invalid):
+		writeInvalidCoupon(w, invalid.Errors)
+	case errors.Is(err, models.ErrDuplicateCouponCode):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "coupon_code_taken"})
+	case errors.Is(err, service.ErrCouponNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "coupon_not_found"})
+	case errors.Is(err, service.ErrNotAssignedAudience):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "coupon_not_assigned_audience"})
+	case errors.Is(err, service.ErrNotTemplate):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "coupon_not_template"})
+	default:
+		log.Printf("%s: %v", fallback, err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": fallback})
+	}
+}
+
+// writeInvalidCoupon rejects a coupon definition with its field errors
+func writeInvalidCoupon(w http.ResponseWriter, errs []service.FieldError) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error":  "invalid_coupon",
+		"errors": errs,
+	})
+}
+
+// requestTime parses an optional RFC3339 timestamp, falling back to now
+func requestTime(ts string) time.Time {
+	if strings.TrimSpace(ts) != "" {
+		if t, err := time.Parse(time.RFC3339, ts); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Now().UTC()
+}
+
 // --- Handlers ---
 
 // CreateCoupon handles POST /admin/coupons
-// creates coupon record + items + categories in a transaction
 func (h *CouponHandler) CreateCoupon(w http.ResponseWriter, r *http.Request) {
 	var req CreateCouponRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_body"})
 		return
 	}
-
-	// basic validation
-	if req.CouponCode == "" || req.DiscountValue <= 0 {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "coupon_code and discount_value required"})
+	meta, parseErrs := req.toMeta()
+	if len(parseErrs) > 0 {
+		writeInvalidCoupon(w, definitionErrors(parseErrs, h.service.CheckNew(meta, req.AssignedUsers, time.Now())))
 		return
 	}
 
-	// parse dates
-	expiry, err := time.Parse(time.RFC3339, req.ExpiryDate)
+	couponID, err := h.service.CreateCoupon(r.Context(), meta, req.AssignedUsers)
 	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid expiry_date; use RFC3339"})
+		writeAdminError(w, err, "failed_create_coupon")
 		return
 	}
-	validFrom, err := parseTimeOrEmpty(req.ValidFrom)
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid valid_from; use RFC3339"})
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"message":   "coupon_created",
+		"coupon_id": couponID,
+	})
+}
+
+// UpdateCoupon handles PUT /admin/coupons/{code}
+// replaces the whole definition; the body is the same as for create, and the
+// code comes from the path. Assignments are managed via /assignments.
+func (h *CouponHandler) UpdateCoupon(w http.ResponseWriter, r *http.Request) {
+	var req CreateCouponRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_body"})
 		return
 	}
-	validTo, err := parseTimeOrEmpty(req.ValidTo)
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid valid_to; use RFC3339"})
+	if len(req.AssignedUsers) > 0 {
+		writeInvalidCoupon(w, []service.FieldError{{
+			Field: "assigned_user_ids", Code: service.CodeNotAllowed, Message: "assigned_user_ids not allowed on update; use /assignments",
+		}})
+		return
+	}
+	req.CouponCode = chi.URLParam(r, "code")
+	meta, parseErrs := req.toMeta()
+	if len(parseErrs) > 0 {
+		writeInvalidCoupon(w, definitionErrors(parseErrs, service.CheckDefinition(meta, nil, time.Time{})))
 		return
 	}
 
-	// start tx
-	ctx := r.Context()
-	tx, err := h.db.BeginTx(ctx, nil)
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "could_not_start_tx"})
+	if err := h.service.UpdateCoupon(r.Context(), meta); err != nil {
+		writeAdminError(w, err, "failed_update_coupon")
 		return
 	}
-	defer func() {
-		_ = tx.Rollback()
-	}()
-
-	// insert coupon
-	insertCoupon := `
-		INSERT INTO coupons
-		(coupon_code, expiry_date, usage_type, min_order_value, valid_from, valid_to,
-		 discount_type, discount_value, max_usage_per_user, target_type, terms_and_conditions, created_at, updated_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,NOW(),NOW())
-		RETURNING id
-	`
-	var couponID int
-	err = tx.QueryRowContext(ctx, insertCoupon,
-		req.CouponCode,
-		expiry,
-		req.UsageType,
-		req.MinOrderValue,
-		validFrom,
-		validTo,
-		req.DiscountType,
-		req.DiscountValue,
-		req.MaxUsagePerUser,
-		req.TargetType,
-		req.Terms,
-	).Scan(&couponID)
-	if err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed_create_coupon"})
+	writeJSON(w, http.StatusOK, map[string]string{"message": "coupon_updated"})
+}
+
+// DeleteCoupon handles DELETE /admin/coupons/{code}
+func (h *CouponHandler) DeleteCoupon(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.DeleteCoupon(r.Context(), chi.URLParam(r, "code")); err != nil {
+		writeAdminError(w, err, "failed_delete_coupon")
 		return
 	}
+	writeJSON(w, http.StatusOK, map[string]string{"message": "coupon_deleted"})
+}
 
-	// insert items
-	if len(req.Items) > 0 {
-		stmt := `INSERT INTO coupon_applicable_items (coupon_id, medicine_id) VALUES ($1, $2)`
-		for _, mid := range req.Items {
-			if _, err := tx.ExecContext(ctx, stmt, couponID, mid); err != nil {
-				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed_create_items"})
-				return
-			}
-		}
+// GetCoupon handles GET /admin/coupons/{code}
+func (h *CouponHandler) GetCoupon(w http.ResponseWriter, r *http.Request) {
+	meta, err := h.service.GetCoupon(r.Context(), chi.URLParam(r, "code"))
+	if err != nil {
+		writeAdminError(w, err, "failed_get_coupon")
+		return
 	}
+	writeJSON(w, http.StatusOK, couponToRequest(meta))
+}
 
-	// insert categories
-	if len(req.Categories) > 0 {
-		stmt := `INSERT INTO coupon_applicable_categories (coupon_id, category_name) VALUES ($1, $2)`
-		for _, cat := range req.Categories {
-			if _, err := tx.ExecContext(ctx, stmt, couponID, cat); err != nil {
-				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed_create_categories"})
-				return
-			}
-		}
+// ListCoupons handles GET /admin/coupons?limit=&offset=
+func (h *CouponHandler) ListCoupons(w http.ResponseWriter, r *http.Request) {
+	limit, offset := 100, 0
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 1000 {
+		limit = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && v > 0 {
+		offset = v
 	}
 
-	if err := tx.Commit(); err != nil {
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "commit_failed"})
+	metas, err := h.service.ListCoupons(r.Context(), limit, offset)
+	if err != nil {
+		writeAdminError(w, err, "failed_list_coupons")
 		return
 	}
-
-	writeJSON(w, http.StatusCreated, map[string]interface{}{
-		"message":   "coupon_created",
-		"coupon_id": couponID,
-	})
+	out := make([]CreateCouponRequest, 0, len(metas))
+	for _, m := range metas {
+		out = append(out, couponToRequest(m))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"coupons": out, "limit": limit, "offset": offset})
 }
 
 // ValidateCoupon handles POST /coupons/validate
@@ -212,6 +313,7 @@ func (h *CouponHandler) ValidateCoupon(w http.ResponseWriter, r *http.Request) {
 		CouponCode: req.Coupon,
 		CartItems:  req.CartItems,
 		OrderTotal: req.OrderTotal,
+		User:       req.User,
 	}
 
 	// if timestamp provided parse it (override)
@@ -224,25 +326,29 @@ func (h *CouponHandler) ValidateCoupon(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 	resp, err := h.service.ValidateCoupon(ctx, vr)
-	if err != nil {
-		// internal error
-		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal_error", "detail": err.Error()})
+	writeValidation(w, resp, err)
+}
+
+// QuoteCoupon handles POST /coupons/quote
+// same body and response as /coupons/validate, but nothing is consumed;
+// an optional timestamp prices the cart at that time, like /coupons/applicable
+func (h *CouponHandler) QuoteCoupon(w http.ResponseWriter, r *http.Request) {
+	var req ValidateRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_body"})
 		return
 	}
 
-	if !resp.IsValid {
-		writeJSON(w, http.StatusOK, map[string]interface{}{
-			"is_valid": false,
-			"message":  resp.Message,
-		})
-		return
+	vr := models.ValidationRequest{
+		UserID:     req.UserID,
+		CouponCode: req.Coupon,
+		CartItems:  req.CartItems,
+		OrderTotal: req.OrderTotal,
+		User:       req.User,
 	}
 
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"is_valid": true,
-		"discount": resp.Discount,
-		"message":  resp.Message,
-	})
+	resp, err := h.service.QuoteCoupon(r.Context(), vr, requestTime(req.Timestamp))
+	writeValidation(w, resp, err)
 }
 
 // GetApplicableCoupons handles GET /coupons/applicable
@@ -292,107 +398,108 @@ func (h *CouponHandler) GetApplicableCoupons(w http.ResponseWriter, r *http.Requ
 	}
 
 	// timestamp parse
-	var now time.Time
-	if strings.TrimSpace(req.Timestamp) != "" {
-		if t, err := time.Parse(time.RFC3339, req.Timestamp); err == nil {
-			now = t.UTC()
-		} else {
-			now = time.Now().UTC()
-		}
-	} else {
-		now = time.Now().UTC()
-	}
+	now := requestTime(req.Timestamp)
 
-	// get all coupon codes (simple approach)
-	const allCouponsQ = `SELECT id, coupon_code, expiry_date, min_order_value, valid_from, valid_to, usage_type, max_usage_per_user FROM coupons`
-	rows, err := h.db.QueryContext(r.Context(), allCouponsQ)
+	vr := models.ValidationRequest{
+		UserID:     req.UserID,
+		CartItems:  req.CartItems,
+		OrderTotal: req.OrderTotal,
+		User:       req.User,
+	}
+	applicable, rejected, err := h.service.ApplicableCoupons(r.Context(), vr, now)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed_list_coupons"})
 		return
 	}
-	defer rows.Close()
-
-	applicable := []string{}
 
-	for rows.Next() {
-		var id int
-		var code string
-		var expiry time.Time
-		var minOrder float64
-		var validFrom sql.NullTime
-		var validTo sql.NullTime
-		var usageType string
-		var maxUsage sql.NullInt64
-
-		if err := rows.Scan(&id, &code, &expiry, &minOrder, &validFrom, &validTo, &usageType, &maxUsage); err != nil {
-			continue
-		}
+	resp := ApplicableResponse{ApplicableCoupons: make([]string, 0, len(applicable)), Coupons: applicable}
+	for _, c := range applicable {
+		resp.ApplicableCoupons = append(resp.ApplicableCoupons, c.CouponCode)
+	}
+	// ?explain=true also lists the rejected coupons with their reasons
+	if explain, _ := strconv.ParseBool(r.URL.Query().Get("explain")); explain {
+		resp.Rejected = rejected
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
 
-		// basic checks
-		if expiry.Before(now) {
-			continue
-		}
-		if minOrder > req.OrderTotal {
-			continue
-		}
-		if validFrom.Valid && validTo.Valid {
-			if now.Before(validFrom.Time) || now.After(validTo.Time) {
+// AssignUsers handles POST /admin/coupons/{code}/assignments
+// issues an assigned coupon to a bulk list of users; accepts JSON {"user_ids": [...]}
+// or a CSV body (text/csv) with the user id in the first column
+func (h *CouponHandler) AssignUsers(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	var userIDs []string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		cr := csv.NewReader(r.Body)
+		cr.FieldsPerRecord = -1
+		for {
+			rec, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_csv"})
+				return
+			}
+			uid := strings.TrimSpace(rec[0])
+			if uid == "" || uid == "user_id" { // skip blanks and the header row
 				continue
 			}
+			userIDs = append(userIDs, uid)
 		}
-
-		// check user usage count (non-locking read)
-		var usageCount int
-		ucQuery := `SELECT usage_count FROM coupon_usage WHERE coupon_id=$1 AND user_id=$2`
-		row := h.db.QueryRowContext(r.Context(), ucQuery, id, req.UserID)
-		switch err := row.Scan(&usageCount); err {
-		case nil:
-			// ok
-		case sql.ErrNoRows:
-			usageCount = 0
-		default:
-			// on error, be conservative and skip this coupon
-			continue
-		}
-		if maxUsage.Valid && int64(usageCount) >= maxUsage.Int64 {
-			continue
+	} else {
+		var req AssignUsersRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_body"})
+			return
 		}
-		if usageType == "one_time" && usageCount >= 1 {
-			continue
+		for _, uid := range req.UserIDs {
+			if uid = strings.TrimSpace(uid); uid != "" {
+				userIDs = append(userIDs, uid)
+			}
 		}
+	}
+	if len(userIDs) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "user_ids required"})
+		return
+	}
 
-		// fetch meta to test item/category applicability
-		meta, err := h.couponRepo.GetCouponMeta(r.Context(), code)
-		if err != nil || meta == nil {
-			continue
-		}
+	added, err := h.service.AssignUsers(r.Context(), code, userIDs)
+	if err != nil {
+		writeAdminError(w, err, "failed_assign_users")
+		return
+	}
 
-		// evaluate if any cart item matches rules (if coupon has restrictions)
-		applies := false
-		if len(meta.ApplicableItems) == 0 && len(meta.ApplicableCategories) == 0 {
-			// no restrictions => applies to whole cart
-			applies = true
-		} else {
-			itemSet := make(map[string]bool)
-			for _, iid := range meta.ApplicableItems {
-				itemSet[iid] = true
-			}
-			catSet := make(map[string]bool)
-			for _, c := range meta.ApplicableCategories {
-				catSet[c] = true
-			}
-			for _, it := range req.CartItems {
-				if itemSet[it.ID] || catSet[it.Category] {
-					applies = true
-					break
-				}
-			}
-		}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"message":  "users_assigned",
+		"received": len(userIDs),
+		"added":    added,
+	})
+}
 
-		if applies {
-			applicable = append(applicable, code)
+// WatchChanges keeps the service's cache and index in sync with coupon writes
+// made by other instances, via Postgres LISTEN on dsn. While it isn't
+// listening the cache is flushed periodically instead.
+func (h *CouponHandler) WatchChanges(dsn string) *cache.Listener {
+	refresh := func(code string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := h.service.CouponChanged(ctx, code); err != nil {
+			log.Printf("coupon refresh %s: %v", code, err)
+		}
+	}
+	flush := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := h.service.Flush(ctx); err != nil {
+			log.Printf("coupon flush: %v", err)
 		}
 	}
+	return cache.Listen(dsn, refresh, flush)
+}
 
-	writeJSON(w, http.StatusOK, ApplicableResponse{ApplicableCoupons: applicable})
+// CacheStats handles GET /admin/cache/stats
+func (h *CouponHandler) CacheStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.service.CacheStats())
 }
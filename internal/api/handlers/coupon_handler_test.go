@@ -0,0 +1,178 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/api"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/api/handlers"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository/memory"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/service"
+)
+
+// newRouter serves the full API on a fresh in-memory store
+func newRouter(t *testing.T) http.Handler {
+	t.Helper()
+	_, h := newStoreRouter(t)
+	return h
+}
+
+// newStoreRouter is newRouter that also returns the store, to set up data the
+// API refuses to write
+func newStoreRouter(t *testing.T) (*memory.Store, http.Handler) {
+	t.Helper()
+	store := memory.NewStore()
+	svc := service.NewCouponService(store, memory.NewCouponRepo(store), memory.NewUsageRepo(store),
+		memory.NewAssignmentRepo(store), memory.NewChildCodeRepo(store))
+	return store, api.NewRouterWith(handlers.NewCouponHandlerWith(svc))
+}
+
+// do sends a JSON body and decodes the JSON response into out when set
+func do(t *testing.T, h http.Handler, method, path, body string, out interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: decode %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func couponBody(code string, percent int) string {
+	expiry := time.Now().Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339)
+	return `{"coupon_code":"` + code + `","expiry_date":"` + expiry + `","usage_type":"multi_use",
+		"discount_type":"percentage","discount_value":` + strconv.Itoa(percent) + `,"max_usage_per_user":1,
+		"target_type":"inventory","applicable_categories":["painkillers"]}`
+}
+
+const cartBody = `{"user_id":"u1","coupon_code":"SAVE10","order_total":200,
+	"cart_items":[{"id":"med1","category":"painkillers","price":100,"qty":2}]}`
+
+func TestAdminCRUD(t *testing.T) {
+	h := newRouter(t)
+
+	if code := do(t, h, http.MethodPost, "/admin/coupons", couponBody("SAVE10", 10), nil); code != http.StatusCreated {
+		t.Fatalf("create: %d", code)
+	}
+	var errBody map[string]interface{}
+	if code := do(t, h, http.MethodPost, "/admin/coupons", couponBody("save-10", 10), &errBody); code != http.StatusConflict || errBody["error"] != "coupon_code_taken" {
+		t.Errorf("duplicate create: %d %v", code, errBody)
+	}
+	var invalid struct {
+		Error  string               `json:"error"`
+		Errors []service.FieldError `json:"errors"`
+	}
+	bad := strings.Replace(couponBody("BAD", 150), `"inventory"`, `"nowhere"`, 1)
+	if code := do(t, h, http.MethodPost, "/admin/coupons", bad, &invalid); code != http.StatusBadRequest || len(invalid.Errors) != 2 {
+		t.Errorf("invalid create: %d %+v", code, invalid)
+	}
+
+	var got handlers.CreateCouponRequest
+	if code := do(t, h, http.MethodGet, "/admin/coupons/save10", "", &got); code != http.StatusOK || got.CouponCode != "SAVE10" || got.DiscountValue != 10 {
+		t.Fatalf("get: %d %+v", code, got)
+	}
+
+	if code := do(t, h, http.MethodPut, "/admin/coupons/SAVE10", couponBody("ignored", 25), nil); code != http.StatusOK {
+		t.Fatalf("update: %d", code)
+	}
+	var list struct {
+		Coupons []handlers.CreateCouponRequest `json:"coupons"`
+	}
+	if code := do(t, h, http.MethodGet, "/admin/coupons", "", &list); code != http.StatusOK || len(list.Coupons) != 1 || list.Coupons[0].DiscountValue != 25 {
+		t.Errorf("list: %d %+v", code, list)
+	}
+
+	if code := do(t, h, http.MethodDelete, "/admin/coupons/SAVE10", "", nil); code != http.StatusOK {
+		t.Fatalf("delete: %d", code)
+	}
+	if code := do(t, h, http.MethodGet, "/admin/coupons/SAVE10", "", nil); code != http.StatusNotFound {
+		t.Errorf("get after delete: %d", code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	h := newRouter(t)
+	do(t, h, http.MethodPost, "/admin/coupons", couponBody("SAVE10", 10), nil)
+
+	var resp map[string]interface{}
+	if code := do(t, h, http.MethodPost, "/coupons/validate", cartBody, &resp); code != http.StatusOK || resp["is_valid"] != true || resp["discount"] != 20.0 {
+		t.Fatalf("validate: %d %v", code, resp)
+	}
+	resp = nil
+	if do(t, h, http.MethodPost, "/coupons/validate", cartBody, &resp); resp["is_valid"] != false || resp["message"] != "usage_limit_reached" {
+		t.Errorf("second validate: %v", resp)
+	}
+	resp = nil
+	unknown := strings.Replace(cartBody, "SAVE10", "NOPE", 1)
+	if do(t, h, http.MethodPost, "/coupons/validate", unknown, &resp); resp["message"] != "coupon_not_found" {
+		t.Errorf("unknown code: %v", resp)
+	}
+	if code := do(t, h, http.MethodPost, "/coupons/validate", "{", nil); code != http.StatusBadRequest {
+		t.Errorf("bad body: %d", code)
+	}
+}
+
+func TestApplicable(t *testing.T) {
+	h := newRouter(t)
+	do(t, h, http.MethodPost, "/admin/coupons", couponBody("PAIN10", 10), nil)
+	vitamins := strings.Replace(couponBody("VIT20", 20), "painkillers", "vitamins", 1)
+	do(t, h, http.MethodPost, "/admin/coupons", vitamins, nil)
+	bulk := strings.Replace(couponBody("BULK30", 30), `"max_usage_per_user"`, `"min_eligible_qty":10,"max_usage_per_user"`, 1)
+	do(t, h, http.MethodPost, "/admin/coupons", bulk, nil)
+
+	var resp handlers.ApplicableResponse
+	if code := do(t, h, http.MethodGet, "/coupons/applicable?explain=true", cartBody, &resp); code != http.StatusOK {
+		t.Fatalf("applicable: %d", code)
+	}
+	if len(resp.ApplicableCoupons) != 1 || resp.ApplicableCoupons[0] != "PAIN10" || resp.Coupons[0].Discount != 20 {
+		t.Errorf("applicable %+v", resp)
+	}
+	// VIT20 can't match the cart and is not evaluated at all
+	if len(resp.Rejected) != 1 || resp.Rejected[0].CouponCode != "BULK30" || resp.Rejected[0].Reason != "min_eligible_qty_not_met" {
+		t.Errorf("rejected %+v", resp.Rejected)
+	}
+
+	// the listing does not consume anything
+	var validate map[string]interface{}
+	body := strings.Replace(cartBody, "SAVE10", "PAIN10", 1)
+	if do(t, h, http.MethodPost, "/coupons/validate", body, &validate); validate["is_valid"] != true {
+		t.Errorf("validate after listing: %v", validate)
+	}
+}
+
+func TestCreateReportsPrefixConflictWithParseErrors(t *testing.T) {
+	h := newRouter(t)
+	template := strings.Replace(couponBody("SMS", 10), `"usage_type"`, `"is_template":true,"usage_type"`, 1)
+	if code := do(t, h, http.MethodPost, "/admin/coupons", template, nil); code != http.StatusCreated {
+		t.Fatalf("create template: %d", code)
+	}
+	if code := do(t, h, http.MethodPost, "/admin/coupons/SMS/codes", `{"count":1,"prefix":"SMS-"}`, nil); code != http.StatusCreated {
+		t.Fatalf("generate codes: %d", code)
+	}
+
+	// the bad date is a parse error; the prefix conflict must still be reported
+	body := couponBody("SMS-WINTER", 10)
+	body = body[:strings.Index(body, `"expiry_date":"`)] + `"expiry_date":"tomorrow",` + body[strings.Index(body, `"usage_type"`):]
+	var invalid struct {
+		Errors []service.FieldError `json:"errors"`
+	}
+	if code := do(t, h, http.MethodPost, "/admin/coupons", body, &invalid); code != http.StatusBadRequest {
+		t.Fatalf("create: %d", code)
+	}
+	fields := map[string]string{}
+	for _, fe := range invalid.Errors {
+		fields[fe.Field] = fe.Code
+	}
+	if fields["expiry_date"] != service.CodeInvalid || fields["coupon_code"] != service.CodeConflict {
+		t.Errorf("errors %+v, want expiry_date invalid and coupon_code conflict", invalid.Errors)
+	}
+}
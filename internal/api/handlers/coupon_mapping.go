@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/service"
+)
+
+// toMeta converts the request body to a coupon definition; it only parses the
+// wire format, the service validates the result. Dates that can't be parsed
+// are left unset and reported as field errors.
+func (req CreateCouponRequest) toMeta() (*models.CouponMeta, []service.FieldError) {
+	var errs []service.FieldError
+	badDate := func(field string) {
+		errs = append(errs, service.FieldError{Field: field, Code: service.CodeInvalid, Message: field + " must be RFC3339"})
+	}
+	var expiry time.Time
+	if req.ExpiryDate != "" {
+		t, err := time.Parse(time.RFC3339, req.ExpiryDate)
+		if err != nil {
+			badDate("expiry_date")
+		}
+		expiry = t
+	}
+	validFrom, err := parseTimeOrEmpty(req.ValidFrom)
+	if err != nil {
+		badDate("valid_from")
+	}
+	validTo, err := parseTimeOrEmpty(req.ValidTo)
+	if err != nil {
+		badDate("valid_to")
+	}
+
+	return &models.CouponMeta{
+		Coupon: models.Coupon{
+			CouponCode:          req.CouponCode,
+			ExpiryDate:          expiry,
+			UsageType:           req.UsageType,
+			MinOrderValue:       req.MinOrderValue,
+			MinEligibleQty:      req.MinEligibleQty,
+			MinEligibleSubtotal: req.MinEligibleSubtotal,
+			ValidFrom:           validFrom,
+			ValidTo:             validTo,
+			DiscountType:        req.DiscountType,
+			DiscountValue:       req.DiscountValue,
+			BuyQty:              req.BuyQty,
+			GetQty:              req.GetQty,
+			TierBasis:           req.TierBasis,
+			MaxUnitsDiscounted:  req.MaxUnitsDiscounted,
+			MaxUsagePerUser:     req.MaxUsagePerUser,
+			Audience:            req.Audience,
+			MinPriorOrders:      req.MinPriorOrders,
+			MaxPriorOrders:      req.MaxPriorOrders,
+			RuleExpr:            req.RuleExpression,
+			IsTemplate:          req.IsTemplate,
+			TargetType:          req.TargetType,
+			Terms:               req.Terms,
+		},
+		ApplicableItems:      req.Items,
+		ApplicableCategories: req.Categories,
+		RewardItems:          req.RewardItems,
+		RewardCategories:     req.RewardCategories,
+		Tiers:                req.Tiers,
+		Conditions:           req.Conditions,
+	}, errs
+}
+
+// definitionErrors completes the parse errors of toMeta with the errors of the
+// same service check the write would run, so every problem is reported at once
+func definitionErrors(parseErrs, checked []service.FieldError) []service.FieldError {
+	reported := make(map[string]bool, len(parseErrs))
+	for _, fe := range parseErrs {
+		reported[fe.Field] = true
+	}
+	errs := parseErrs
+	for _, fe := range checked {
+		if !reported[fe.Field] {
+			errs = append(errs, fe)
+		}
+	}
+	return errs
+}
+
+// couponToRequest renders a stored coupon in the create body format, so admin
+// reads can be edited and sent back
+func couponToRequest(m *models.CouponMeta) CreateCouponRequest {
+	req := CreateCouponRequest{
+		CouponCode:          m.CouponCode,
+		ExpiryDate:          m.ExpiryDate.UTC().Format(time.RFC3339),
+		UsageType:           m.UsageType,
+		MinOrderValue:       m.MinOrderValue,
+		MinEligibleQty:      m.MinEligibleQty,
+		MinEligibleSubtotal: m.MinEligibleSubtotal,
+		DiscountType:        m.DiscountType,
+		DiscountValue:       m.DiscountValue,
+		BuyQty:              m.BuyQty,
+		GetQty:              m.GetQty,
+		MaxUnitsDiscounted:  m.MaxUnitsDiscounted,
+		MaxUsagePerUser:     m.MaxUsagePerUser,
+		Audience:            m.Audience,
+		MinPriorOrders:      m.MinPriorOrders,
+		MaxPriorOrders:      m.MaxPriorOrders,
+		RuleExpression:      m.RuleExpr,
+		IsTemplate:          m.IsTemplate,
+		TargetType:          m.TargetType,
+		Terms:               m.Terms,
+		Items:               m.ApplicableItems,
+		Categories:          m.ApplicableCategories,
+		RewardItems:         m.RewardItems,
+		RewardCategories:    m.RewardCategories,
+		TierBasis:           m.TierBasis,
+		Tiers:               m.Tiers,
+		Conditions:          m.Conditions,
+	}
+	if m.ValidFrom != nil {
+		req.ValidFrom = m.ValidFrom.UTC().Format(time.RFC3339)
+	}
+	if m.ValidTo != nil {
+		req.ValidTo = m.ValidTo.UTC().Format(time.RFC3339)
+	}
+	return req
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/service"
+)
+
+const (
+	maxImportBytes = 32 << 20
+	listSeparator  = "|" // between ids and categories in a CSV cell
+)
+
+// csvColumn maps a CSV column to a field of the create body; the column names
+// are the JSON names, so both formats describe a coupon the same way
+type csvColumn struct {
+	name string
+	want string // what a value must look like, for errors
+	get  func(*CreateCouponRequest) string
+	set  func(*CreateCouponRequest, string) error
+}
+
+func column[T any](name, want string, field func(*CreateCouponRequest) *T, parse func(string) (T, error), format func(T) string) csvColumn {
+	return csvColumn{
+		name: name,
+		want: want,
+		get:  func(req *CreateCouponRequest) string { return format(*field(req)) },
+		set: func(req *CreateCouponRequest, s string) error {
+			v, err := parse(s)
+			if err != nil {
+				return err
+			}
+			*field(req) = v
+			return nil
+		},
+	}
+}
+
+func stringColumn(name string, field func(*CreateCouponRequest) *string) csvColumn {
+	return column(name, "text", field,
+		func(s string) (string, error) { return s, nil },
+		func(v string) string { return v })
+}
+
+func floatColumn(name string, field func(*CreateCouponRequest) *float64) csvColumn {
+	return column(name, "a number", field,
+		func(s string) (float64, error) { return strconv.ParseFloat(s, 64) },
+		func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) })
+}
+
+func intColumn(name string, field func(*CreateCouponRequest) *int) csvColumn {
+	return column(name, "an integer", field, strconv.Atoi, strconv.Itoa)
+}
+
+// optIntColumn leaves the field nil for an empty cell
+func optIntColumn(name string, field func(*CreateCouponRequest) **int) csvColumn {
+	return column(name, "an integer", field,
+		func(s string) (*int, error) {
+			v, err := strconv.Atoi(s)
+			return &v, err
+		},
+		func(v *int) string {
+			if v == nil {
+				return ""
+			}
+			return strconv.Itoa(*v)
+		})
+}
+
+func boolColumn(name string, field func(*CreateCouponRequest) *bool) csvColumn {
+	return column(name, "true or false", field, strconv.ParseBool,
+		func(v bool) string {
+			if !v {
+				return ""
+			}
+			return "true"
+		})
+}
+
+// listColumn holds ids or categories separated by listSeparator
+func listColumn(name string, field func(*CreateCouponRequest) *[]string) csvColumn {
+	return column(name, "a list", field,
+		func(s string) ([]string, error) {
+			var out []string
+			for _, v := range strings.Split(s, listSeparator) {
+				if v = strings.TrimSpace(v); v != "" {
+					out = append(out, v)
+				}
+			}
+			return out, nil
+		},
+		func(v []string) string { return strings.Join(v, listSeparator) })
+}
+
+// jsonColumn holds a nested structure, e.g. tiers, as JSON
+func jsonColumn[T any](name string, field func(*CreateCouponRequest) *[]T) csvColumn {
+	return column(name, "a JSON array", field,
+		func(s string) ([]T, error) {
+			var v []T
+			err := json.Unmarshal([]byte(s), &v)
+			return v, err
+		},
+		func(v []T) string {
+			if len(v) == 0 {
+				return ""
+			}
+			b, _ := json.Marshal(v)
+			return string(b)
+		})
+}
+
+// couponColumns is the CSV layout of import and export, in export order
+var couponColumns = []csvColumn{
+	stringColumn("coupon_code", func(r *CreateCouponRequest) *string { return &r.CouponCode }),
+	stringColumn("expiry_date", func(r *CreateCouponRequest) *string { return &r.ExpiryDate }),
+	stringColumn("usage_type", func(r *CreateCouponRequest) *string { return &r.UsageType }),
+	floatColumn("min_order_value", func(r *CreateCouponRequest) *float64 { return &r.MinOrderValue }),
+	intColumn("min_eligible_qty", func(r *CreateCouponRequest) *int { return &r.MinEligibleQty }),
+	floatColumn("min_eligible_subtotal", func(r *CreateCouponRequest) *float64 { return &r.MinEligibleSubtotal }),
+	stringColumn("valid_from", func(r *CreateCouponRequest) *string { return &r.ValidFrom }),
+	stringColumn("valid_to", func(r *CreateCouponRequest) *string { return &r.ValidTo }),
+	stringColumn("discount_type", func(r *CreateCouponRequest) *string { return &r.DiscountType }),
+	floatColumn("discount_value", func(r *CreateCouponRequest) *float64 { return &r.DiscountValue }),
+	intColumn("buy_quantity", func(r *CreateCouponRequest) *int { return &r.BuyQty }),
+	intColumn("get_quantity", func(r *CreateCouponRequest) *int { return &r.GetQty }),
+	intColumn("max_units_discounted", func(r *CreateCouponRequest) *int { return &r.MaxUnitsDiscounted }),
+	intColumn("max_usage_per_user", func(r *CreateCouponRequest) *int { return &r.MaxUsagePerUser }),
+	stringColumn("audience", func(r *CreateCouponRequest) *string { return &r.Audience }),
+	listColumn("assigned_user_ids", func(r *CreateCouponRequest) *[]string { return &r.AssignedUsers }),
+	optIntColumn("min_prior_orders", func(r *CreateCouponRequest) **int { return &r.MinPriorOrders }),
+	optIntColumn("max_prior_orders", func(r *CreateCouponRequest) **int { return &r.MaxPriorOrders }),
+	stringColumn("rule_expression", func(r *CreateCouponRequest) *string { return &r.RuleExpression }),
+	boolColumn("is_template", func(r *CreateCouponRequest) *bool { return &r.IsTemplate }),
+	stringColumn("target_type", func(r *CreateCouponRequest) *string { return &r.TargetType }),
+	stringColumn("terms_and_conditions", func(r *CreateCouponRequest) *string { return &r.Terms }),
+	listColumn("applicable_medicine_ids", func(r *CreateCouponRequest) *[]string { return &r.Items }),
+	listColumn("applicable_categories", func(r *CreateCouponRequest) *[]string { return &r.Categories }),
+	listColumn("reward_medicine_ids", func(r *CreateCouponRequest) *[]string { return &r.RewardItems }),
+	listColumn("reward_categories", func(r *CreateCouponRequest) *[]string { return &r.RewardCategories }),
+	stringColumn("tier_basis", func(r *CreateCouponRequest) *string { return &r.TierBasis }),
+	jsonColumn("tiers", func(r *CreateCouponRequest) *[]models.DiscountTier { return &r.Tiers }),
+	jsonColumn("eligibility_conditions", func(r *CreateCouponRequest) *[]models.EligibilityCondition { return &r.Conditions }),
+}
+
+// importFileError rejects an import file that can't be read row by row
+type importFileError struct {
+	code   string
+	detail string
+}
+
+func (e *importFileError) Error() string { return e.code + ": " + e.detail }
+
+// importRow turns a parsed row into a service import row
+func importRow(line int, req CreateCouponRequest, parseErrs []service.FieldError) service.ImportRow {
+	meta, errs := req.toMeta()
+	return service.ImportRow{Line: line, Coupon: meta, AssignedUsers: req.AssignedUsers, Errors: append(parseErrs, errs...)}
+}
+
+// readCSVImport reads a header row of column names followed by a coupon per
+// row; columns may be left out or in any order
+func readCSVImport(body io.Reader) ([]service.ImportRow, error) {
+	cr := csv.NewReader(body)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &importFileError{"invalid_csv", err.Error()}
+	}
+	byName := make(map[string]csvColumn, len(couponColumns))
+	for _, c := range couponColumns {
+		byName[c.name] = c
+	}
+	cols := make([]csvColumn, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff") // byte order mark of spreadsheet exports
+		}
+		c, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return nil, &importFileError{"unknown_column", name}
+		}
+		cols[i] = c
+	}
+
+	var rows []service.ImportRow
+	for len(rows) <= service.MaxImportRows {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, &importFileError{"invalid_csv", err.Error()}
+		}
+		line, _ := cr.FieldPos(0)
+
+		var req CreateCouponRequest
+		var errs []service.FieldError
+		for i, v := range rec {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			if err := cols[i].set(&req, v); err != nil {
+				errs = append(errs, service.FieldError{Field: cols[i].name, Code: service.CodeInvalid, Message: cols[i].name + " must be " + cols[i].want})
+			}
+		}
+		rows = append(rows, importRow(line, req, errs))
+	}
+	return rows, nil
+}
+
+// readNDJSONImport reads a create body per line; blank lines are skipped
+func readNDJSONImport(body io.Reader) ([]service.ImportRow, error) {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	var rows []service.ImportRow
+	for line := 1; sc.Scan() && len(rows) <= service.MaxImportRows; line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		var req CreateCouponRequest
+		if err := json.Unmarshal([]byte(text), &req); err != nil {
+			rows = append(rows, service.ImportRow{Line: line, Errors: []service.FieldError{{
+				Field: "line", Code: service.CodeInvalid, Message: "line must be a JSON coupon: " + err.Error(),
+			}}})
+			continue
+		}
+		rows = append(rows, importRow(line, req, nil))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, &importFileError{"invalid_body", err.Error()}
+	}
+	return rows, nil
+}
+
+// ImportCoupons handles POST /admin/coupons/import?dry_run=
+// creates coupons in bulk from CSV (text/csv; a header row of the create body
+// field names, ids and categories separated by "|", tiers and conditions as
+// JSON) or NDJSON (a create body per line). Either every coupon is created or
+// none; every invalid row is reported. dry_run=true only checks the rows.
+func (h *CouponHandler) ImportCoupons(w http.ResponseWriter, r *http.Request) {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	var rows []service.ImportRow
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		rows, err = readCSVImport(body)
+	} else {
+		rows, err = readNDJSONImport(body)
+	}
+	var fileErr *importFileError
+	if errors.As(err, &fileErr) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fileErr.code, "detail": fileErr.detail})
+		return
+	}
+	if len(rows) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "coupons required"})
+		return
+	}
+
+	valid, err := h.service.ImportCoupons(r.Context(), rows, dryRun)
+	var invalid *service.InvalidImportError
+	switch {
+	case errors.As(err, &invalid):
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":   "invalid_import",
+			"dry_run": dryRun,
+			"valid":   valid,
+			"invalid": len(invalid.Rows),
+			"rows":    invalid.Rows,
+		})
+	case err != nil:
+		writeAdminError(w, err, "failed_import_coupons")
+	case dryRun:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"message": "import_valid", "dry_run": true, "valid": valid})
+	default:
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"message": "coupons_imported", "imported": valid})
+	}
+}
+
+// ExportCoupons handles GET /admin/coupons/export?format=csv|ndjson&active=1
+// streams every coupon with its assigned users in the import format (default
+// ndjson). With active=1 expired coupons are left out, so the file can be
+// imported again as is. Generated codes are not included.
+func (h *CouponHandler) ExportCoupons(w http.ResponseWriter, r *http.Request) {
+	var writeRow func(CreateCouponRequest) error
+	var flush func() error
+	switch format := r.URL.Query().Get("format"); format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="coupons.csv"`)
+		cw := csv.NewWriter(w)
+		header := make([]string, len(couponColumns))
+		for i, c := range couponColumns {
+			header[i] = c.name
+		}
+		_ = cw.Write(header)
+		writeRow = func(req CreateCouponRequest) error {
+			rec := make([]string, len(couponColumns))
+			for i, c := range couponColumns {
+				rec[i] = c.get(&req)
+			}
+			return cw.Write(rec)
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case "", "ndjson":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="coupons.ndjson"`)
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		writeRow = func(req CreateCouponRequest) error { return enc.Encode(req) }
+		flush = bw.Flush
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown format %q; use csv or ndjson", format)})
+		return
+	}
+
+	var activeAt time.Time
+	if active, _ := strconv.ParseBool(r.URL.Query().Get("active")); active {
+		activeAt = time.Now()
+	}
+
+	flusher, _ := w.(http.Flusher)
+	rows := 0
+	err := h.service.EachCoupon(r.Context(), activeAt, func(m *models.CouponMeta, assignedUsers []string) error {
+		req := couponToRequest(m)
+		req.AssignedUsers = assignedUsers
+		if err := writeRow(req); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	switch {
+	case err != nil && rows == 0:
+		// nothing is sent yet
+		w.Header().Del("Content-Disposition")
+		writeAdminError(w, err, "failed_export_coupons")
+	case err != nil:
+		// the status is sent already; a truncated file is all we can signal
+		log.Printf("export coupons after %d rows: %v", rows, err)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository/memory"
+)
+
+// send is do for a raw body with its content type; it returns the response
+func send(h http.Handler, method, path, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	formats := map[string]string{"ndjson": "application/x-ndjson", "csv": "text/csv"}
+	for format, contentType := range formats {
+		t.Run(format, func(t *testing.T) {
+			store, src := newStoreRouter(t)
+			do(t, src, http.MethodPost, "/admin/coupons", couponBody("SAVE10", 10), nil)
+			do(t, src, http.MethodPost, "/admin/coupons", strings.Replace(couponBody("PAIN20", 20), `"max_usage_per_user"`, `"min_eligible_qty":2,"max_usage_per_user"`, 1), nil)
+			do(t, src, http.MethodPost, "/admin/coupons", strings.Replace(couponBody("VIP", 30), `"max_usage_per_user"`, `"audience":"assigned","assigned_user_ids":["v2","v1"],"max_usage_per_user"`, 1), nil)
+			memory.NewCouponRepo(store).Put(&models.CouponMeta{Coupon: models.Coupon{
+				CouponCode: "OLD", ExpiryDate: time.Now().Add(-time.Hour), UsageType: "multi_use",
+				DiscountType: "flat", DiscountValue: 5, TargetType: "inventory",
+			}})
+
+			export := send(src, http.MethodGet, "/admin/coupons/export?active=1&format="+format, "", "")
+			if export.Code != http.StatusOK {
+				t.Fatalf("export: %d %s", export.Code, export.Body)
+			}
+			if strings.Contains(export.Body.String(), "OLD") {
+				t.Errorf("expired coupon exported:\n%s", export.Body)
+			}
+
+			dstStore, dst := newStoreRouter(t)
+			imported := send(dst, http.MethodPost, "/admin/coupons/import", contentType, export.Body.String())
+			var resp map[string]interface{}
+			_ = json.Unmarshal(imported.Body.Bytes(), &resp)
+			if imported.Code != http.StatusCreated || resp["imported"] != 3.0 {
+				t.Fatalf("import: %d %v", imported.Code, resp)
+			}
+			var got struct {
+				MinEligibleQty int `json:"min_eligible_qty"`
+			}
+			if code := do(t, dst, http.MethodGet, "/admin/coupons/PAIN20", "", &got); code != http.StatusOK || got.MinEligibleQty != 2 {
+				t.Errorf("imported PAIN20: %d %+v", code, got)
+			}
+			vip, err := memory.NewCouponRepo(dstStore).GetCouponMeta(t.Context(), "VIP")
+			if err != nil || vip == nil {
+				t.Fatalf("imported VIP: %v %v", vip, err)
+			}
+			for _, user := range []string{"v1", "v2"} {
+				if ok, _ := memory.NewAssignmentRepo(dstStore).IsAssigned(t.Context(), vip.ID, user); !ok {
+					t.Errorf("VIP not assigned to %s after import", user)
+				}
+			}
+
+			// the same file again: every code is taken now
+			again := send(dst, http.MethodPost, "/admin/coupons/import", contentType, export.Body.String())
+			var invalid struct {
+				Rows []json.RawMessage `json:"rows"`
+			}
+			_ = json.Unmarshal(again.Body.Bytes(), &invalid)
+			if again.Code != http.StatusBadRequest || len(invalid.Rows) != 3 {
+				t.Errorf("second import: %d %s", again.Code, again.Body)
+			}
+		})
+	}
+}
+
+func TestExportIncludesExpired(t *testing.T) {
+	store, h := newStoreRouter(t)
+	do(t, h, http.MethodPost, "/admin/coupons", couponBody("SAVE10", 10), nil)
+	memory.NewCouponRepo(store).Put(&models.CouponMeta{Coupon: models.Coupon{
+		CouponCode: "OLD", ExpiryDate: time.Now().Add(-time.Hour), UsageType: "multi_use",
+		DiscountType: "flat", DiscountValue: 5, TargetType: "inventory",
+	}})
+
+	export := send(h, http.MethodGet, "/admin/coupons/export", "", "")
+	var codes []string
+	for _, line := range strings.Split(strings.TrimSpace(export.Body.String()), "\n") {
+		var row struct {
+			CouponCode string `json:"coupon_code"`
+		}
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatalf("export line %q: %v", line, err)
+		}
+		codes = append(codes, row.CouponCode)
+	}
+	if strings.Join(codes, ",") != "SAVE10,OLD" {
+		t.Errorf("exported %v, want SAVE10,OLD", codes)
+	}
+}
@@ -2,29 +2,55 @@ package api
 
 import (
 	"database/sql"
+	"expvar"
 	"net/http"
 
 	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/api/handlers"
 	"github.com/go-chi/chi/v5"
 )
 
-// NewRouter builds the HTTP router for the coupon-service
-func NewRouter(db *sql.DB) http.Handler {
-	r := chi.NewRouter()
-
+// NewRouter builds the HTTP router for the coupon-service.
+// When dsn is set, coupon changes from other instances are picked up via LISTEN.
+func NewRouter(db *sql.DB, dsn string) http.Handler {
 	couponHandler := handlers.NewCouponHandler(db)
+	if dsn != "" {
+		// the listener lives as long as the process and retries in the
+		// background if it can't start
+		couponHandler.WatchChanges(dsn)
+	}
+	return NewRouterWith(couponHandler)
+}
+
+// NewRouterWith builds the HTTP router around an already wired handler,
+// e.g. one backed by the in-memory store in tests
+func NewRouterWith(couponHandler *handlers.CouponHandler) http.Handler {
+	r := chi.NewRouter()
 
 	// Public coupon endpoints
 	r.Route("/coupons", func(r chi.Router) {
 		r.Get("/applicable", couponHandler.GetApplicableCoupons)
 		r.Post("/validate", couponHandler.ValidateCoupon)
+		r.Post("/quote", couponHandler.QuoteCoupon)
 	})
 
 	// Admin endpoints
 	r.Route("/admin", func(r chi.Router) {
 		r.Post("/coupons", couponHandler.CreateCoupon)
+		r.Get("/coupons", couponHandler.ListCoupons)
+		r.Post("/coupons/import", couponHandler.ImportCoupons)
+		r.Get("/coupons/export", couponHandler.ExportCoupons)
+		r.Get("/coupons/{code}", couponHandler.GetCoupon)
+		r.Put("/coupons/{code}", couponHandler.UpdateCoupon)
+		r.Delete("/coupons/{code}", couponHandler.DeleteCoupon)
+		r.Post("/coupons/{code}/assignments", couponHandler.AssignUsers)
+		r.Post("/coupons/{code}/codes", couponHandler.GenerateCodes)
+		r.Get("/coupons/{code}/codes/export", couponHandler.ExportCodes)
+		r.Get("/cache/stats", couponHandler.CacheStats)
 	})
 
+	// expvar metrics, including usage transaction retries
+	r.Handle("/debug/vars", expvar.Handler())
+
 	// health
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
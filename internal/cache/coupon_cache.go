@@ -1,27 +1,160 @@
 package cache
 
-import "sync"
+import (
+	"container/list"
+	"sync"
+	"time"
 
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// CouponCache is a concurrency-safe LRU cache of coupon metadata keyed by code.
+// Entries expire after ttl; when full the least recently used entry is evicted.
+// Codes known not to exist can be cached too (negative entries, nil meta).
 type CouponCache struct {
-	mu    sync.RWMutex
-	store map[string]interface{}
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	ll         *list.List // front = most recently used
+	items      map[string]*list.Element
+	stats      Stats
+	gen        uint64 // bumped on every invalidation, see Generation
+}
+
+type entry struct {
+	code      string
+	meta      *models.CouponMeta // nil = coupon doesn't exist
+	expiresAt time.Time
 }
 
-func NewCouponCache() *CouponCache {
+// Stats are cumulative counters plus the current size
+type Stats struct {
+	Hits          uint64 `json:"hits"`
+	NegativeHits  uint64 `json:"negative_hits"` // hits on a cached "not found"
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`     // dropped to stay within maxEntries
+	Expirations   uint64 `json:"expirations"`   // found past their ttl
+	Invalidations uint64 `json:"invalidations"` // removed by Invalidate or Purge
+	Size          int    `json:"size"`
+}
+
+// NewCouponCache creates a cache holding at most maxEntries coupons for ttl each.
+// maxEntries <= 0 means no size bound, ttl <= 0 means entries don't expire.
+func NewCouponCache(maxEntries int, ttl time.Duration) *CouponCache {
 	return &CouponCache{
-		store: make(map[string]interface{}),
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get returns the cached coupon for code if present and not expired.
+// ok with a nil meta means the code is cached as not found.
+func (c *CouponCache) Get(code string) (meta *models.CouponMeta, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[code]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !e.expiresAt.IsZero() && !time.Now().Before(e.expiresAt) {
+		c.removeElement(el)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	if e.meta == nil {
+		c.stats.NegativeHits++
+	} else {
+		c.stats.Hits++
+	}
+	return e.meta, true
+}
+
+// Generation returns a counter that changes whenever entries are invalidated.
+// Take it before loading from the database and pass it to Set/SetNotFound so a
+// load that raced with an invalidation doesn't re-insert stale data.
+func (c *CouponCache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// Set stores meta under code, replacing any previous entry, unless the cache
+// was invalidated after gen was taken
+func (c *CouponCache) Set(code string, meta *models.CouponMeta, gen uint64) {
+	c.set(code, meta, c.ttl, gen)
+}
+
+// SetNotFound caches that code doesn't exist for ttl, unless the cache was
+// invalidated after gen was taken
+func (c *CouponCache) SetNotFound(code string, ttl time.Duration, gen uint64) {
+	c.set(code, nil, ttl, gen)
+}
+
+func (c *CouponCache) set(code string, meta *models.CouponMeta, ttl time.Duration, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	if el, ok := c.items[code]; ok {
+		e := el.Value.(*entry)
+		e.meta, e.expiresAt = meta, expiresAt
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[code] = c.ll.PushFront(&entry{code: code, meta: meta, expiresAt: expiresAt})
+	if c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
 	}
 }
 
-func (c *CouponCache) Get(key string) (interface{}, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	val, ok := c.store[key]
-	return val, ok
+// Invalidate drops code from the cache; unknown codes are ignored
+func (c *CouponCache) Invalidate(code string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if el, ok := c.items[code]; ok {
+		c.removeElement(el)
+		c.stats.Invalidations++
+	}
+}
+
+// Purge drops every entry
+func (c *CouponCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.stats.Invalidations += uint64(c.ll.Len())
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
 }
 
-func (c *CouponCache) Set(key string, value interface{}) {
+// Stats returns a snapshot of the counters
+func (c *CouponCache) Stats() Stats {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.store[key] = value
+
+	s := c.stats
+	s.Size = c.ll.Len()
+	return s
+}
+
+func (c *CouponCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry).code)
 }
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+func meta(id int) *models.CouponMeta {
+	return &models.CouponMeta{Coupon: models.Coupon{ID: id}}
+}
+
+func cachedID(t *testing.T, c *CouponCache, code string) int {
+	t.Helper()
+	m, ok := c.Get(code)
+	switch {
+	case !ok:
+		return 0
+	case m == nil:
+		return -1
+	}
+	return m.ID
+}
+
+func TestCouponCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCouponCache(3, 0)
+	for i, code := range []string{"A", "B", "C"} {
+		c.Set(code, meta(i+1), c.Generation())
+	}
+	c.Get("A")                                    // a hit counts as a use: B goes first
+	c.Set("D", meta(4), c.Generation())           // D, A, C
+	c.Set("C", meta(30), c.Generation())          // so does replacing: A goes next
+	c.SetNotFound("E", time.Hour, c.Generation()) // negative entries take room too
+
+	want := map[string]int{"A": 0, "B": 0, "C": 30, "D": 4, "E": -1}
+	for code, id := range want {
+		if got := cachedID(t, c, code); got != id {
+			t.Errorf("%s: %d, want %d", code, got, id)
+		}
+	}
+	if s := c.Stats(); s.Evictions != 2 || s.Size != 3 {
+		t.Errorf("stats %+v, want 2 evictions and size 3", s)
+	}
+}
+
+func TestCouponCacheUnbounded(t *testing.T) {
+	c := NewCouponCache(0, 0)
+	for i := range 1000 {
+		c.Set(string(rune('a'+i%26))+string(rune(i)), meta(i), c.Generation())
+	}
+	if s := c.Stats(); s.Size != 1000 || s.Evictions != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestCouponCacheExpiry(t *testing.T) {
+	const ttl = 20 * time.Millisecond
+	c := NewCouponCache(10, ttl)
+	c.Set("A", meta(1), c.Generation())
+	c.SetNotFound("GONE", 5*ttl, c.Generation()) // negative entries have their own ttl
+
+	if got := cachedID(t, c, "A"); got != 1 {
+		t.Fatalf("A: %d before expiry", got)
+	}
+	time.Sleep(2 * ttl)
+	if got := cachedID(t, c, "A"); got != 0 {
+		t.Errorf("A: %d after expiry", got)
+	}
+	if got := cachedID(t, c, "GONE"); got != -1 {
+		t.Errorf("GONE: %d, want the negative entry", got)
+	}
+	if s := c.Stats(); s.Expirations != 1 || s.Size != 1 {
+		t.Errorf("stats %+v, want 1 expiration and size 1", s)
+	}
+
+	forever := NewCouponCache(10, 0)
+	forever.Set("A", meta(1), forever.Generation())
+	time.Sleep(2 * ttl)
+	if got := cachedID(t, forever, "A"); got != 1 {
+		t.Errorf("entry without ttl: %d", got)
+	}
+}
+
+func TestCouponCacheNegativeEntries(t *testing.T) {
+	c := NewCouponCache(10, time.Hour)
+	c.SetNotFound("NEW", time.Hour, c.Generation())
+	if m, ok := c.Get("NEW"); !ok || m != nil {
+		t.Fatalf("Get: %v, %v; want a cached not found", m, ok)
+	}
+	// creating the coupon replaces the negative entry
+	c.Set("NEW", meta(1), c.Generation())
+	if got := cachedID(t, c, "NEW"); got != 1 {
+		t.Errorf("after Set: %d", got)
+	}
+	c.Get("MISSING")
+	if s := c.Stats(); s.NegativeHits != 1 || s.Hits != 1 || s.Misses != 1 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+// A load that began before an invalidation must not store what it read
+func TestCouponCacheGenerationGuard(t *testing.T) {
+	for name, invalidate := range map[string]func(c *CouponCache){
+		"Invalidate of the code":       func(c *CouponCache) { c.Invalidate("A") },
+		"Invalidate of another code":   func(c *CouponCache) { c.Invalidate("B") },
+		"Purge":                        func(c *CouponCache) { c.Purge() },
+		"Invalidate of an absent code": func(c *CouponCache) { c.Invalidate("NONE") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := NewCouponCache(10, time.Hour)
+			c.Set("A", meta(1), c.Generation())
+
+			stale := c.Generation()
+			invalidate(c)
+			c.Set("A", meta(2), stale)
+			c.SetNotFound("A", time.Hour, stale)
+			if got := cachedID(t, c, "A"); got == 2 || got == -1 {
+				t.Errorf("stale fill stored: %d", got)
+			}
+
+			c.Set("A", meta(3), c.Generation())
+			if got := cachedID(t, c, "A"); got != 3 {
+				t.Errorf("fresh fill: %d", got)
+			}
+		})
+	}
+}
+
+// run with -race. A writer keeps publishing new versions of a coupon and
+// invalidating it while loaders fill the cache the way the service does: take
+// the generation, read the current version, store it. Once a version is
+// invalidated, no older one may be cached.
+func TestCouponCacheConcurrentFills(t *testing.T) {
+	c := NewCouponCache(4, time.Hour)
+	var version atomic.Int64
+	version.Store(1)
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, ok := c.Get("A"); ok {
+					continue
+				}
+				gen := c.Generation()
+				c.Set("A", meta(int(version.Load())), gen)
+				c.Set("OTHER", meta(0), gen) // churn in the LRU list
+			}
+		}()
+	}
+
+	for v := int64(2); v <= 2000; v++ {
+		version.Store(v)
+		if v%100 == 0 {
+			c.Purge()
+		} else {
+			c.Invalidate("A")
+		}
+		if got := cachedID(t, c, "A"); got != 0 && int64(got) < v {
+			t.Errorf("version %d cached after %d was invalidated", got, v)
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
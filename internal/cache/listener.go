@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ChangeChannel is the Postgres channel coupon writes NOTIFY on (see migration
+// 000010); the payload is the coupon code
+const ChangeChannel = "coupon_changed"
+
+// DisconnectedFlushInterval is how often everything cached is dropped while
+// changes can't be heard, and how often a failed LISTEN is retried. It bounds
+// how stale a coupon can get in the meantime.
+const DisconnectedFlushInterval = 30 * time.Second
+
+// Listener LISTENs for coupon changes made by any instance and forwards them.
+//
+// onChange gets the code of each changed coupon. onReset is called whenever
+// notifications may have been missed - the connection dropped or was
+// re-established - and must drop everything cached. Until LISTEN is active,
+// and while the connection is down, onReset is also called every
+// DisconnectedFlushInterval.
+// pq.Listener reconnects on its own with backoff.
+type Listener struct {
+	l          conn
+	onChange   func(code string)
+	onReset    func()
+	flushEvery time.Duration
+	resets     chan struct{}
+	done       chan struct{}
+
+	mu      sync.Mutex
+	started bool // LISTEN was issued; pq re-issues it on reconnect
+	down    bool // disconnected and not reconnected yet
+}
+
+// conn is the part of pq.Listener the Listener uses
+type conn interface {
+	Listen(channel string) error
+	NotificationChannel() <-chan *pq.Notification
+	Ping() error
+	Close() error
+}
+
+// Listen starts listening on dsn in the background and forwards notifications
+// until Close. It doesn't fail: until LISTEN succeeds it is retried, and the
+// cache flushed, every DisconnectedFlushInterval.
+func Listen(dsn string, onChange func(code string), onReset func()) *Listener {
+	dial := func(event pq.EventCallbackType) conn {
+		return pq.NewListener(dsn, time.Second, time.Minute, event)
+	}
+	return listen(dial, onChange, onReset, DisconnectedFlushInterval)
+}
+
+func listen(dial func(pq.EventCallbackType) conn, onChange func(code string), onReset func(), flushEvery time.Duration) *Listener {
+	ln := &Listener{
+		onChange:   onChange,
+		onReset:    onReset,
+		flushEvery: flushEvery,
+		resets:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	ln.l = dial(ln.event)
+	go ln.start()
+	go ln.run()
+	return ln
+}
+
+// Listening reports whether changes are currently being received
+func (ln *Listener) Listening() bool {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	return ln.started && !ln.down
+}
+
+func (ln *Listener) setDown(down bool) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	ln.down = down
+}
+
+// Close stops listening and closes the connection
+func (ln *Listener) Close() error {
+	close(ln.done)
+	return ln.l.Close()
+}
+
+// start issues LISTEN, which waits for the first connection, retrying errors
+func (ln *Listener) start() {
+	for {
+		err := ln.l.Listen(ChangeChannel)
+		if err == nil {
+			break
+		}
+		select {
+		case <-ln.done:
+			return
+		default:
+		}
+		log.Printf("coupon listener not started, retrying in %v: %v", ln.flushEvery, err)
+		select {
+		case <-ln.done:
+			return
+		case <-time.After(ln.flushEvery):
+		}
+	}
+	ln.mu.Lock()
+	ln.started = true
+	ln.mu.Unlock()
+	// whatever was cached before LISTEN may have changed unheard
+	ln.reset()
+}
+
+// reset asks run to call onReset
+func (ln *Listener) reset() {
+	select {
+	case ln.resets <- struct{}{}:
+	default:
+	}
+}
+
+// event runs on pq's goroutine and must not block
+func (ln *Listener) event(ev pq.ListenerEventType, err error) {
+	switch ev {
+	case pq.ListenerEventDisconnected:
+		log.Printf("coupon listener disconnected: %v", err)
+		// writes made while disconnected won't be delivered
+		ln.setDown(true)
+		ln.reset()
+	case pq.ListenerEventConnectionAttemptFailed:
+		log.Printf("coupon listener reconnect failed: %v", err)
+	case pq.ListenerEventReconnected:
+		// pq has re-issued LISTEN; the nil notification that follows resets
+		log.Printf("coupon listener reconnected")
+		ln.setDown(false)
+	}
+}
+
+func (ln *Listener) run() {
+	// an idle connection may be dead without pq noticing; ping to find out
+	idle := time.NewTicker(90 * time.Second)
+	defer idle.Stop()
+	flush := time.NewTicker(ln.flushEvery)
+	defer flush.Stop()
+
+	for {
+		select {
+		case <-ln.done:
+			return
+		case <-ln.resets:
+			ln.onReset()
+		case n, ok := <-ln.l.NotificationChannel():
+			if !ok {
+				return
+			}
+			if n == nil {
+				// pq sends nil after re-establishing the connection
+				ln.onReset()
+				continue
+			}
+			ln.onChange(n.Extra)
+		case <-flush.C:
+			if !ln.Listening() {
+				ln.onReset()
+			}
+		case <-idle.C:
+			go ln.l.Ping()
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// fakeConn stands in for pq.Listener. Listen blocks until the test sends its
+// result on listen; events are raised with the callback the Listener passed.
+type fakeConn struct {
+	event  pq.EventCallbackType
+	listen chan error
+	notify chan *pq.Notification
+}
+
+func (f *fakeConn) NotificationChannel() <-chan *pq.Notification { return f.notify }
+func (f *fakeConn) Ping() error                                  { return nil }
+func (f *fakeConn) Close() error                                 { close(f.listen); return nil }
+
+func (f *fakeConn) Listen(string) error {
+	err, ok := <-f.listen
+	if !ok {
+		return errors.New("listener closed")
+	}
+	return err
+}
+
+const testFlushEvery = 10 * time.Millisecond
+
+type recorder struct {
+	resets  atomic.Int64
+	mu      sync.Mutex
+	changes []string
+}
+
+func startListener(t *testing.T) (*Listener, *fakeConn, *recorder) {
+	t.Helper()
+	f := &fakeConn{listen: make(chan error), notify: make(chan *pq.Notification)}
+	rec := &recorder{}
+	ln := listen(func(event pq.EventCallbackType) conn {
+		f.event = event
+		return f
+	}, func(code string) {
+		rec.mu.Lock()
+		rec.changes = append(rec.changes, code)
+		rec.mu.Unlock()
+	}, func() { rec.resets.Add(1) }, testFlushEvery)
+	return ln, f, rec
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// resetsStopped reports whether onReset stays quiet for several flush intervals;
+// one reset may already have been queued
+func resetsStopped(rec *recorder) bool {
+	before := rec.resets.Load()
+	time.Sleep(5 * testFlushEvery)
+	return rec.resets.Load()-before <= 1
+}
+
+func TestListenerFlushesUntilListening(t *testing.T) {
+	ln, f, rec := startListener(t)
+	defer ln.Close()
+
+	// LISTEN fails at first: it is retried and the cache flushed meanwhile
+	f.listen <- errors.New("permission denied for channel")
+	waitFor(t, "periodic flushes", func() bool { return rec.resets.Load() >= 3 })
+	if ln.Listening() {
+		t.Fatal("listening before LISTEN succeeded")
+	}
+
+	f.listen <- nil
+	waitFor(t, "listening", ln.Listening)
+	if !resetsStopped(rec) {
+		t.Error("still flushing while listening")
+	}
+
+	f.notify <- &pq.Notification{Channel: ChangeChannel, Extra: "SAVE10"}
+	waitFor(t, "the change", func() bool {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.changes) == 1 && rec.changes[0] == "SAVE10"
+	})
+}
+
+func TestListenerReconnect(t *testing.T) {
+	ln, f, rec := startListener(t)
+	defer ln.Close()
+	f.listen <- nil
+	waitFor(t, "listening", ln.Listening)
+	waitFor(t, "the reset after LISTEN", func() bool { return rec.resets.Load() >= 1 })
+	resetsStopped(rec)
+
+	// dropped: flush at once, then periodically until pq reconnects
+	before := rec.resets.Load()
+	f.event(pq.ListenerEventDisconnected, errors.New("connection reset"))
+	if ln.Listening() {
+		t.Error("listening after the disconnect")
+	}
+	waitFor(t, "flushes while disconnected", func() bool { return rec.resets.Load() >= before+3 })
+
+	f.event(pq.ListenerEventReconnected, nil)
+	before = rec.resets.Load()
+	f.notify <- nil // pq's reconnect marker
+	waitFor(t, "the reset after reconnecting", func() bool { return rec.resets.Load() > before })
+	if !ln.Listening() {
+		t.Error("not listening after the reconnect")
+	}
+	if !resetsStopped(rec) {
+		t.Error("still flushing after the reconnect")
+	}
+}
+
+// a drop while LISTEN is being issued leaves the listener flushing until pq
+// reports the reconnect
+func TestListenerDropDuringStart(t *testing.T) {
+	ln, f, rec := startListener(t)
+	defer ln.Close()
+	f.event(pq.ListenerEventDisconnected, errors.New("connection reset"))
+	f.listen <- nil
+	waitFor(t, "the reset after LISTEN", func() bool { return rec.resets.Load() >= 1 })
+	if ln.Listening() {
+		t.Error("listening although the connection dropped")
+	}
+
+	f.event(pq.ListenerEventReconnected, nil)
+	if !ln.Listening() {
+		t.Error("not listening after the reconnect")
+	}
+}
+
+func TestListenerCloseWhileStarting(t *testing.T) {
+	ln, _, rec := startListener(t)
+	ln.Close()
+	before := rec.resets.Load()
+	time.Sleep(5 * testFlushEvery)
+	if rec.resets.Load() != before {
+		t.Error("flushing after Close")
+	}
+}
@@ -1 +1,281 @@
 package concurrency
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/expr"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// Result is the outcome of one rule. Reason is the rejection message returned to
+// callers (e.g. coupon_expired); Err is set when the rule could not be evaluated.
+type Result struct {
+	Pass   bool
+	Rule   string
+	Reason string
+	Err    error
+}
+
+// Pass is the result of a rule that holds
+var Pass = Result{Pass: true}
+
+func fail(reason string) Result {
+	return Result{Reason: reason}
+}
+
+// Cart is the cart side of an evaluation
+type Cart struct {
+	Items      []models.CartItem
+	OrderTotal float64
+	Now        time.Time
+
+	env map[string]interface{} // rule expression env, built once per cart
+}
+
+// UserFacts answers per-user questions that need the database. Implementations
+// are expected to look things up lazily so rules that reject early cost nothing.
+type UserFacts interface {
+	UsageCount(ctx context.Context, couponID int) (int, error)
+	IsAssigned(ctx context.Context, couponID int) (bool, error)
+	PriorOrders(ctx context.Context) (int, error)
+}
+
+// User is the user side of an evaluation: caller-supplied context plus looked-up facts
+type User struct {
+	ID      string
+	Context models.UserContext
+	Facts   UserFacts
+}
+
+// Rule is one eligibility check run against a coupon, cart and user
+type Rule interface {
+	Name() string
+	Evaluate(ctx context.Context, c *models.CouponMeta, cart *Cart, user *User) Result
+}
+
+// RuleFunc adapts a function to the Rule interface
+type RuleFunc struct {
+	RuleName string
+	Fn       func(ctx context.Context, c *models.CouponMeta, cart *Cart, user *User) Result
+}
+
+func (f RuleFunc) Name() string { return f.RuleName }
+
+func (f RuleFunc) Evaluate(ctx context.Context, c *models.CouponMeta, cart *Cart, user *User) Result {
+	return f.Fn(ctx, c, cart, user)
+}
+
+// Pipeline is an ordered registry of rules. Validate and applicable both run the
+// same pipeline so they can't disagree about eligibility.
+type Pipeline struct {
+	mu    sync.RWMutex
+	rules []Rule // copy-on-write: Evaluate iterates it without the lock
+}
+
+// NewPipeline returns a pipeline with the built-in rules in their default order
+func NewPipeline() *Pipeline {
+	return &Pipeline{rules: BuiltinRules()}
+}
+
+// Register appends a custom rule to the end of the pipeline
+func (p *Pipeline) Register(r Rule) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules = append(slices.Clip(p.rules), r)
+}
+
+// RegisterBefore inserts a custom rule ahead of the named rule
+func (p *Pipeline) RegisterBefore(name string, r Rule) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, existing := range p.rules {
+		if existing.Name() == name {
+			p.rules = slices.Insert(slices.Clip(p.rules), i, r)
+			return nil
+		}
+	}
+	return fmt.Errorf("rule %q not registered", name)
+}
+
+// Names lists the rules in evaluation order
+func (p *Pipeline) Names() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	names := make([]string, len(p.rules))
+	for i, r := range p.rules {
+		names[i] = r.Name()
+	}
+	return names
+}
+
+// Evaluate runs the rules in order and stops at the first one that doesn't pass
+func (p *Pipeline) Evaluate(ctx context.Context, c *models.CouponMeta, cart *Cart, user *User) Result {
+	p.mu.RLock()
+	rules := p.rules
+	p.mu.RUnlock()
+
+	for _, r := range rules {
+		if err := ctx.Err(); err != nil {
+			return Result{Rule: r.Name(), Reason: "internal_error", Err: err}
+		}
+		res := r.Evaluate(ctx, c, cart, user)
+		if res.Err != nil && res.Reason == "" {
+			res.Reason = "internal_error"
+		}
+		if !res.Pass || res.Err != nil {
+			res.Pass = false
+			res.Rule = r.Name()
+			return res
+		}
+	}
+	return Pass
+}
+
+// --- built-in rules ---
+
+// BuiltinRules returns the built-in rules, cheap in-memory checks first and the
+// ones that may need a database lookup last
+func BuiltinRules() []Rule {
+	return []Rule{
+		RuleFunc{"expiry", expiryRule},
+		RuleFunc{"window", windowRule},
+		RuleFunc{"min_order", minOrderRule},
+		RuleFunc{"items", itemsRule},
+		RuleFunc{"eligible_minimums", eligibleMinimumsRule},
+		RuleFunc{"segment", segmentRule},
+		RuleFunc{"expression", expressionRule},
+		RuleFunc{"audience", audienceRule},
+		RuleFunc{"order_history", orderHistoryRule},
+		RuleFunc{"usage", usageRule},
+	}
+}
+
+func expiryRule(_ context.Context, c *models.CouponMeta, cart *Cart, _ *User) Result {
+	if c.ExpiryDate.Before(cart.Now) {
+		return fail("coupon_expired")
+	}
+	return Pass
+}
+
+// windowRule: each bound of the valid_from/valid_to window applies on its own
+func windowRule(_ context.Context, c *models.CouponMeta, cart *Cart, _ *User) Result {
+	if c.ValidFrom != nil && cart.Now.Before(*c.ValidFrom) {
+		return fail("not_in_valid_window")
+	}
+	if c.ValidTo != nil && cart.Now.After(*c.ValidTo) {
+		return fail("not_in_valid_window")
+	}
+	return Pass
+}
+
+func minOrderRule(_ context.Context, c *models.CouponMeta, cart *Cart, _ *User) Result {
+	if c.MinOrderValue > cart.OrderTotal {
+		return fail("min_order_value_not_met")
+	}
+	return Pass
+}
+
+// itemsRule: a coupon restricted to items/categories needs at least one matching line
+func itemsRule(_ context.Context, c *models.CouponMeta, cart *Cart, _ *User) Result {
+	if !c.IsRestricted() {
+		return Pass
+	}
+	for _, it := range cart.Items {
+		if c.Matches(it) {
+			return Pass
+		}
+	}
+	return fail("no_applicable_items")
+}
+
+func eligibleMinimumsRule(_ context.Context, c *models.CouponMeta, cart *Cart, _ *User) Result {
+	qty, subtotal := c.EligibleTotals(cart.Items)
+	if qty < c.MinEligibleQty {
+		return fail("min_eligible_qty_not_met")
+	}
+	if subtotal < c.MinEligibleSubtotal {
+		return fail("min_eligible_subtotal_not_met")
+	}
+	return Pass
+}
+
+func segmentRule(_ context.Context, c *models.CouponMeta, cart *Cart, user *User) Result {
+	if models.FirstFailedCondition(c.Conditions, user.Context, cart.Now) != nil {
+		return fail("not_eligible_segment")
+	}
+	return Pass
+}
+
+// expressionRule: evaluation errors (bad types, budget exceeded) count as not satisfied
+func expressionRule(_ context.Context, c *models.CouponMeta, cart *Cart, user *User) Result {
+	if c.Rule == nil {
+		return Pass
+	}
+	if cart.env == nil {
+		cart.env = models.NewRuleEnv(models.ValidationRequest{
+			UserID:     user.ID,
+			CartItems:  cart.Items,
+			OrderTotal: cart.OrderTotal,
+			User:       user.Context,
+		}, cart.Now)
+	}
+	ok, err := c.Rule.EvalBool(cart.env, expr.DefaultBudget)
+	if err != nil || !ok {
+		return fail("rule_not_satisfied")
+	}
+	return Pass
+}
+
+// audienceRule: assigned coupons are only valid for the users they were issued to
+func audienceRule(ctx context.Context, c *models.CouponMeta, _ *Cart, user *User) Result {
+	if c.Audience != "assigned" {
+		return Pass
+	}
+	ok, err := user.Facts.IsAssigned(ctx, c.ID)
+	if err != nil {
+		return Result{Err: fmt.Errorf("check assignment: %w", err)}
+	}
+	if !ok {
+		return fail("not_eligible_user")
+	}
+	return Pass
+}
+
+func orderHistoryRule(ctx context.Context, c *models.CouponMeta, _ *Cart, user *User) Result {
+	if !c.HasOrderCondition() {
+		return Pass
+	}
+	n, err := user.Facts.PriorOrders(ctx)
+	if err != nil {
+		return Result{Err: fmt.Errorf("prior orders: %w", err)}
+	}
+	if !c.AcceptsPriorOrders(n) {
+		return fail("order_history_not_eligible")
+	}
+	return Pass
+}
+
+func usageRule(ctx context.Context, c *models.CouponMeta, _ *Cart, user *User) Result {
+	n, err := user.Facts.UsageCount(ctx, c.ID)
+	if err != nil {
+		return Result{Err: fmt.Errorf("usage count: %w", err)}
+	}
+	return CheckUsage(c, n)
+}
+
+// CheckUsage applies the per-user usage limits to a usage count. It is also used
+// under the row lock when a redemption is consumed.
+func CheckUsage(c *models.CouponMeta, usageCount int) Result {
+	if c.UsageType == "one_time" && usageCount >= 1 {
+		return fail("coupon_already_used")
+	}
+	// MaxUsagePerUser <= 0 means no per-user cap
+	if c.MaxUsagePerUser > 0 && usageCount >= c.MaxUsagePerUser {
+		return fail("usage_limit_reached")
+	}
+	return Pass
+}
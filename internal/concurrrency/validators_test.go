@@ -0,0 +1,148 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+func passRule(name string) Rule {
+	return RuleFunc{name, func(context.Context, *models.CouponMeta, *Cart, *User) Result { return Pass }}
+}
+
+func TestPipelineRegisterBefore(t *testing.T) {
+	p := NewPipeline()
+	if err := p.RegisterBefore("usage", passRule("custom")); err != nil {
+		t.Fatal(err)
+	}
+	p.Register(passRule("last"))
+
+	names := p.Names()
+	if i := slices.Index(names, "custom"); i < 0 || names[i+1] != "usage" {
+		t.Errorf("custom not before usage: %v", names)
+	}
+	if names[len(names)-1] != "last" {
+		t.Errorf("last not appended: %v", names)
+	}
+	if err := p.RegisterBefore("missing", passRule("x")); err == nil {
+		t.Error("registered before a missing rule")
+	}
+}
+
+// run with -race: Evaluate iterates the rules without holding the lock
+func TestPipelineRegisterWhileEvaluating(t *testing.T) {
+	p := &Pipeline{}
+	p.Register(RuleFunc{"final", func(context.Context, *models.CouponMeta, *Cart, *User) Result { return fail("final") }})
+	coupon := &models.CouponMeta{}
+	cart := &Cart{Now: time.Now()}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				runtime.Gosched()
+				if res := p.Evaluate(context.Background(), coupon, cart, &User{}); res.Reason != "final" {
+					t.Errorf("reason %q, want final", res.Reason)
+					return
+				}
+			}
+		}()
+	}
+	for i := range 200 {
+		if err := p.RegisterBefore("final", passRule(fmt.Sprint("custom", i))); err != nil {
+			t.Fatal(err)
+		}
+		runtime.Gosched()
+	}
+	wg.Wait()
+}
+
+func TestSegmentRule(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	signup := now.AddDate(0, 0, -40)
+	insured := true
+	gold := models.UserContext{Tier: "gold", City: "Pune", SignupDate: &signup, Insured: &insured}
+
+	tests := []struct {
+		name  string
+		conds []models.EligibilityCondition
+		user  models.UserContext
+		want  string
+	}{
+		{"no conditions", nil, models.UserContext{}, ""},
+		{"tier in", []models.EligibilityCondition{{Attribute: "tier", Operator: "in", Values: []string{"gold", "platinum"}}}, gold, ""},
+		{"tier not in", []models.EligibilityCondition{{Attribute: "tier", Operator: "not_in", Values: []string{"gold"}}}, gold, "not_eligible_segment"},
+		{"attribute missing", []models.EligibilityCondition{{Attribute: "channel", Operator: "eq", Values: []string{"app"}}}, gold, "not_eligible_segment"},
+		{"insured", []models.EligibilityCondition{{Attribute: "insured", Operator: "eq", Values: []string{"true"}}}, gold, ""},
+		{"account age", []models.EligibilityCondition{{Attribute: "account_age_days", Operator: "gte", Values: []string{"30"}}}, gold, ""},
+		{"account too young", []models.EligibilityCondition{{Attribute: "account_age_days", Operator: "gt", Values: []string{"40"}}}, gold, "not_eligible_segment"},
+		{"signed up before", []models.EligibilityCondition{{Attribute: "signup_date", Operator: "lt", Values: []string{"2026-02-01"}}}, gold, ""},
+		{"every condition must hold", []models.EligibilityCondition{
+			{Attribute: "tier", Operator: "eq", Values: []string{"gold"}},
+			{Attribute: "city", Operator: "neq", Values: []string{"Pune"}},
+		}, gold, "not_eligible_segment"},
+	}
+	for _, tt := range tests {
+		c := &models.CouponMeta{Conditions: tt.conds}
+		res := segmentRule(context.Background(), c, &Cart{Now: now}, &User{Context: tt.user})
+		if res.Pass != (tt.want == "") || res.Reason != tt.want {
+			t.Errorf("%s: %+v, want %q", tt.name, res, tt.want)
+		}
+	}
+}
+
+// priorOrderFacts answers PriorOrders and counts the lookups
+type priorOrderFacts struct {
+	UserFacts
+	orders  int
+	err     error
+	lookups int
+}
+
+func (f *priorOrderFacts) PriorOrders(context.Context) (int, error) {
+	f.lookups++
+	return f.orders, f.err
+}
+
+func TestOrderHistoryRule(t *testing.T) {
+	zero, one := 0, 1
+	tests := []struct {
+		name     string
+		min, max *int
+		orders   int
+		err      error
+		want     string
+		lookedUp bool
+	}{
+		{"no condition", nil, nil, 5, nil, "", false},
+		{"first order", nil, &zero, 0, nil, "", true},
+		{"not the first order", nil, &zero, 1, nil, "order_history_not_eligible", true},
+		{"returning customer", &one, nil, 3, nil, "", true},
+		{"no orders yet", &one, nil, 0, nil, "order_history_not_eligible", true},
+		{"lookup fails", nil, &zero, 0, errors.New("db down"), "", true},
+	}
+	for _, tt := range tests {
+		c := &models.CouponMeta{Coupon: models.Coupon{MinPriorOrders: tt.min, MaxPriorOrders: tt.max}}
+		facts := &priorOrderFacts{orders: tt.orders, err: tt.err}
+		res := orderHistoryRule(context.Background(), c, &Cart{}, &User{Facts: facts})
+		if tt.err != nil {
+			if !errors.Is(res.Err, tt.err) {
+				t.Errorf("%s: %+v, want the lookup error", tt.name, res)
+			}
+		} else if res.Pass != (tt.want == "") || res.Reason != tt.want {
+			t.Errorf("%s: %+v, want %q", tt.name, res, tt.want)
+		}
+		if (facts.lookups > 0) != tt.lookedUp {
+			t.Errorf("%s: %d prior order lookups", tt.name, facts.lookups)
+		}
+	}
+}
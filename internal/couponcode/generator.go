@@ -0,0 +1,251 @@
+// Package couponcode generates and verifies the codes handed out for coupon
+// templates.
+//
+// A code is prefix + random body + HMAC fragment + optional check character.
+// The fragment is an HMAC-SHA256 of prefix and body under the template's
+// secret, written in the code alphabet, so codes can't be made up without the
+// secret. The check character is Luhn mod N over body and fragment, so typos
+// are caught too: any one wrong character, and any two swapped neighbours
+// except, for alphabets of even size, the first and last alphabet characters.
+// Both are verified without a database lookup.
+package couponcode
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// DefaultAlphabet leaves out characters that are easily confused (0/O, 1/I/L)
+const DefaultAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+const (
+	DefaultLength    = 10
+	MinLength        = 4
+	MaxLength        = 32
+	DefaultMACLength = 4
+	MaxMACLength     = 8
+	SecretSize       = 32
+	maxCodeLen       = 100 // coupon_codes.code
+)
+
+// Spec describes the codes of a template
+type Spec struct {
+	Alphabet  string `json:"alphabet,omitempty"`   // default DefaultAlphabet
+	Length    int    `json:"length,omitempty"`     // random characters, default DefaultLength
+	Prefix    string `json:"prefix,omitempty"`     // e.g. "SMS-"; tells template codes apart from others
+	CheckChar bool   `json:"check_char,omitempty"` // append a Luhn mod N check character
+	MACLength int    `json:"mac_length,omitempty"` // HMAC fragment characters, default DefaultMACLength without check_char
+}
+
+// IsZero reports whether no field was set
+func (s Spec) IsZero() bool {
+	return s == Spec{}
+}
+
+// WithDefaults fills in the default alphabet and length, and adds an HMAC
+// fragment when neither it nor a check character was asked for
+func (s Spec) WithDefaults() Spec {
+	if s.Alphabet == "" {
+		s.Alphabet = DefaultAlphabet
+	}
+	if s.Length == 0 {
+		s.Length = DefaultLength
+	}
+	if s.MACLength == 0 && !s.CheckChar {
+		s.MACLength = DefaultMACLength
+	}
+	return s
+}
+
+// SpecError is a problem with one field of a Spec
+type SpecError struct {
+	Field   string // JSON name
+	Message string
+}
+
+func (e *SpecError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// Validate checks a spec after WithDefaults. Alphabet characters must survive
+// code normalization unchanged, otherwise generated codes could not be typed
+// back in.
+func (s Spec) Validate() error {
+	if len(s.Alphabet) < 2 {
+		return &SpecError{"alphabet", "alphabet needs at least 2 characters"}
+	}
+	seen := make(map[rune]bool, len(s.Alphabet))
+	for _, r := range s.Alphabet {
+		if r > 127 || models.NormalizeCouponCode(string(r)) != string(r) {
+			return &SpecError{"alphabet", fmt.Sprintf("alphabet character %q is not allowed; use upper-case ASCII letters and digits", r)}
+		}
+		if seen[r] {
+			return &SpecError{"alphabet", fmt.Sprintf("alphabet character %q repeats", r)}
+		}
+		seen[r] = true
+	}
+	if s.Length < MinLength || s.Length > MaxLength {
+		return &SpecError{"length", fmt.Sprintf("length must be between %d and %d", MinLength, MaxLength)}
+	}
+	if s.MACLength < 0 || s.MACLength > MaxMACLength {
+		return &SpecError{"mac_length", fmt.Sprintf("mac_length must be between 0 and %d", MaxMACLength)}
+	}
+	if s.NormalizedPrefix() == "" {
+		return &SpecError{"prefix", "prefix is required so template codes can be recognized"}
+	}
+	for _, r := range s.Prefix {
+		if r > 127 || r < ' ' {
+			return &SpecError{"prefix", "prefix must be printable ASCII"}
+		}
+	}
+	if len(s.Prefix)+s.Length+s.MACLength+1 > maxCodeLen {
+		return &SpecError{"prefix", fmt.Sprintf("prefix, length and mac_length must fit in %d characters", maxCodeLen)}
+	}
+	return nil
+}
+
+// NormalizedPrefix is the prefix as it appears in normalized codes
+func (s Spec) NormalizedPrefix() string {
+	return models.NormalizeCouponCode(s.Prefix)
+}
+
+// Format is the spec of a template together with its secret
+type Format struct {
+	Spec
+	Secret []byte
+}
+
+// NewFormat applies defaults to spec, validates it and draws a new secret
+func NewFormat(spec Spec) (Format, error) {
+	spec = spec.WithDefaults()
+	if err := spec.Validate(); err != nil {
+		return Format{}, err
+	}
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return Format{}, err
+	}
+	return Format{Spec: spec, Secret: secret}, nil
+}
+
+// Keyspace is the number of distinct codes the spec can produce
+func (s Spec) Keyspace() float64 {
+	return math.Pow(float64(len(s.Alphabet)), float64(s.Length))
+}
+
+// Generator produces and verifies the codes of one format
+type Generator struct {
+	spec   Spec
+	secret []byte
+	max    *big.Int
+}
+
+// NewGenerator validates f; formats with an HMAC fragment need a secret
+func NewGenerator(f Format) (*Generator, error) {
+	if err := f.Spec.Validate(); err != nil {
+		return nil, err
+	}
+	if f.MACLength > 0 && len(f.Secret) < SecretSize/2 {
+		return nil, errors.New("couponcode: format secret too short")
+	}
+	return &Generator{spec: f.Spec, secret: f.Secret, max: big.NewInt(int64(len(f.Alphabet)))}, nil
+}
+
+// Spec returns the spec of the format
+func (g *Generator) Spec() Spec {
+	return g.spec
+}
+
+// Next returns a new random code from crypto/rand
+func (g *Generator) Next() (string, error) {
+	body := make([]byte, g.spec.Length)
+	for i := range body {
+		n, err := rand.Int(rand.Reader, g.max)
+		if err != nil {
+			return "", err
+		}
+		body[i] = g.spec.Alphabet[n.Int64()]
+	}
+	signed := append(body, g.mac(body)...)
+	if g.spec.CheckChar {
+		signed = append(signed, checkChar(g.spec.Alphabet, signed))
+	}
+	return g.spec.Prefix + string(signed), nil
+}
+
+// mac is the HMAC fragment of body, spelled in the alphabet
+func (g *Generator) mac(body []byte) []byte {
+	if g.spec.MACLength == 0 {
+		return nil
+	}
+	h := hmac.New(sha256.New, g.secret)
+	h.Write([]byte(g.spec.NormalizedPrefix()))
+	h.Write(body)
+	n := new(big.Int).SetBytes(h.Sum(nil))
+
+	out := make([]byte, g.spec.MACLength)
+	digit := new(big.Int)
+	for i := range out {
+		n.DivMod(n, g.max, digit)
+		out[i] = g.spec.Alphabet[digit.Int64()]
+	}
+	return out
+}
+
+// Matches reports whether code starts with the format's prefix, i.e. whether
+// Verify is the right check for it
+func (g *Generator) Matches(code string) bool {
+	return strings.HasPrefix(models.NormalizeCouponCode(code), g.spec.NormalizedPrefix())
+}
+
+// Verify reports whether code has the format's prefix, length and alphabet,
+// a correct check character and a genuine HMAC fragment. The code may be typed
+// in any case and with separators.
+func (g *Generator) Verify(code string) bool {
+	norm := models.NormalizeCouponCode(code)
+	prefix := g.spec.NormalizedPrefix()
+	if !strings.HasPrefix(norm, prefix) {
+		return false
+	}
+	rest := []byte(norm[len(prefix):])
+	signedLen := g.spec.Length + g.spec.MACLength
+	want := signedLen
+	if g.spec.CheckChar {
+		want++
+	}
+	if len(rest) != want || strings.Trim(string(rest), g.spec.Alphabet) != "" {
+		return false
+	}
+	if g.spec.CheckChar && checkChar(g.spec.Alphabet, rest[:signedLen]) != rest[signedLen] {
+		return false
+	}
+	body := rest[:g.spec.Length]
+	return hmac.Equal(g.mac(body), rest[g.spec.Length:signedLen])
+}
+
+// checkChar is the Luhn mod N check character of body over alphabet. Luhn
+// reduces doubled values by their digit sum in base n; that only keeps them
+// distinct for even n, so odd alphabets, where doubling mod n already is, use
+// that instead.
+func checkChar(alphabet string, body []byte) byte {
+	n := len(alphabet)
+	factor, sum := 2, 0
+	for i := len(body) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(alphabet, body[i])
+		if n%2 == 0 {
+			sum += addend/n + addend%n
+		} else {
+			sum += addend % n
+		}
+		factor = 3 - factor
+	}
+	return alphabet[(n-sum%n)%n]
+}
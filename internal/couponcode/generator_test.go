@@ -0,0 +1,225 @@
+package couponcode
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testGenerator(t *testing.T, spec Spec, secret byte) *Generator {
+	t.Helper()
+	g, err := NewGenerator(Format{Spec: spec.WithDefaults(), Secret: bytes.Repeat([]byte{secret}, SecretSize)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func next(t *testing.T, g *Generator) string {
+	t.Helper()
+	code, err := g.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// replace returns code with the character at i set to c
+func replace(code string, i int, c byte) string {
+	b := []byte(code)
+	b[i] = c
+	return string(b)
+}
+
+func TestNextVerifyRoundTrip(t *testing.T) {
+	specs := map[string]Spec{
+		"defaults":        {Prefix: "SMS-"},
+		"check character": {Prefix: "SMS-", CheckChar: true},
+		"check only":      {Prefix: "SMS-", CheckChar: true, MACLength: 0, Length: 12},
+		"long mac":        {Prefix: "vip_", MACLength: MaxMACLength, Alphabet: "ABCDEF0123"},
+	}
+	for name, spec := range specs {
+		t.Run(name, func(t *testing.T) {
+			g := testGenerator(t, spec, 1)
+			s := g.Spec()
+			want := len(s.Prefix) + s.Length + s.MACLength
+			if s.CheckChar {
+				want++
+			}
+			for range 200 {
+				code := next(t, g)
+				if len(code) != want || !strings.HasPrefix(code, s.Prefix) {
+					t.Fatalf("%q: want %d characters starting %q", code, want, s.Prefix)
+				}
+				if !g.Matches(code) || !g.Verify(code) {
+					t.Fatalf("%q does not verify", code)
+				}
+				// typed back in lower case and with separators
+				typed := strings.ToLower(code[:len(s.Prefix)+2]) + " -" + strings.ToLower(code[len(s.Prefix)+2:])
+				if !g.Verify(typed) {
+					t.Fatalf("%q does not verify", typed)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsMalformed(t *testing.T) {
+	g := testGenerator(t, Spec{Prefix: "SMS-", CheckChar: true}, 1)
+	code := next(t, g)
+	for _, bad := range []string{
+		"",
+		"SMS-",
+		"XMS-" + code[4:],
+		code[:len(code)-1],
+		code + "A",
+		replace(code, 5, '0'), // not in the alphabet
+	} {
+		if g.Verify(bad) {
+			t.Errorf("%q verified", bad)
+		}
+	}
+}
+
+// The check character alone catches every single-character substitution, and
+// every swap of two different neighbours except, for alphabets of even size,
+// the first and last alphabet characters
+func TestCheckCharDetectsTypos(t *testing.T) {
+	for name, alphabet := range map[string]string{
+		"odd alphabet":  DefaultAlphabet,
+		"even alphabet": "0123456789ABCDEF",
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := testGenerator(t, Spec{Prefix: "SMS-", Alphabet: alphabet, CheckChar: true}, 1)
+			first, last := alphabet[0], alphabet[len(alphabet)-1]
+			blindSpot := len(alphabet)%2 == 0
+			start := len(g.Spec().Prefix)
+
+			for range 50 {
+				code := next(t, g)
+				for i := start; i < len(code); i++ {
+					for j := range len(alphabet) {
+						if c := alphabet[j]; c != code[i] && g.Verify(replace(code, i, c)) {
+							t.Fatalf("%q: substituting %c at %d not detected", code, c, i)
+						}
+					}
+				}
+				for i := start; i+1 < len(code); i++ {
+					a, b := code[i], code[i+1]
+					if a == b || blindSpot && (a == first && b == last || a == last && b == first) {
+						continue
+					}
+					if swapped := replace(replace(code, i, b), i+1, a); g.Verify(swapped) {
+						t.Fatalf("%q: swapping %c%c at %d not detected", code, a, b, i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCheckCharSwaps(t *testing.T) {
+	// every pair, not just those random codes happen to contain
+	for _, alphabet := range []string{DefaultAlphabet, "0123456789ABCDEF"} {
+		first, last := alphabet[0], alphabet[len(alphabet)-1]
+		for i := range len(alphabet) {
+			for j := range len(alphabet) {
+				a, b := alphabet[i], alphabet[j]
+				if a == b {
+					continue
+				}
+				same := checkChar(alphabet, []byte{'A', a, b}) == checkChar(alphabet, []byte{'A', b, a})
+				blind := len(alphabet)%2 == 0 && (a == first && b == last || a == last && b == first)
+				if same != blind {
+					t.Errorf("%s: swapping %c%c changes the check character: %v", alphabet, a, b, !same)
+				}
+			}
+		}
+	}
+}
+
+// An altered code whose check character is recomputed, as anyone who knows the
+// format could, is still rejected by the HMAC fragment
+func TestVerifyRejectsForgedMAC(t *testing.T) {
+	g := testGenerator(t, Spec{Prefix: "SMS-", CheckChar: true, MACLength: DefaultMACLength}, 1)
+	s := g.Spec()
+	start := len(s.Prefix)
+	signedEnd := start + s.Length + s.MACLength
+
+	withCheck := func(code string) string {
+		signed := []byte(code[start:signedEnd])
+		return code[:signedEnd] + string(checkChar(s.Alphabet, signed))
+	}
+	for range 20 {
+		code := next(t, g)
+		for i := start; i < signedEnd; i++ {
+			for j := range len(s.Alphabet) {
+				c := s.Alphabet[j]
+				if c == code[i] {
+					continue
+				}
+				// i in the body: the fragment no longer matches it;
+				// i in the fragment: the fragment itself is forged
+				if forged := withCheck(replace(code, i, c)); g.Verify(forged) {
+					t.Fatalf("%q: forged %q verified", code, forged)
+				}
+			}
+		}
+	}
+}
+
+func TestVerifyWithWrongKey(t *testing.T) {
+	spec := Spec{Prefix: "SMS-", MACLength: MaxMACLength}
+	g := testGenerator(t, spec, 1)
+	other := testGenerator(t, spec, 2)
+	for range 200 {
+		code := next(t, g)
+		if other.Verify(code) {
+			t.Fatalf("%q verified under another secret", code)
+		}
+		if !g.Verify(code) {
+			t.Fatalf("%q does not verify", code)
+		}
+	}
+}
+
+func TestNewGenerator(t *testing.T) {
+	short := Format{Spec: Spec{Prefix: "SMS-"}.WithDefaults(), Secret: make([]byte, SecretSize/2-1)}
+	if _, err := NewGenerator(short); err == nil {
+		t.Error("accepted a short secret")
+	}
+	checkOnly := Format{Spec: Spec{Prefix: "SMS-", CheckChar: true}.WithDefaults()}
+	if _, err := NewGenerator(checkOnly); err != nil {
+		t.Errorf("check-only format without a secret: %v", err)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	tests := []struct {
+		spec  Spec
+		field string
+	}{
+		{Spec{Prefix: "SMS-"}, ""},
+		{Spec{Prefix: "SMS-", Alphabet: "A"}, "alphabet"},
+		{Spec{Prefix: "SMS-", Alphabet: "ABA"}, "alphabet"},
+		{Spec{Prefix: "SMS-", Alphabet: "ABc"}, "alphabet"},
+		{Spec{Prefix: "SMS-", Alphabet: "AB-"}, "alphabet"},
+		{Spec{Prefix: "SMS-", Length: MinLength - 1}, "length"},
+		{Spec{Prefix: "SMS-", Length: MaxLength + 1}, "length"},
+		{Spec{Prefix: "SMS-", MACLength: MaxMACLength + 1}, "mac_length"},
+		{Spec{Prefix: "--"}, "prefix"},
+		{Spec{Prefix: "SMS\x01"}, "prefix"},
+		{Spec{Prefix: strings.Repeat("P", maxCodeLen)}, "prefix"},
+	}
+	for _, tt := range tests {
+		err := tt.spec.WithDefaults().Validate()
+		var specErr *SpecError
+		switch {
+		case tt.field == "" && err != nil:
+			t.Errorf("%+v: %v", tt.spec, err)
+		case tt.field != "" && (!errors.As(err, &specErr) || specErr.Field != tt.field):
+			t.Errorf("%+v: %v, want a %s error", tt.spec, err, tt.field)
+		}
+	}
+}
@@ -0,0 +1,547 @@
+// Package expr is a small, sandboxed expression language for coupon rules, e.g.
+//
+//	cart.subtotal >= 500 && any(cart.items, .category == "Vitamins") && user.tier == "gold"
+//
+// Expressions are compiled once and evaluated against a read-only environment of
+// maps, lists, strings, numbers and booleans. There are no assignments, loops or
+// host calls; list functions are the only iteration and every evaluation step is
+// charged against a budget, so a rule can't run away.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// DefaultBudget is the number of evaluation steps a rule may take
+const DefaultBudget = 10000
+
+// ErrBudgetExceeded is returned when evaluation runs out of steps
+var ErrBudgetExceeded = errors.New("expr: evaluation budget exceeded")
+
+// Program is a compiled expression, safe for concurrent use
+type Program struct {
+	src  string
+	root node
+}
+
+// Compile parses src. vars lists the root variables the expression may reference.
+func Compile(src string, vars ...string) (*Program, error) {
+	if len(src) > MaxSourceLen {
+		return nil, fmt.Errorf("expr: expression too long (max %d chars)", MaxSourceLen)
+	}
+	toks, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("expr: %w", err)
+	}
+	p := &parser{toks: toks, vars: make(map[string]bool)}
+	for _, v := range vars {
+		p.vars[v] = true
+	}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, fmt.Errorf("expr: %w", err)
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("expr: unexpected token at %d", t.pos)
+	}
+	return &Program{src: src, root: root}, nil
+}
+
+// String returns the source the program was compiled from
+func (p *Program) String() string { return p.src }
+
+// Eval runs the program against env with the given step budget (DefaultBudget if <= 0)
+// and returns its value.
+func (p *Program) Eval(env map[string]interface{}, budget int) (interface{}, error) {
+	if budget <= 0 {
+		budget = DefaultBudget
+	}
+	st := &state{env: env, budget: budget}
+	return p.root.eval(st)
+}
+
+// EvalBool runs the program and requires a boolean result
+func (p *Program) EvalBool(env map[string]interface{}, budget int) (bool, error) {
+	v, err := p.Eval(env, budget)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expr: result is %s, not a boolean", typeName(v))
+	}
+	return b, nil
+}
+
+type state struct {
+	env    map[string]interface{}
+	elems  []interface{} // current list element of each enclosing predicate
+	budget int
+}
+
+func (s *state) step(n int) error {
+	s.budget -= n
+	if s.budget < 0 {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+type node interface {
+	eval(s *state) (interface{}, error)
+}
+
+type literal struct{ v interface{} }
+type ident struct{ name string }
+type elemRef struct{ field string }
+type member struct {
+	x     node
+	field string
+}
+type index struct{ x, i node }
+type unary struct {
+	op string
+	x  node
+}
+type binary struct {
+	op   string
+	l, r node
+}
+type call struct {
+	fn   string
+	args []node
+}
+type listLit struct{ elems []node }
+
+func (n *literal) eval(s *state) (interface{}, error) {
+	return n.v, s.step(1)
+}
+
+func (n *ident) eval(s *state) (interface{}, error) {
+	return normalize(s.env[n.name]), s.step(1)
+}
+
+func (n *elemRef) eval(s *state) (interface{}, error) {
+	if err := s.step(1); err != nil {
+		return nil, err
+	}
+	return field(s.elems[len(s.elems)-1], n.field)
+}
+
+func (n *member) eval(s *state) (interface{}, error) {
+	x, err := n.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.step(1); err != nil {
+		return nil, err
+	}
+	return field(x, n.field)
+}
+
+func (n *index) eval(s *state) (interface{}, error) {
+	x, err := n.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	i, err := n.i.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.step(1); err != nil {
+		return nil, err
+	}
+	switch xv := x.(type) {
+	case []interface{}:
+		f, ok := i.(float64)
+		if !ok || f != math.Trunc(f) {
+			return nil, fmt.Errorf("expr: list index must be a whole number")
+		}
+		if f < 0 || int(f) >= len(xv) {
+			return nil, nil
+		}
+		return normalize(xv[int(f)]), nil
+	case map[string]interface{}:
+		k, ok := i.(string)
+		if !ok {
+			return nil, fmt.Errorf("expr: map key must be a string")
+		}
+		return normalize(xv[k]), nil
+	}
+	return nil, fmt.Errorf("expr: cannot index %s", typeName(x))
+}
+
+func (n *listLit) eval(s *state) (interface{}, error) {
+	out := make([]interface{}, 0, len(n.elems))
+	for _, e := range n.elems {
+		v, err := e.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, s.step(1)
+}
+
+func (n *unary) eval(s *state) (interface{}, error) {
+	x, err := n.x.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.step(1); err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expr: ! needs a boolean, got %s", typeName(x))
+		}
+		return !b, nil
+	default: // "-"
+		f, ok := x.(float64)
+		if !ok {
+			return nil, fmt.Errorf("expr: - needs a number, got %s", typeName(x))
+		}
+		return -f, nil
+	}
+}
+
+func (n *binary) eval(s *state) (interface{}, error) {
+	l, err := n.l.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.step(1); err != nil {
+		return nil, err
+	}
+
+	// short-circuit logic
+	if n.op == "&&" || n.op == "||" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expr: %s needs booleans, got %s", n.op, typeName(l))
+		}
+		if (n.op == "&&" && !lb) || (n.op == "||" && lb) {
+			return lb, nil
+		}
+		r, err := n.r.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expr: %s needs booleans, got %s", n.op, typeName(r))
+		}
+		return rb, nil
+	}
+
+	r, err := n.r.eval(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return s.equal(l, r)
+	case "!=":
+		eq, err := s.equal(l, r)
+		return !eq, err
+	case "in":
+		switch rv := r.(type) {
+		case []interface{}:
+			if err := s.step(len(rv)); err != nil {
+				return nil, err
+			}
+			for _, e := range rv {
+				eq, err := s.equal(l, normalize(e))
+				if err != nil {
+					return nil, err
+				}
+				if eq {
+					return true, nil
+				}
+			}
+			return false, nil
+		case string:
+			ls, ok := l.(string)
+			if !ok {
+				return nil, fmt.Errorf("expr: in on a string needs a string, got %s", typeName(l))
+			}
+			if err := s.step(len(rv)); err != nil {
+				return nil, err
+			}
+			return strings.Contains(rv, ls), nil
+		}
+		return nil, fmt.Errorf("expr: in needs a list or string, got %s", typeName(r))
+	case "<", "<=", ">", ">=":
+		c, err := compare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "+":
+		if ls, ok := l.(string); ok {
+			if rs, ok := r.(string); ok {
+				if err := s.step(len(ls) + len(rs)); err != nil {
+					return nil, err
+				}
+				return ls + rs, nil
+			}
+		}
+	}
+
+	lf, lok := l.(float64)
+	rf, rok := r.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("expr: %s needs numbers, got %s and %s", n.op, typeName(l), typeName(r))
+	}
+	switch n.op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		if rf == 0 {
+			return nil, fmt.Errorf("expr: division by zero")
+		}
+		return lf / rf, nil
+	case "%":
+		if rf == 0 {
+			return nil, fmt.Errorf("expr: division by zero")
+		}
+		return math.Mod(lf, rf), nil
+	}
+	return nil, fmt.Errorf("expr: unknown operator %s", n.op)
+}
+
+func (n *call) eval(s *state) (interface{}, error) {
+	if err := s.step(1); err != nil {
+		return nil, err
+	}
+	if functions[n.fn].predicate {
+		return n.evalPredicate(s)
+	}
+
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	switch n.fn {
+	case "len":
+		switch v := args[0].(type) {
+		case string:
+			return float64(len(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		case nil:
+			return 0.0, nil
+		}
+		return nil, fmt.Errorf("expr: len of %s", typeName(args[0]))
+	case "lower", "upper":
+		str, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("expr: %s needs a string, got %s", n.fn, typeName(args[0]))
+		}
+		if err := s.step(len(str)); err != nil {
+			return nil, err
+		}
+		if n.fn == "lower" {
+			return strings.ToLower(str), nil
+		}
+		return strings.ToUpper(str), nil
+	case "contains", "starts_with":
+		a, ok1 := args[0].(string)
+		b, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("expr: %s needs strings", n.fn)
+		}
+		if err := s.step(len(a)); err != nil {
+			return nil, err
+		}
+		if n.fn == "contains" {
+			return strings.Contains(a, b), nil
+		}
+		return strings.HasPrefix(a, b), nil
+	}
+	return nil, fmt.Errorf("expr: unknown function %s", n.fn)
+}
+
+// evalPredicate runs any/all/none/count/sum, evaluating the second argument once per element
+func (n *call) evalPredicate(s *state) (interface{}, error) {
+	lv, err := n.args[0].eval(s)
+	if err != nil {
+		return nil, err
+	}
+	var list []interface{}
+	switch v := lv.(type) {
+	case []interface{}:
+		list = v
+	case nil:
+	default:
+		return nil, fmt.Errorf("expr: %s needs a list, got %s", n.fn, typeName(lv))
+	}
+
+	matches := 0
+	total := 0.0
+	for _, e := range list {
+		s.elems = append(s.elems, normalize(e))
+		v, err := n.args[1].eval(s)
+		s.elems = s.elems[:len(s.elems)-1]
+		if err != nil {
+			return nil, err
+		}
+
+		if n.fn == "sum" {
+			f, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("expr: sum needs numbers, got %s", typeName(v))
+			}
+			total += f
+			continue
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("expr: %s needs a boolean predicate, got %s", n.fn, typeName(v))
+		}
+		if b {
+			matches++
+			if n.fn == "any" {
+				return true, nil
+			}
+		} else if n.fn == "all" {
+			return false, nil
+		}
+	}
+
+	switch n.fn {
+	case "any":
+		return false, nil
+	case "all":
+		return true, nil
+	case "none":
+		return matches == 0, nil
+	case "count":
+		return float64(matches), nil
+	}
+	return total, nil
+}
+
+// --- value helpers ---
+
+func field(x interface{}, name string) (interface{}, error) {
+	switch xv := x.(type) {
+	case map[string]interface{}:
+		return normalize(xv[name]), nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("expr: %s has no field %q", typeName(x), name)
+}
+
+// normalize maps host values onto the language's types
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return float64(x)
+	case int64:
+		return float64(x)
+	case float32:
+		return float64(x)
+	case []string:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			out[i] = e
+		}
+		return out
+	case []map[string]interface{}:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			out[i] = e
+		}
+		return out
+	}
+	return v
+}
+
+// equal compares a and b, charging a step per list element compared
+func (s *state) equal(a, b interface{}) (bool, error) {
+	switch av := a.(type) {
+	case float64, string, bool, nil:
+		return a == b, nil
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false, nil
+		}
+		for i := range av {
+			if err := s.step(1); err != nil {
+				return false, err
+			}
+			eq, err := s.equal(normalize(av[i]), normalize(bv[i]))
+			if err != nil || !eq {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func compare(a, b interface{}) (int, error) {
+	switch av := a.(type) {
+	case float64:
+		if bv, ok := b.(float64); ok {
+			switch {
+			case av < bv:
+				return -1, nil
+			case av > bv:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv), nil
+		}
+	}
+	return 0, fmt.Errorf("expr: cannot order %s and %s", typeName(a), typeName(b))
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,181 @@
+package expr
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testEnv() map[string]interface{} {
+	return map[string]interface{}{
+		"cart": map[string]interface{}{
+			"subtotal": 620,
+			"qty":      4,
+			"items": []map[string]interface{}{
+				{"id": "med1", "category": "Vitamins", "qty": 3, "price": 40},
+				{"id": "med2", "category": "Painkillers", "qty": 1, "price": 500},
+			},
+		},
+		"user": map[string]interface{}{
+			"tier": "gold",
+			"tags": []string{"staff", "beta"},
+		},
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{`cart.subtotal >= 500 && any(cart.items, .category == "Vitamins") && user.tier == "gold"`, true},
+		{`all(cart.items, .qty >= 1)`, true},
+		{`none(cart.items, .price > 1000)`, true},
+		{`count(cart.items, .qty > 1)`, 1.0},
+		{`sum(cart.items, .qty * .price)`, 620.0},
+		{`"beta" in user.tags`, true},
+		{`"old" in user.tags`, false},
+		{`"ami" in "Vitamins"`, true},
+		{`user.tags == ["staff", "beta"]`, true},
+		{`user.tags != ["staff"]`, true},
+		{`cart.items[1].id + "-" + lower(user.tier)`, "med2-gold"},
+		{`cart.missing.field == null`, true},
+		{`cart.items[5]`, nil},
+		{`len(cart.items) + len(user) + len("ab")`, 6.0},
+		{`starts_with(upper(user.tier), "GO") && contains(user.tier, "ol")`, true},
+		{`-cart.qty % 3`, -1.0},
+		{`false && cart.nothing.here > 1`, false}, // short-circuits
+		{`any(null, true)`, false},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src, "cart", "user")
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.src, err)
+			continue
+		}
+		got, err := p.Eval(testEnv(), 0)
+		if err != nil {
+			t.Errorf("Eval(%q): %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Eval(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEvalTypeErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`cart.qty && true`, "&& needs booleans, got number"},
+		{`true || false || "x"`, ""}, // short-circuits before the string
+		{`false || "x"`, "|| needs booleans, got string"},
+		{`!cart.qty`, "! needs a boolean, got number"},
+		{`-user.tier`, "- needs a number, got string"},
+		{`cart.qty + "1"`, "+ needs numbers, got number and string"},
+		{`user.tier < 1`, "cannot order string and number"},
+		{`cart.qty / 0`, "division by zero"},
+		{`cart.qty % 0`, "division by zero"},
+		{`1 in cart.qty`, "in needs a list or string, got number"},
+		{`1 in "abc"`, "in on a string needs a string, got number"},
+		{`cart.qty.x`, `number has no field "x"`},
+		{`cart.items[0.5]`, "list index must be a whole number"},
+		{`cart[1]`, "map key must be a string"},
+		{`user.tier[0]`, "cannot index string"},
+		{`len(1)`, "len of number"},
+		{`lower(1)`, "lower needs a string, got number"},
+		{`contains(user.tier, 1)`, "contains needs strings"},
+		{`any(user.tier, true)`, "any needs a list, got string"},
+		{`any(cart.items, .qty)`, "any needs a boolean predicate, got number"},
+		{`sum(cart.items, .category)`, "sum needs numbers, got string"},
+	}
+	for _, tt := range tests {
+		p, err := Compile(tt.src, "cart", "user")
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.src, err)
+			continue
+		}
+		_, err = p.Eval(testEnv(), 0)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("Eval(%q): %v", tt.src, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Eval(%q) = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestEvalBoolNeedsBoolean(t *testing.T) {
+	p, err := Compile(`cart.qty`, "cart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.EvalBool(testEnv(), 0); err == nil || !strings.Contains(err.Error(), "not a boolean") {
+		t.Errorf("EvalBool: %v", err)
+	}
+}
+
+// bigEnv holds data far larger than the default budget
+func bigEnv() map[string]interface{} {
+	items := make([]interface{}, 20000)
+	for i := range items {
+		items[i] = map[string]interface{}{"qty": 1}
+	}
+	long := strings.Repeat("x", 50000)
+	return map[string]interface{}{
+		"items":  items,
+		"items2": append([]interface{}(nil), items...),
+		"nums":   make([]interface{}, 20000),
+		"nums2":  make([]interface{}, 20000),
+		"long":   long,
+	}
+}
+
+func TestEvalBudget(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"predicate over a long list", `any(items, .qty > 1)`},
+		{"nested predicates", `count(nums, count(nums, true) > 0) > 0`},
+		{"membership in a long list", `"x" in nums`},
+		{"equality of long lists", `nums == nums2`},
+		{"equality of lists of lists", `[nums] == [nums2]`},
+		{"substring of a long string", `"y" in long`},
+		{"contains on a long string", `contains(long, "y")`},
+		{"starts_with on a long string", `starts_with(long, "y")`},
+		{"lower of a long string", `lower(long) == ""`},
+		{"concatenating a long string", `long + "y" == ""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Compile(tt.src, "items", "items2", "nums", "nums2", "long")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.Eval(bigEnv(), 0); !errors.Is(err, ErrBudgetExceeded) {
+				t.Errorf("Eval: %v, want ErrBudgetExceeded", err)
+			}
+		})
+	}
+}
+
+func TestEvalBudgetIsExact(t *testing.T) {
+	// literal, literal, binary: three steps
+	p, err := Compile(`1 == 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := p.Eval(nil, 3); err != nil || v != true {
+		t.Errorf("budget 3: %v, %v", v, err)
+	}
+	if _, err := p.Eval(nil, 2); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("budget 2: %v, want ErrBudgetExceeded", err)
+	}
+}
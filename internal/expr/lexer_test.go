@@ -0,0 +1,65 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []token
+	}{
+		{
+			src: `cart.qty >= 1_000 && "a\"b" != 'c'`,
+			want: []token{
+				{kind: tokIdent, text: "cart", pos: 0},
+				{kind: tokOp, text: ".", pos: 4},
+				{kind: tokIdent, text: "qty", pos: 5},
+				{kind: tokOp, text: ">=", pos: 9},
+				{kind: tokNumber, num: 1000, pos: 12},
+				{kind: tokOp, text: "&&", pos: 18},
+				{kind: tokString, str: `a"b`, pos: 21},
+				{kind: tokOp, text: "!=", pos: 28},
+				{kind: tokString, str: "c", pos: 31},
+				{kind: tokEOF, pos: 34},
+			},
+		},
+		{
+			// identifiers are decoded as UTF-8, not byte by byte
+			src: "größe == 'é'",
+			want: []token{
+				{kind: tokIdent, text: "größe", pos: 0},
+				{kind: tokOp, text: "==", pos: 9},
+				{kind: tokString, str: "é", pos: 12},
+				{kind: tokEOF, pos: 16},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := lex(tt.src)
+		if err != nil {
+			t.Errorf("lex(%q): %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lex(%q)\n got %+v\nwant %+v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestLexErrors(t *testing.T) {
+	for _, src := range []string{
+		`"open`,
+		`1.2.3`,
+		`a # b`,
+		"a × b", // a multi-byte symbol is one character, reported whole
+	} {
+		if toks, err := lex(src); err == nil {
+			t.Errorf("lex(%q) = %+v, want an error", src, toks)
+		}
+	}
+	if _, err := lex("a × b"); err == nil || err.Error() != `unexpected character '×' at 2` {
+		t.Errorf("multi-byte error: %v", err)
+	}
+}
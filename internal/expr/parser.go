@@ -0,0 +1,278 @@
+package expr
+
+import "fmt"
+
+// Limits applied at compile time
+const (
+	MaxSourceLen = 2000
+	MaxNodes     = 256
+	MaxDepth     = 32
+)
+
+// functions and their arity; predicate functions take a list and an
+// expression evaluated per element, where ".field" refers to the element
+var functions = map[string]struct {
+	arity     int
+	predicate bool
+}{
+	"any":         {2, true},
+	"all":         {2, true},
+	"none":        {2, true},
+	"count":       {2, true},
+	"sum":         {2, true},
+	"len":         {1, false},
+	"lower":       {1, false},
+	"upper":       {1, false},
+	"contains":    {2, false},
+	"starts_with": {2, false},
+}
+
+type parser struct {
+	toks   []token
+	pos    int
+	vars   map[string]bool
+	nodes  int
+	depth  int
+	inPred int // > 0 while parsing the per-element argument of a predicate function
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(text string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == text
+}
+
+func (p *parser) expect(text string) error {
+	t := p.next()
+	if t.kind != tokOp || t.text != text {
+		return fmt.Errorf("expected %q at %d", text, t.pos)
+	}
+	return nil
+}
+
+// count tracks the size of the tree so oversized rules are rejected
+func (p *parser) count() error {
+	p.nodes++
+	if p.nodes > MaxNodes {
+		return fmt.Errorf("expression too large (max %d nodes)", MaxNodes)
+	}
+	return nil
+}
+
+func (p *parser) parseExpr() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > MaxDepth {
+		return nil, fmt.Errorf("expression nested too deeply (max %d)", MaxDepth)
+	}
+	return p.parseBinary(0)
+}
+
+// binary operator precedence, lowest first
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) binaryOp(level int) (string, bool) {
+	t := p.peek()
+	for _, op := range precedence[level] {
+		if (t.kind == tokOp || t.kind == tokIdent) && t.text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.binaryOp(level)
+		if !ok {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.count(); err != nil {
+			return nil, err
+		}
+		left = &binary{op: op, l: left, r: right}
+		// comparisons don't chain
+		if level == 2 {
+			return left, nil
+		}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("!") || p.isOp("-") {
+		op := p.next().text
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > MaxDepth {
+			return nil, fmt.Errorf("expression nested too deeply (max %d)", MaxDepth)
+		}
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.count(); err != nil {
+			return nil, err
+		}
+		return &unary{op: op, x: x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.isOp("."):
+			p.next()
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected field name at %d", t.pos)
+			}
+			if err := p.count(); err != nil {
+				return nil, err
+			}
+			x = &member{x: x, field: t.text}
+		case p.isOp("["):
+			p.next()
+			i, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			if err := p.count(); err != nil {
+				return nil, err
+			}
+			x = &index{x: x, i: i}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	if err := p.count(); err != nil {
+		return nil, err
+	}
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return &literal{v: t.num}, nil
+	case tokString:
+		return &literal{v: t.str}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literal{v: true}, nil
+		case "false":
+			return &literal{v: false}, nil
+		case "null":
+			return &literal{v: nil}, nil
+		}
+		if p.isOp("(") {
+			return p.parseCall(t)
+		}
+		if !p.vars[t.text] {
+			return nil, fmt.Errorf("unknown variable %q at %d", t.text, t.pos)
+		}
+		return &ident{name: t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			var elems []node
+			for !p.isOp("]") {
+				e, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				elems = append(elems, e)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			return &listLit{elems: elems}, p.expect("]")
+		case ".":
+			// element shorthand: ".category" inside any(cart.items, .category == "x")
+			f := p.next()
+			if f.kind != tokIdent {
+				return nil, fmt.Errorf("expected field name at %d", f.pos)
+			}
+			if p.inPred == 0 {
+				return nil, fmt.Errorf(".%s used outside a predicate function at %d", f.text, t.pos)
+			}
+			return &elemRef{field: f.text}, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected token at %d", t.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+	}
+	p.next() // (
+	var args []node
+	for !p.isOp(")") {
+		if fn.predicate && len(args) == 1 {
+			p.inPred++
+		}
+		a, err := p.parseExpr()
+		if fn.predicate && len(args) == 1 {
+			p.inPred--
+		}
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if len(args) != fn.arity {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", name.text, fn.arity, len(args))
+	}
+	return &call{fn: name.text, args: args}, nil
+}
@@ -0,0 +1,58 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"empty", ``, "unexpected token"},
+		{"trailing tokens", `cart.qty 1`, "unexpected token"},
+		{"unclosed paren", `(cart.qty > 1`, `expected ")"`},
+		{"unclosed list", `"a" in ["a", "b"`, `expected "]"`},
+		{"missing field name", `cart.`, "expected field name"},
+		{"unknown variable", `order.qty > 1`, `unknown variable "order"`},
+		{"unknown function", `exec(cart)`, `unknown function "exec"`},
+		{"wrong arity", `len(cart.items, 1)`, "len takes 1 arguments, got 2"},
+		{"element outside predicate", `.qty > 1`, ".qty used outside a predicate function"},
+		{"element in list argument", `any(.items, true)`, ".items used outside a predicate function"},
+		{"element in plain function", `len(.name) > 0`, ".name used outside a predicate function"},
+		{"chained comparison", `1 < 2 < 3`, "unexpected token"},
+		{"too long", strings.Repeat("1+", MaxSourceLen/2) + "1", "too long"},
+		{"too many nodes", strings.Repeat("1+", MaxNodes) + "1", "too large"},
+		{"nested too deeply", strings.Repeat("(", MaxDepth) + "1" + strings.Repeat(")", MaxDepth), "nested too deeply"},
+		{"unary nested too deeply", strings.Repeat("!", MaxDepth) + "true", "nested too deeply"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.src, "cart")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Compile(%q) = %v, want %q", tt.src, err, tt.want)
+			}
+		})
+	}
+}
+
+// the limits are inclusive: a rule right at them still compiles
+func TestCompileLimits(t *testing.T) {
+	for name, src := range map[string]string{
+		"nodes": strings.Repeat("1+", (MaxNodes-1)/2) + "1",
+		"depth": strings.Repeat("(", MaxDepth-1) + "1" + strings.Repeat(")", MaxDepth-1),
+	} {
+		if _, err := Compile(src); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestCompileElementInNestedPredicate(t *testing.T) {
+	src := `any(cart.items, .qty > 1 && count(cart.items, .qty > 1) > 1)`
+	if _, err := Compile(src, "cart"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ChildCode is one generated code of a coupon template. Redeeming it applies
+// the template's rules and usage limits; each child code can be redeemed once.
+type ChildCode struct {
+	ID           int64
+	TemplateID   int
+	TemplateCode string
+	Code         string
+	Batch        string // label of the generation run
+	CreatedAt    time.Time
+	RedeemedAt   *time.Time
+	RedeemedBy   string
+}
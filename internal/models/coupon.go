@@ -1,22 +1,42 @@
 package models
 
-import "time"
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/expr"
+)
+
+// ErrDuplicateCouponCode is returned by repositories when a coupon code is already taken
+var ErrDuplicateCouponCode = errors.New("coupon_code_taken")
 
 type Coupon struct {
-	ID              int
-	CouponCode      string
-	ExpiryDate      time.Time
-	UsageType       string
-	MinOrderValue   float64
-	ValidFrom       *time.Time
-	ValidTo         *time.Time
-	DiscountType    string
-	DiscountValue   float64
-	MaxUsagePerUser int
-	TargetType      string
-	Terms           string
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	ID                  int
+	CouponCode          string
+	ExpiryDate          time.Time
+	UsageType           string
+	MinOrderValue       float64
+	MinEligibleQty      int
+	MinEligibleSubtotal float64
+	ValidFrom           *time.Time
+	ValidTo             *time.Time
+	DiscountType        string
+	DiscountValue       float64
+	BuyQty              int    // bogo: units to buy per set
+	GetQty              int    // bogo: units discounted per set
+	TierBasis           string // tiered: "order_total" or "eligible_qty"
+	MaxUnitsDiscounted  int    // per-item types: cap on discounted units, 0 = no cap
+	MaxUsagePerUser     int
+	Audience            string // "public", or "assigned" to listed users only
+	MinPriorOrders      *int   // order history window, nil = no bound
+	MaxPriorOrders      *int   // 0 = first order only
+	RuleExpr            string // optional internal/expr rule, "" = none
+	IsTemplate          bool   // only redeemable through its generated child codes
+	TargetType          string
+	Terms               string
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
 }
 
 // Optimized read model for validation
@@ -25,4 +45,138 @@ type CouponMeta struct {
 	Coupon
 	ApplicableItems      []string
 	ApplicableCategories []string
+	// bogo "get" side; empty means the same items/categories as the buy side
+	RewardItems      []string
+	RewardCategories []string
+	// tiered discounts, sorted by ascending threshold
+	Tiers []DiscountTier
+	// conditions over the user context; all must hold
+	Conditions []EligibilityCondition
+	// RuleExpr compiled once on load
+	Rule *expr.Program
+}
+
+// DiscountTier is one step of a tiered coupon
+type DiscountTier struct {
+	Threshold     float64 `json:"threshold"`
+	DiscountType  string  `json:"discount_type"` // flat or percentage
+	DiscountValue float64 `json:"discount_value"`
+}
+
+// codeSpace is the whitespace NormalizeCouponCode drops. It is spelled out
+// rather than unicode.IsSpace so the SQL in migrations/000011 can use the exact
+// same set; [[:space:]] there depends on the database locale.
+const codeSpace = " \t\n\v\f\r"
+
+// stripSeparators mirrors code_normalization.strip_separators, the setting the
+// stored normalized codes were computed with
+var stripSeparators = true
+
+// SetStripCodeSeparators sets whether NormalizeCouponCode drops '-' and '_'.
+// It is only called at startup with the value stored in the database (see
+// repository.LoadCodeNormalization), before any code is normalized.
+func SetStripCodeSeparators(strip bool) {
+	stripSeparators = strip
+}
+
+// NormalizeCouponCode returns the canonical form coupon codes are matched by:
+// whitespace (and separators, see SetStripCodeSeparators) is dropped and
+// letters upper-cased, so " save-10 " finds SAVE10. The coupons.coupon_code_norm
+// column holds the same form.
+func NormalizeCouponCode(code string) string {
+	drop := codeSpace
+	if stripSeparators {
+		drop += "-_"
+	}
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if strings.ContainsRune(drop, r) {
+			return -1
+		}
+		return r
+	}, code))
+}
+
+// HasOrderCondition reports whether the coupon depends on the user's order history
+func (c *Coupon) HasOrderCondition() bool {
+	return c.MinPriorOrders != nil || c.MaxPriorOrders != nil
+}
+
+// AcceptsPriorOrders reports whether a user with n earlier orders may use the coupon
+func (c *Coupon) AcceptsPriorOrders(n int) bool {
+	if c.MinPriorOrders != nil && n < *c.MinPriorOrders {
+		return false
+	}
+	if c.MaxPriorOrders != nil && n > *c.MaxPriorOrders {
+		return false
+	}
+	return true
+}
+
+// IsRestricted reports whether the coupon is limited to specific items or categories.
+func (m *CouponMeta) IsRestricted() bool {
+	return len(m.ApplicableItems) > 0 || len(m.ApplicableCategories) > 0
+}
+
+// Matches reports whether a cart line passes the coupon's item and category filters.
+// A coupon without restrictions matches every line.
+func (m *CouponMeta) Matches(it CartItem) bool {
+	if !m.IsRestricted() {
+		return true
+	}
+	for _, id := range m.ApplicableItems {
+		if id == it.ID {
+			return true
+		}
+	}
+	for _, c := range m.ApplicableCategories {
+		if c == it.Category {
+			return true
+		}
+	}
+	return false
+}
+
+// EligibleTotals sums quantity and value over the cart lines the coupon matches.
+func (m *CouponMeta) EligibleTotals(items []CartItem) (qty int, subtotal float64) {
+	for _, it := range items {
+		if !m.Matches(it) {
+			continue
+		}
+		qty += it.Qty
+		subtotal += float64(it.Qty) * it.Price
+	}
+	return qty, subtotal
+}
+
+// MatchesReward reports whether a cart line can be handed out on the "get" side
+// of a bogo coupon.
+func (m *CouponMeta) MatchesReward(it CartItem) bool {
+	if len(m.RewardItems) == 0 && len(m.RewardCategories) == 0 {
+		return m.Matches(it)
+	}
+	for _, id := range m.RewardItems {
+		if id == it.ID {
+			return true
+		}
+	}
+	for _, c := range m.RewardCategories {
+		if c == it.Category {
+			return true
+		}
+	}
+	return false
+}
+
+// TierFor returns the highest tier reached by value and the tier after it.
+// Either may be nil. Tiers must be sorted by threshold.
+func (m *CouponMeta) TierFor(value float64) (current, next *DiscountTier) {
+	for i := range m.Tiers {
+		if value >= m.Tiers[i].Threshold {
+			current = &m.Tiers[i]
+			continue
+		}
+		next = &m.Tiers[i]
+		break
+	}
+	return current, next
 }
@@ -0,0 +1,27 @@
+package models
+
+import "testing"
+
+func TestNormalizeCouponCode(t *testing.T) {
+	tests := []struct {
+		code       string
+		strip      bool
+		normalized string
+	}{
+		{" save10 ", true, "SAVE10"},
+		{"Save-10", true, "SAVE10"},
+		{"save_10", true, "SAVE10"},
+		{"sa\tve\n1\v0\f\r", true, "SAVE10"},
+		// outside the ASCII set, like the SQL in migrations/000011
+		{"save\u00a010", true, "SAVE\u00a010"},
+		{"save\u300010", true, "SAVE\u300010"},
+		{" Save-10_x ", false, "SAVE-10_X"},
+	}
+	defer SetStripCodeSeparators(stripSeparators)
+	for _, tt := range tests {
+		SetStripCodeSeparators(tt.strip)
+		if got := NormalizeCouponCode(tt.code); got != tt.normalized {
+			t.Errorf("NormalizeCouponCode(%q) with strip %v = %q, want %q", tt.code, tt.strip, got, tt.normalized)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/expr"
+)
+
+// RuleVars are the variables a coupon rule expression can use
+var RuleVars = []string{"cart", "user", "channel", "now"}
+
+// CompileRule compiles a coupon rule expression against RuleVars
+func CompileRule(src string) (*expr.Program, error) {
+	return expr.Compile(src, RuleVars...)
+}
+
+// NewRuleEnv builds the read-only environment a rule is evaluated against:
+//
+//	cart.subtotal, cart.order_total, cart.qty, cart.item_count, cart.categories,
+//	cart.items[] {id, category, price, qty, total}
+//	user.id, user.tier, user.age_band, user.city, user.channel, user.insured,
+//	user.signup_date, user.account_age_days, user.prior_orders
+//	channel
+//	now.date, now.time, now.year, now.month, now.day, now.weekday, now.hour, now.unix
+//
+// Attributes the caller did not supply are null.
+func NewRuleEnv(req ValidationRequest, now time.Time) map[string]interface{} {
+	now = now.UTC()
+
+	items := make([]interface{}, 0, len(req.CartItems))
+	categories := []interface{}{}
+	seen := make(map[string]bool)
+	subtotal := 0.0
+	qty := 0
+	for _, it := range req.CartItems {
+		total := float64(it.Qty) * it.Price
+		items = append(items, map[string]interface{}{
+			"id":       it.ID,
+			"category": it.Category,
+			"price":    it.Price,
+			"qty":      float64(it.Qty),
+			"total":    total,
+		})
+		subtotal += total
+		qty += it.Qty
+		if !seen[it.Category] {
+			seen[it.Category] = true
+			categories = append(categories, it.Category)
+		}
+	}
+
+	u := req.User
+	user := map[string]interface{}{
+		"id":       req.UserID,
+		"tier":     optString(u.Tier),
+		"age_band": optString(u.AgeBand),
+		"city":     optString(u.City),
+		"channel":  optString(u.Channel),
+	}
+	if u.Insured != nil {
+		user["insured"] = *u.Insured
+	}
+	if u.SignupDate != nil {
+		user["signup_date"] = u.SignupDate.UTC().Format(time.RFC3339)
+		user["account_age_days"] = float64(int(now.Sub(*u.SignupDate).Hours() / 24))
+	}
+	if u.PriorOrders != nil {
+		user["prior_orders"] = float64(*u.PriorOrders)
+	}
+
+	return map[string]interface{}{
+		"cart": map[string]interface{}{
+			"subtotal":    subtotal,
+			"order_total": req.OrderTotal,
+			"qty":         float64(qty),
+			"item_count":  float64(len(req.CartItems)),
+			"categories":  categories,
+			"items":       items,
+		},
+		"user":    user,
+		"channel": optString(u.Channel),
+		"now": map[string]interface{}{
+			"date":    now.Format(time.DateOnly),
+			"time":    now.Format("15:04"),
+			"year":    float64(now.Year()),
+			"month":   float64(now.Month()),
+			"day":     float64(now.Day()),
+			"weekday": strings.ToLower(now.Weekday().String()),
+			"hour":    float64(now.Hour()),
+			"unix":    float64(now.Unix()),
+		},
+	}
+}
+
+func optString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserContext carries caller-supplied attributes of the user a cart belongs to
+type UserContext struct {
+	SignupDate *time.Time `json:"signup_date,omitempty"` // RFC3339
+	Tier       string     `json:"tier,omitempty"`        // e.g. silver, gold
+	AgeBand    string     `json:"age_band,omitempty"`    // e.g. 18-25, 60+
+	City       string     `json:"city,omitempty"`
+	Channel    string     `json:"channel,omitempty"` // e.g. app, web, store
+	Insured    *bool      `json:"insured,omitempty"`
+	// number of orders the user placed before this one; when omitted the
+	// service falls back to its own redemption history across all coupons
+	PriorOrders *int `json:"prior_orders,omitempty"`
+}
+
+// EligibilityCondition is one condition a coupon declares over the user context,
+// e.g. {"attribute": "tier", "operator": "in", "values": ["gold", "platinum"]}
+type EligibilityCondition struct {
+	Attribute string   `json:"attribute"`
+	Operator  string   `json:"operator"`
+	Values    []string `json:"values"`
+}
+
+// attribute kinds decide which operators make sense
+var conditionAttributes = map[string]string{
+	"tier":             "string",
+	"age_band":         "string",
+	"city":             "string",
+	"channel":          "string",
+	"insured":          "bool",
+	"signup_date":      "time",
+	"account_age_days": "number", // whole days since signup_date
+}
+
+var conditionOperators = map[string]bool{
+	"eq": true, "neq": true, "in": true, "not_in": true,
+	"gt": true, "gte": true, "lt": true, "lte": true,
+}
+
+// Validate checks the condition is well formed so bad definitions fail at create time
+func (c EligibilityCondition) Validate() error {
+	kind, ok := conditionAttributes[c.Attribute]
+	if !ok {
+		return fmt.Errorf("unknown attribute %q", c.Attribute)
+	}
+	if !conditionOperators[c.Operator] {
+		return fmt.Errorf("unknown operator %q", c.Operator)
+	}
+	if len(c.Values) == 0 {
+		return fmt.Errorf("%s: values required", c.Attribute)
+	}
+	ordered := c.Operator == "gt" || c.Operator == "gte" || c.Operator == "lt" || c.Operator == "lte"
+	if ordered && kind != "number" && kind != "time" {
+		return fmt.Errorf("%s: operator %s needs a number or date attribute", c.Attribute, c.Operator)
+	}
+	if (c.Operator != "in" && c.Operator != "not_in") && len(c.Values) != 1 {
+		return fmt.Errorf("%s: operator %s takes exactly one value", c.Attribute, c.Operator)
+	}
+	for _, v := range c.Values {
+		if _, err := parseConditionValue(kind, v); err != nil {
+			return fmt.Errorf("%s: %w", c.Attribute, err)
+		}
+	}
+	return nil
+}
+
+// Holds evaluates the condition against the user at time now.
+// A condition on an attribute the caller did not supply never holds.
+func (c EligibilityCondition) Holds(u UserContext, now time.Time) bool {
+	kind := conditionAttributes[c.Attribute]
+	actual, ok := u.attribute(c.Attribute, now)
+	if !ok {
+		return false
+	}
+
+	switch c.Operator {
+	case "eq":
+		return compareCondition(kind, actual, c.Values[0]) == 0
+	case "neq":
+		return compareCondition(kind, actual, c.Values[0]) != 0
+	case "in":
+		return slices.ContainsFunc(c.Values, func(v string) bool { return compareCondition(kind, actual, v) == 0 })
+	case "not_in":
+		return !slices.ContainsFunc(c.Values, func(v string) bool { return compareCondition(kind, actual, v) == 0 })
+	case "gt":
+		return compareCondition(kind, actual, c.Values[0]) > 0
+	case "gte":
+		return compareCondition(kind, actual, c.Values[0]) >= 0
+	case "lt":
+		return compareCondition(kind, actual, c.Values[0]) < 0
+	case "lte":
+		return compareCondition(kind, actual, c.Values[0]) <= 0
+	}
+	return false
+}
+
+// FirstFailedCondition returns the first condition the user does not meet, or nil
+func FirstFailedCondition(conds []EligibilityCondition, u UserContext, now time.Time) *EligibilityCondition {
+	for i := range conds {
+		if !conds[i].Holds(u, now) {
+			return &conds[i]
+		}
+	}
+	return nil
+}
+
+// attribute returns the user's value for a condition attribute in its string form
+func (u UserContext) attribute(name string, now time.Time) (string, bool) {
+	switch name {
+	case "tier":
+		return u.Tier, u.Tier != ""
+	case "age_band":
+		return u.AgeBand, u.AgeBand != ""
+	case "city":
+		return u.City, u.City != ""
+	case "channel":
+		return u.Channel, u.Channel != ""
+	case "insured":
+		if u.Insured == nil {
+			return "", false
+		}
+		return strconv.FormatBool(*u.Insured), true
+	case "signup_date":
+		if u.SignupDate == nil {
+			return "", false
+		}
+		return u.SignupDate.UTC().Format(time.RFC3339), true
+	case "account_age_days":
+		if u.SignupDate == nil {
+			return "", false
+		}
+		return strconv.Itoa(int(now.Sub(*u.SignupDate).Hours() / 24)), true
+	}
+	return "", false
+}
+
+func parseConditionValue(kind, v string) (interface{}, error) {
+	switch kind {
+	case "number":
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value %q is not a number", v)
+		}
+		return f, nil
+	case "bool":
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("value %q is not a boolean", v)
+		}
+		return b, nil
+	case "time":
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, nil
+		}
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return nil, fmt.Errorf("value %q is not a date (RFC3339 or YYYY-MM-DD)", v)
+		}
+		return t, nil
+	}
+	return v, nil
+}
+
+// compareCondition orders actual against want for the attribute kind; strings compare case-insensitively
+func compareCondition(kind, actual, want string) int {
+	a, errA := parseConditionValue(kind, actual)
+	w, errW := parseConditionValue(kind, want)
+	if errA != nil || errW != nil {
+		return strings.Compare(actual, want)
+	}
+	switch av := a.(type) {
+	case float64:
+		wv := w.(float64)
+		switch {
+		case av < wv:
+			return -1
+		case av > wv:
+			return 1
+		}
+		return 0
+	case time.Time:
+		return av.Compare(w.(time.Time))
+	case bool:
+		if av == w.(bool) {
+			return 0
+		}
+		return 1
+	}
+	return strings.Compare(strings.ToLower(actual), strings.ToLower(want))
+}
@@ -5,10 +5,29 @@ type ValidationRequest struct {
 	CouponCode string
 	CartItems  []CartItem
 	OrderTotal float64
+	User       UserContext
 }
 
 type ValidationResponse struct {
-	IsValid  bool    `json:"is_valid"`
-	Discount float64 `json:"discount,omitempty"`
-	Message  string  `json:"message"`
+	IsValid   bool       `json:"is_valid"`
+	Discount  float64    `json:"discount,omitempty"`
+	Message   string     `json:"message"`
+	FreeItems []FreeItem `json:"free_items,omitempty"`
+	NextTier  *NextTier  `json:"next_tier,omitempty"`
+}
+
+// NextTier tells the caller how far the cart is from the next tier of a tiered coupon
+type NextTier struct {
+	Basis         string  `json:"basis"`     // order_total or eligible_qty
+	Threshold     float64 `json:"threshold"` // value the basis must reach
+	Remaining     float64 `json:"remaining"` // amount (or quantity) still to add
+	DiscountType  string  `json:"discount_type"`
+	DiscountValue float64 `json:"discount_value"`
+}
+
+// FreeItem describes units made free (or discounted) by a bogo coupon
+type FreeItem struct {
+	ItemID    string  `json:"item_id"`
+	Qty       int     `json:"qty"`
+	UnitPrice float64 `json:"unit_price"`
 }
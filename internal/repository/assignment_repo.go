@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// assignBatchSize bounds the number of user ids sent per INSERT
+const assignBatchSize = 1000
+
+type AssignmentRepo struct {
+	db *sql.DB
+}
+
+func NewAssignmentRepo(db *sql.DB) *AssignmentRepo {
+	return &AssignmentRepo{db: db}
+}
+
+// AssignUsers issues the coupon to the given users in one transaction.
+// Users that already hold the coupon are skipped; the number of new assignments is returned.
+func (r *AssignmentRepo) AssignUsers(ctx context.Context, couponID int, userIDs []string) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	query := `
+		INSERT INTO coupon_assigned_users (coupon_id, user_id)
+		SELECT $1, unnest($2::text[])
+		ON CONFLICT DO NOTHING
+	`
+
+	added := 0
+	for start := 0; start < len(userIDs); start += assignBatchSize {
+		end := min(start+assignBatchSize, len(userIDs))
+		res, err := tx.ExecContext(ctx, query, couponID, pq.Array(userIDs[start:end]))
+		if err != nil {
+			return 0, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		added += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// AssignedAmong returns which of the given coupons were issued to the user
+func (r *AssignmentRepo) AssignedAmong(ctx context.Context, userID string, couponIDs []int) (map[int]bool, error) {
+	assigned := make(map[int]bool)
+	if len(couponIDs) == 0 {
+		return assigned, nil
+	}
+
+	query := `SELECT coupon_id FROM coupon_assigned_users WHERE user_id = $1 AND coupon_id = ANY($2)`
+	rows, err := r.db.QueryContext(ctx, query, userID, pq.Array(couponIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		assigned[id] = true
+	}
+	return assigned, rows.Err()
+}
+
+// IsAssigned reports whether the coupon was issued to the user
+func (r *AssignmentRepo) IsAssigned(ctx context.Context, couponID int, userID string) (bool, error) {
+	var ok bool
+	query := `SELECT EXISTS (SELECT 1 FROM coupon_assigned_users WHERE coupon_id = $1 AND user_id = $2)`
+	if err := r.db.QueryRowContext(ctx, query, couponID, userID).Scan(&ok); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
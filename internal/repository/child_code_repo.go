@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/couponcode"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/uow"
+)
+
+// ChildCodeRepo stores the generated codes of coupon templates
+type ChildCodeRepo struct {
+	db *sql.DB
+}
+
+func NewChildCodeRepo(db *sql.DB) *ChildCodeRepo {
+	return &ChildCodeRepo{db: db}
+}
+
+const childCodeColumns = `
+	cc.id, cc.coupon_id, c.coupon_code, cc.code, cc.batch, cc.created_at,
+	cc.redeemed_at, COALESCE(cc.redeemed_by, '')`
+
+func scanChildCode(row rowScanner) (*models.ChildCode, error) {
+	var cc models.ChildCode
+	err := row.Scan(&cc.ID, &cc.TemplateID, &cc.TemplateCode, &cc.Code, &cc.Batch, &cc.CreatedAt,
+		&cc.RedeemedAt, &cc.RedeemedBy)
+	if err != nil {
+		return nil, err
+	}
+	return &cc, nil
+}
+
+// GetChildCode looks a child code up in any spelling; nil if there is none
+func (r *ChildCodeRepo) GetChildCode(ctx context.Context, code string) (*models.ChildCode, error) {
+	query := `
+		SELECT ` + childCodeColumns + `
+		FROM coupon_codes cc JOIN coupons c ON c.id = cc.coupon_id
+		WHERE cc.code_norm = $1`
+	cc, err := scanChildCode(r.db.QueryRowContext(ctx, query, models.NormalizeCouponCode(code)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return cc, err
+}
+
+// InsertChildCodes adds codes to the template inside tx and returns how many
+// were new; codes taken by another child code or by a coupon are skipped
+func (r *ChildCodeRepo) InsertChildCodes(ctx context.Context, utx uow.Tx, templateID int, batch string, codes []string) (int, error) {
+	tx, err := uow.SQLTx(utx)
+	if err != nil {
+		return 0, err
+	}
+
+	norms := make([]string, len(codes))
+	for i, c := range codes {
+		norms[i] = models.NormalizeCouponCode(c)
+	}
+	query := `
+		INSERT INTO coupon_codes (coupon_id, code, code_norm, batch)
+		SELECT $1, n.code, n.norm, $4
+		FROM unnest($2::text[], $3::text[]) AS n(code, norm)
+		WHERE NOT EXISTS (SELECT 1 FROM coupons WHERE coupon_code_norm = n.norm)
+		ON CONFLICT (code_norm) DO NOTHING
+	`
+	res, err := tx.ExecContext(ctx, query, templateID, pq.Array(codes), pq.Array(norms), batch)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// RedeemChildCode marks the code redeemed by userID inside tx. It reports false
+// if the code was redeemed already; a concurrent redemption holds the row
+// until its tx ends.
+func (r *ChildCodeRepo) RedeemChildCode(ctx context.Context, utx uow.Tx, id int64, userID string) (bool, error) {
+	tx, err := uow.SQLTx(utx)
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE coupon_codes SET redeemed_at = NOW(), redeemed_by = $2
+		WHERE id = $1 AND redeemed_at IS NULL`, id, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// EachChildCode calls fn for the template's codes in generation order, limited
+// to one batch unless batch is empty. Rows are streamed, not collected.
+func (r *ChildCodeRepo) EachChildCode(ctx context.Context, templateID int, batch string, fn func(*models.ChildCode) error) error {
+	query := `
+		SELECT ` + childCodeColumns + `
+		FROM coupon_codes cc JOIN coupons c ON c.id = cc.coupon_id
+		WHERE cc.coupon_id = $1 AND ($2::text = '' OR cc.batch = $2)
+		ORDER BY cc.id`
+	rows, err := r.db.QueryContext(ctx, query, templateID, batch)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		cc, err := scanChildCode(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(cc); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+const codeFormatColumns = `f.alphabet, f.length, f.prefix, f.check_char, f.mac_length, f.secret`
+
+func scanCodeFormat(row rowScanner, dest ...interface{}) (couponcode.Format, error) {
+	var f couponcode.Format
+	dest = append(dest, &f.Alphabet, &f.Length, &f.Prefix, &f.CheckChar, &f.MACLength, &f.Secret)
+	err := row.Scan(dest...)
+	return f, err
+}
+
+// GetCodeFormat returns the code format of a template; nil if it has none yet
+func (r *ChildCodeRepo) GetCodeFormat(ctx context.Context, templateID int) (*couponcode.Format, error) {
+	query := `SELECT ` + codeFormatColumns + ` FROM coupon_code_formats f WHERE f.coupon_id = $1`
+	f, err := scanCodeFormat(r.db.QueryRowContext(ctx, query, templateID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ListCodeFormats returns the code formats of all templates by template code
+func (r *ChildCodeRepo) ListCodeFormats(ctx context.Context) (map[string]couponcode.Format, error) {
+	query := `
+		SELECT c.coupon_code, ` + codeFormatColumns + `
+		FROM coupon_code_formats f JOIN coupons c ON c.id = f.coupon_id`
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	formats := make(map[string]couponcode.Format)
+	for rows.Next() {
+		var code string
+		f, err := scanCodeFormat(rows, &code)
+		if err != nil {
+			return nil, err
+		}
+		formats[code] = f
+	}
+	return formats, rows.Err()
+}
+
+// CreateCodeFormat stores the code format of a template inside tx, unless it
+// has one already; then the stored format is returned with false. An insert
+// racing another first batch waits for it, and reads its format once it commits.
+func (r *ChildCodeRepo) CreateCodeFormat(ctx context.Context, utx uow.Tx, templateID int, f couponcode.Format) (couponcode.Format, bool, error) {
+	tx, err := uow.SQLTx(utx)
+	if err != nil {
+		return couponcode.Format{}, false, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO coupon_code_formats (coupon_id, alphabet, length, prefix, check_char, mac_length, secret)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (coupon_id) DO NOTHING`,
+		templateID, f.Alphabet, f.Length, f.Prefix, f.CheckChar, f.MACLength, f.Secret)
+	if err != nil {
+		return couponcode.Format{}, false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return couponcode.Format{}, false, err
+	}
+	if n == 1 {
+		return f, true, nil
+	}
+
+	query := `SELECT ` + codeFormatColumns + ` FROM coupon_code_formats f WHERE f.coupon_id = $1`
+	stored, err := scanCodeFormat(tx.QueryRowContext(ctx, query, templateID))
+	return stored, false, err
+}
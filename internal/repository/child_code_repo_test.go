@@ -0,0 +1,84 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/couponcode"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository/pgtest"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/uow"
+)
+
+// TestCreateCodeFormatConcurrent checks that of two first batches the second
+// waits for the first and gets its format instead of a unique violation
+func TestCreateCodeFormatConcurrent(t *testing.T) {
+	ctx := context.Background()
+	db := pgtest.Open(t)
+	var templateID int
+	err := db.QueryRow(`
+		INSERT INTO coupons (coupon_code, coupon_code_norm, expiry_date, usage_type, discount_type, discount_value, target_type, is_template)
+		VALUES ('SMS', 'SMS', now() + interval '1 day', 'multi_use', 'percentage', 10, 'inventory', true)
+		RETURNING id`).Scan(&templateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := repository.NewChildCodeRepo(db)
+	txs := uow.NewSQL(db)
+	newFormat := func() couponcode.Format {
+		t.Helper()
+		f, err := couponcode.NewFormat(couponcode.Spec{Prefix: "SMS-"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	firstFormat, secondFormat := newFormat(), newFormat()
+
+	first, err := txs.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback()
+	second, err := txs.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Rollback()
+
+	if _, created, err := codes.CreateCodeFormat(ctx, first, templateID, firstFormat); err != nil || !created {
+		t.Fatalf("first: %v, %v", created, err)
+	}
+	type result struct {
+		f       couponcode.Format
+		created bool
+		err     error
+	}
+	secondDone := make(chan result, 1)
+	go func() {
+		f, created, err := codes.CreateCodeFormat(ctx, second, templateID, secondFormat)
+		secondDone <- result{f, created, err}
+	}()
+	select {
+	case r := <-secondDone:
+		t.Fatalf("second did not wait for the first: %+v", r)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	r := <-secondDone
+	if r.err != nil || r.created || !bytes.Equal(r.f.Secret, firstFormat.Secret) {
+		t.Fatalf("second: created %v, %v; want the first format", r.created, r.err)
+	}
+	if err := second.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := codes.GetCodeFormat(ctx, templateID)
+	if err != nil || stored == nil || !bytes.Equal(stored.Secret, firstFormat.Secret) {
+		t.Errorf("stored %+v, %v", stored, err)
+	}
+}
@@ -4,8 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
 
 	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/uow"
 )
 
 type CouponRepo struct {
@@ -16,35 +21,60 @@ func NewCouponRepo(db *sql.DB) *CouponRepo {
 	return &CouponRepo{db: db}
 }
 
-func (r *CouponRepo) GetCouponMeta(ctx context.Context, code string) (*models.CouponMeta, error) {
-	var c models.Coupon
+// couponColumns is the column list scanCoupon expects, for a coupons table aliased as c
+const couponColumns = `
+	c.id, c.coupon_code, c.expiry_date, c.usage_type, c.min_order_value,
+	c.min_eligible_qty, c.min_eligible_subtotal, c.valid_from, c.valid_to, c.discount_type, c.discount_value,
+	c.buy_quantity, c.get_quantity, COALESCE(c.tier_basis, ''), c.max_units_discounted,
+	c.max_usage_per_user, c.audience, c.min_prior_orders, c.max_prior_orders,
+	COALESCE(c.rule_expression, ''), c.is_template, c.target_type, c.terms_and_conditions,
+	c.created_at, c.updated_at`
 
-	query := `
-		SELECT id, coupon_code, expiry_date, usage_type, min_order_value,
-		       valid_from, valid_to, discount_type, discount_value,
-		       max_usage_per_user, target_type, terms_and_conditions,
-		       created_at, updated_at
-		FROM coupons
-		WHERE coupon_code = $1;
-	`
+// querier is a *sql.DB or a *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	err := r.db.QueryRowContext(ctx, query, code).Scan(
+func scanCoupon(row rowScanner) (models.Coupon, error) {
+	var c models.Coupon
+	err := row.Scan(
 		&c.ID,
 		&c.CouponCode,
 		&c.ExpiryDate,
 		&c.UsageType,
 		&c.MinOrderValue,
+		&c.MinEligibleQty,
+		&c.MinEligibleSubtotal,
 		&c.ValidFrom,
 		&c.ValidTo,
 		&c.DiscountType,
 		&c.DiscountValue,
+		&c.BuyQty,
+		&c.GetQty,
+		&c.TierBasis,
+		&c.MaxUnitsDiscounted,
 		&c.MaxUsagePerUser,
+		&c.Audience,
+		&c.MinPriorOrders,
+		&c.MaxPriorOrders,
+		&c.RuleExpr,
+		&c.IsTemplate,
 		&c.TargetType,
 		&c.Terms,
 		&c.CreatedAt,
 		&c.UpdatedAt,
 	)
+	return c, err
+}
+
+func (r *CouponRepo) GetCouponMeta(ctx context.Context, code string) (*models.CouponMeta, error) {
+	query := `SELECT ` + couponColumns + ` FROM coupons c WHERE c.coupon_code_norm = $1`
 
+	c, err := scanCoupon(r.db.QueryRowContext(ctx, query, models.NormalizeCouponCode(code)))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -52,57 +82,504 @@ func (r *CouponRepo) GetCouponMeta(ctx context.Context, code string) (*models.Co
 		return nil, err
 	}
 
-	items, err := r.getApplicableItems(ctx, c.ID)
-	if err != nil {
+	meta := &models.CouponMeta{Coupon: c}
+	if err := loadDetails(ctx, r.db, []*models.CouponMeta{meta}); err != nil {
 		return nil, err
 	}
+	return meta, nil
+}
 
-	categories, err := r.getApplicableCategories(ctx, c.ID)
+// ListApplicableCandidates returns the coupons that could apply to a cart of
+// orderTotal at time now for the user, fully loaded, together with the user's
+// usage count per returned coupon id.
+//
+// Expiry, the valid_from/valid_to window, min_order_value and audience are filtered
+// in SQL; everything else is left to the rule pipeline. Templates are only
+// redeemable through their child codes and never listed. The query count is
+// constant regardless of how many coupons exist.
+func (r *CouponRepo) ListApplicableCandidates(ctx context.Context, userID string, now time.Time, orderTotal float64) ([]*models.CouponMeta, map[int]int, error) {
+	query := `
+		SELECT ` + couponColumns + `
+		FROM coupons c
+		WHERE c.expiry_date >= $2
+		  AND NOT c.is_template
+		  AND (c.valid_from IS NULL OR c.valid_from <= $2)
+		  AND (c.valid_to IS NULL OR c.valid_to >= $2)
+		  AND COALESCE(c.min_order_value, 0) <= $3
+		  AND (c.audience = 'public'
+		       OR EXISTS (SELECT 1 FROM coupon_assigned_users au WHERE au.coupon_id = c.id AND au.user_id = $1))
+		ORDER BY c.id
+	`
+	metas, err := queryMetas(ctx, r.db, query, userID, now, orderTotal)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if len(metas) == 0 {
+		return nil, map[int]int{}, nil
 	}
 
-	return &models.CouponMeta{
-		Coupon:               c,
-		ApplicableItems:      items,
-		ApplicableCategories: categories,
-	}, nil
+	usage := make(map[int]int, len(metas))
+	err = eachRow(ctx, r.db, `SELECT coupon_id, usage_count FROM coupon_usage WHERE user_id = $2 AND coupon_id = ANY($1)`,
+		couponIDs(metas), func(rows *sql.Rows) error {
+			var id, n int
+			if err := rows.Scan(&id, &n); err != nil {
+				return err
+			}
+			usage[id] = n
+			return nil
+		}, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return metas, usage, nil
+}
+
+// ListActive returns every coupon except templates not yet expired at now,
+// fully loaded; used to build the in-memory eligibility index
+func (r *CouponRepo) ListActive(ctx context.Context, now time.Time) ([]*models.CouponMeta, error) {
+	query := `SELECT ` + couponColumns + ` FROM coupons c WHERE c.expiry_date >= $1 AND NOT c.is_template ORDER BY c.id`
+	return queryMetas(ctx, r.db, query, now)
 }
 
-func (r *CouponRepo) getApplicableItems(ctx context.Context, couponID int) ([]string, error) {
-	query := `SELECT medicine_id FROM coupon_applicable_items WHERE coupon_id = $1`
-	rows, err := r.db.QueryContext(ctx, query, couponID)
+// queryMetas scans coupons selected with couponColumns and loads their details
+func queryMetas(ctx context.Context, q querier, query string, args ...interface{}) ([]*models.CouponMeta, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var items []string
+	var metas []*models.CouponMeta
 	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
+		c, err := scanCoupon(rows)
+		if err != nil {
 			return nil, err
 		}
-		items = append(items, id)
+		metas = append(metas, &models.CouponMeta{Coupon: c})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(metas) == 0 {
+		return nil, nil
+	}
+
+	if err := loadDetails(ctx, q, metas); err != nil {
+		return nil, err
+	}
+	return metas, nil
+}
+
+// loadDetails fills items, categories, bogo reward side, tiers and conditions for
+// all metas with one query per child table, and compiles rule expressions.
+func loadDetails(ctx context.Context, q querier, metas []*models.CouponMeta) error {
+	byID := make(map[int]*models.CouponMeta, len(metas))
+	for _, m := range metas {
+		byID[m.ID] = m
+	}
+	ids := couponIDs(metas)
+
+	stringLists := []struct {
+		query string
+		add   func(m *models.CouponMeta, v string)
+	}{
+		{`SELECT coupon_id, medicine_id FROM coupon_applicable_items WHERE coupon_id = ANY($1) ORDER BY id`,
+			func(m *models.CouponMeta, v string) { m.ApplicableItems = append(m.ApplicableItems, v) }},
+		{`SELECT coupon_id, category_name FROM coupon_applicable_categories WHERE coupon_id = ANY($1) ORDER BY id`,
+			func(m *models.CouponMeta, v string) { m.ApplicableCategories = append(m.ApplicableCategories, v) }},
+		{`SELECT coupon_id, medicine_id FROM coupon_reward_items WHERE coupon_id = ANY($1) ORDER BY id`,
+			func(m *models.CouponMeta, v string) { m.RewardItems = append(m.RewardItems, v) }},
+		{`SELECT coupon_id, category_name FROM coupon_reward_categories WHERE coupon_id = ANY($1) ORDER BY id`,
+			func(m *models.CouponMeta, v string) { m.RewardCategories = append(m.RewardCategories, v) }},
+	}
+	for _, l := range stringLists {
+		err := eachRow(ctx, q, l.query, ids, func(rows *sql.Rows) error {
+			var id int
+			var v string
+			if err := rows.Scan(&id, &v); err != nil {
+				return err
+			}
+			l.add(byID[id], v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := eachRow(ctx, q, `
+		SELECT coupon_id, threshold, discount_type, discount_value
+		FROM coupon_discount_tiers
+		WHERE coupon_id = ANY($1)
+		ORDER BY coupon_id, threshold`, ids, func(rows *sql.Rows) error {
+		var id int
+		var t models.DiscountTier
+		if err := rows.Scan(&id, &t.Threshold, &t.DiscountType, &t.DiscountValue); err != nil {
+			return err
+		}
+		byID[id].Tiers = append(byID[id].Tiers, t)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = eachRow(ctx, q, `
+		SELECT coupon_id, attribute, operator, vals
+		FROM coupon_eligibility_conditions
+		WHERE coupon_id = ANY($1)
+		ORDER BY id`, ids, func(rows *sql.Rows) error {
+		var id int
+		var c models.EligibilityCondition
+		if err := rows.Scan(&id, &c.Attribute, &c.Operator, pq.Array(&c.Values)); err != nil {
+			return err
+		}
+		byID[id].Conditions = append(byID[id].Conditions, c)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// compile the rule once here; the compiled program travels with the cached meta
+	for _, m := range metas {
+		if m.RuleExpr == "" {
+			continue
+		}
+		m.Rule, err = models.CompileRule(m.RuleExpr)
+		if err != nil {
+			return fmt.Errorf("coupon %s: %w", m.CouponCode, err)
+		}
+	}
+	return nil
+}
+
+// eachRow runs a query whose first parameter is the coupon id array and calls fn per row
+func eachRow(ctx context.Context, q querier, query string, ids []int64, fn func(rows *sql.Rows) error, args ...interface{}) error {
+	rows, err := q.QueryContext(ctx, query, append([]interface{}{pq.Array(ids)}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func couponIDs(metas []*models.CouponMeta) []int64 {
+	ids := make([]int64, len(metas))
+	for i, m := range metas {
+		ids[i] = int64(m.ID)
+	}
+	return ids
+}
+
+// couponParams are the writable coupons columns in the order of the $1..$21
+// placeholders used by Create and Update
+func couponParams(m *models.CouponMeta) []interface{} {
+	return []interface{}{
+		m.CouponCode,
+		m.ExpiryDate,
+		m.UsageType,
+		m.MinOrderValue,
+		m.ValidFrom,
+		m.ValidTo,
+		m.DiscountType,
+		m.DiscountValue,
+		m.MaxUsagePerUser,
+		m.TargetType,
+		m.Terms,
+		m.MinEligibleQty,
+		m.MinEligibleSubtotal,
+		m.BuyQty,
+		m.GetQty,
+		m.TierBasis,
+		m.MaxUnitsDiscounted,
+		m.Audience,
+		m.MinPriorOrders,
+		m.MaxPriorOrders,
+		m.RuleExpr,
+	}
+}
+
+// Create inserts the coupon with its items, categories, bogo reward side,
+// conditions, tiers and initial assignments inside tx and returns its id.
+// Codes already handed out as template child codes are taken too.
+func (r *CouponRepo) Create(ctx context.Context, utx uow.Tx, m *models.CouponMeta, assignedUsers []string) (int, error) {
+	tx, err := uow.SQLTx(utx)
+	if err != nil {
+		return 0, err
+	}
+
+	norm := models.NormalizeCouponCode(m.CouponCode)
+	var childTaken bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM coupon_codes WHERE code_norm = $1)`, norm).Scan(&childTaken)
+	if err != nil {
+		return 0, err
+	}
+	if childTaken {
+		return 0, fmt.Errorf("%w: %s", models.ErrDuplicateCouponCode, m.CouponCode)
+	}
+
+	insertCoupon := `
+		INSERT INTO coupons
+		(coupon_code, expiry_date, usage_type, min_order_value, valid_from, valid_to,
+		 discount_type, discount_value, max_usage_per_user, target_type, terms_and_conditions,
+		 min_eligible_qty, min_eligible_subtotal, buy_quantity, get_quantity, tier_basis,
+		 max_units_discounted, audience, min_prior_orders, max_prior_orders, rule_expression,
+		 is_template, coupon_code_norm, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,NULLIF($16, ''),$17,$18,$19,$20,NULLIF($21, ''),$22,$23,NOW(),NOW())
+		RETURNING id
+	`
+	params := append(couponParams(m), m.IsTemplate, norm)
+	var couponID int
+	if err := tx.QueryRowContext(ctx, insertCoupon, params...).Scan(&couponID); err != nil {
+		if isUniqueViolation(err, couponCodeKey) || isUniqueViolation(err, couponCodeNormKey) {
+			return 0, fmt.Errorf("%w: %s", models.ErrDuplicateCouponCode, m.CouponCode)
+		}
+		return 0, err
+	}
+
+	if err := insertDetails(ctx, tx, couponID, m); err != nil {
+		return 0, err
+	}
+
+	if len(assignedUsers) > 0 {
+		stmt := `INSERT INTO coupon_assigned_users (coupon_id, user_id) SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING`
+		if _, err := tx.ExecContext(ctx, stmt, couponID, pq.Array(assignedUsers)); err != nil {
+			return 0, fmt.Errorf("assign users: %w", err)
+		}
 	}
-	return items, nil
+	return couponID, nil
 }
 
-func (r *CouponRepo) getApplicableCategories(ctx context.Context, couponID int) ([]string, error) {
-	query := `SELECT category_name FROM coupon_applicable_categories WHERE coupon_id = $1`
-	rows, err := r.db.QueryContext(ctx, query, couponID)
+// Update replaces the definition of the coupon matching m.CouponCode inside tx,
+// including all child rows; the stored code, template flag, generated codes,
+// usage and assignments are kept.
+// It returns the coupon id, or 0 if no such coupon exists.
+func (r *CouponRepo) Update(ctx context.Context, utx uow.Tx, m *models.CouponMeta) (int, error) {
+	tx, err := uow.SQLTx(utx)
+	if err != nil {
+		return 0, err
+	}
+
+	update := `
+		UPDATE coupons SET
+		  expiry_date = $2, usage_type = $3, min_order_value = $4, valid_from = $5, valid_to = $6,
+		  discount_type = $7, discount_value = $8, max_usage_per_user = $9, target_type = $10,
+		  terms_and_conditions = $11, min_eligible_qty = $12, min_eligible_subtotal = $13,
+		  buy_quantity = $14, get_quantity = $15, tier_basis = NULLIF($16, ''),
+		  max_units_discounted = $17, audience = $18, min_prior_orders = $19, max_prior_orders = $20,
+		  rule_expression = NULLIF($21, ''), updated_at = NOW()
+		WHERE coupon_code_norm = $1
+		RETURNING id
+	`
+	params := couponParams(m)
+	params[0] = models.NormalizeCouponCode(m.CouponCode)
+	var couponID int
+	err = tx.QueryRowContext(ctx, update, params...).Scan(&couponID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, table := range detailTables {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE coupon_id = $1`, couponID); err != nil {
+			return 0, fmt.Errorf("clear %s: %w", table, err)
+		}
+	}
+	if err := insertDetails(ctx, tx, couponID, m); err != nil {
+		return 0, err
+	}
+	return couponID, nil
+}
+
+// Delete removes the coupon and, by cascade, its child rows, generated codes,
+// usage and assignments. It reports whether the coupon existed.
+func (r *CouponRepo) Delete(ctx context.Context, utx uow.Tx, code string) (bool, error) {
+	tx, err := uow.SQLTx(utx)
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM coupons WHERE coupon_code_norm = $1`, models.NormalizeCouponCode(code))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// HasCodePrefix reports whether a coupon other than a template has a code
+// whose normalized form starts with prefix (normalized)
+func (r *CouponRepo) HasCodePrefix(ctx context.Context, prefix string) (bool, error) {
+	var found bool
+	err := r.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM coupons
+			WHERE left(coupon_code_norm, length($1::text)) = $1::text AND NOT is_template
+		)`, prefix).Scan(&found)
+	return found, err
+}
+
+// TakenCodes returns which of the normalized codes are used by a coupon or by a
+// generated code, in one query
+func (r *CouponRepo) TakenCodes(ctx context.Context, norms []string) (map[string]bool, error) {
+	taken := make(map[string]bool)
+	if len(norms) == 0 {
+		return taken, nil
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT coupon_code_norm FROM coupons WHERE coupon_code_norm = ANY($1)
+		UNION
+		SELECT code_norm FROM coupon_codes WHERE code_norm = ANY($1)`, pq.Array(norms))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var categories []string
 	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
+		var norm string
+		if err := rows.Scan(&norm); err != nil {
 			return nil, err
 		}
-		categories = append(categories, name)
+		taken[norm] = true
+	}
+	return taken, rows.Err()
+}
+
+// List returns coupons ordered by id, fully loaded
+func (r *CouponRepo) List(ctx context.Context, limit, offset int) ([]*models.CouponMeta, error) {
+	query := `SELECT ` + couponColumns + ` FROM coupons c ORDER BY c.id LIMIT $1 OFFSET $2`
+	return queryMetas(ctx, r.db, query, limit, offset)
+}
+
+// eachCouponPage is how many coupons EachCoupon loads per query
+const eachCouponPage = 500
+
+// EachCoupon calls fn for every coupon ordered by id, fully loaded, with the
+// users it is assigned to. The pages are read by id inside one read-only
+// REPEATABLE READ transaction, so concurrent writes neither skip nor repeat a
+// coupon however long fn takes.
+func (r *CouponRepo) EachCoupon(ctx context.Context, fn func(m *models.CouponMeta, assignedUsers []string) error) error {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	query := `SELECT ` + couponColumns + ` FROM coupons c WHERE c.id > $1 ORDER BY c.id LIMIT $2`
+	for after := 0; ; {
+		metas, err := queryMetas(ctx, tx, query, after, eachCouponPage)
+		if err != nil {
+			return err
+		}
+		if len(metas) == 0 {
+			return nil
+		}
+
+		assigned := make(map[int][]string)
+		err = eachRow(ctx, tx, `SELECT coupon_id, user_id FROM coupon_assigned_users WHERE coupon_id = ANY($1) ORDER BY coupon_id, user_id`,
+			couponIDs(metas), func(rows *sql.Rows) error {
+				var id int
+				var user string
+				if err := rows.Scan(&id, &user); err != nil {
+					return err
+				}
+				assigned[id] = append(assigned[id], user)
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+
+		for _, m := range metas {
+			if err := fn(m, assigned[m.ID]); err != nil {
+				return err
+			}
+		}
+		if len(metas) < eachCouponPage {
+			return nil
+		}
+		after = metas[len(metas)-1].ID
+	}
+}
+
+// detailTables are the per-coupon definition tables rewritten by Update
+var detailTables = []string{
+	"coupon_applicable_items",
+	"coupon_applicable_categories",
+	"coupon_reward_items",
+	"coupon_reward_categories",
+	"coupon_eligibility_conditions",
+	"coupon_discount_tiers",
+}
+
+// insertDetails writes the child rows of a coupon definition
+func insertDetails(ctx context.Context, tx *sql.Tx, couponID int, m *models.CouponMeta) error {
+	stringLists := []struct {
+		stmt   string
+		values []string
+	}{
+		{`INSERT INTO coupon_applicable_items (coupon_id, medicine_id) SELECT $1, unnest($2::text[])`, m.ApplicableItems},
+		{`INSERT INTO coupon_applicable_categories (coupon_id, category_name) SELECT $1, unnest($2::text[])`, m.ApplicableCategories},
+		{`INSERT INTO coupon_reward_items (coupon_id, medicine_id) SELECT $1, unnest($2::text[])`, m.RewardItems},
+		{`INSERT INTO coupon_reward_categories (coupon_id, category_name) SELECT $1, unnest($2::text[])`, m.RewardCategories},
+	}
+	for _, l := range stringLists {
+		if len(l.values) == 0 {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, l.stmt, couponID, pq.Array(l.values)); err != nil {
+			return err
+		}
+	}
+
+	stmt := `INSERT INTO coupon_eligibility_conditions (coupon_id, attribute, operator, vals) VALUES ($1, $2, $3, $4)`
+	for _, c := range m.Conditions {
+		if _, err := tx.ExecContext(ctx, stmt, couponID, c.Attribute, c.Operator, pq.Array(c.Values)); err != nil {
+			return err
+		}
+	}
+
+	stmt = `INSERT INTO coupon_discount_tiers (coupon_id, threshold, discount_type, discount_value) VALUES ($1, $2, $3, $4)`
+	for _, t := range m.Tiers {
+		if _, err := tx.ExecContext(ctx, stmt, couponID, t.Threshold, t.DiscountType, t.DiscountValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unique constraints on coupons.coupon_code and its normalized form
+const (
+	couponCodeKey     = "coupons_coupon_code_key"
+	couponCodeNormKey = "coupons_coupon_code_norm_key"
+)
+
+// isUniqueViolation reports whether err is a unique violation of constraint
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
+// LoadCodeNormalization makes models.NormalizeCouponCode strip separators the
+// way the stored normalized codes were, as recorded in code_normalization. It
+// must run before any code is normalized.
+func LoadCodeNormalization(ctx context.Context, db *sql.DB) error {
+	var strip bool
+	if err := db.QueryRowContext(ctx, `SELECT strip_separators FROM code_normalization`).Scan(&strip); err != nil {
+		return fmt.Errorf("load code normalization: %w", err)
 	}
-	return categories, nil
+	models.SetStripCodeSeparators(strip)
+	return nil
 }
@@ -0,0 +1,121 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository/pgtest"
+)
+
+func TestCodeSeparatorStripping(t *testing.T) {
+	ctx := context.Background()
+	db := pgtest.Open(t)
+	defer models.SetStripCodeSeparators(true)
+
+	insert := func(code string) error {
+		_, err := db.Exec(`
+			INSERT INTO coupons (coupon_code, coupon_code_norm, expiry_date, usage_type, discount_type, discount_value, target_type)
+			VALUES ($1, $2, now() + interval '1 day', 'multi_use', 'percentage', 10, 'inventory')`,
+			code, models.NormalizeCouponCode(code))
+		return err
+	}
+	norms := func() map[string]string {
+		t.Helper()
+		rows, err := db.Query(`SELECT coupon_code, coupon_code_norm FROM coupons`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		out := map[string]string{}
+		for rows.Next() {
+			var code, norm string
+			if err := rows.Scan(&code, &norm); err != nil {
+				t.Fatal(err)
+			}
+			out[code] = norm
+		}
+		return out
+	}
+
+	if err := repository.LoadCodeNormalization(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert("Save-10"); err != nil {
+		t.Fatal(err)
+	}
+	if norm := norms()["Save-10"]; norm != "SAVE10" {
+		t.Fatalf("stored %q with separators stripped", norm)
+	}
+
+	if _, err := db.Exec(`SELECT set_code_separator_stripping(false)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := repository.LoadCodeNormalization(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if got := models.NormalizeCouponCode("save-10"); got != "SAVE-10" {
+		t.Errorf("normalized to %q after loading strip=false", got)
+	}
+	if norm := norms()["Save-10"]; norm != "SAVE-10" {
+		t.Errorf("stored %q after set_code_separator_stripping(false)", norm)
+	}
+
+	// distinct again without stripping; stripping would make them collide
+	if err := insert("SAVE10"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`SELECT set_code_separator_stripping(true)`); err == nil {
+		t.Error("stripping separators made two codes collide")
+	}
+	var strip bool
+	if err := db.QueryRow(`SELECT strip_separators FROM code_normalization`).Scan(&strip); err != nil || strip {
+		t.Errorf("setting after the failed change: %v, %v", strip, err)
+	}
+}
+
+func TestEachCouponSnapshot(t *testing.T) {
+	ctx := context.Background()
+	db := pgtest.Open(t)
+
+	// more than one page, so the keyset step is crossed
+	const n = 501
+	_, err := db.Exec(`
+		INSERT INTO coupons (coupon_code, coupon_code_norm, expiry_date, usage_type, discount_type, discount_value, target_type, audience)
+		SELECT 'C' || g, 'C' || g, now() + interval '1 day', 'multi_use', 'percentage', 10, 'inventory',
+		       CASE WHEN g = 1 THEN 'assigned' ELSE 'public' END
+		FROM generate_series(1, $1) g`, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO coupon_assigned_users (coupon_id, user_id) SELECT id, u FROM coupons, unnest(ARRAY['v2', 'v1']) u WHERE coupon_code = 'C1'`); err != nil {
+		t.Fatal(err)
+	}
+
+	var codes []string
+	err = repository.NewCouponRepo(db).EachCoupon(ctx, func(m *models.CouponMeta, assignedUsers []string) error {
+		if len(codes) == 0 {
+			// writes after the snapshot are not seen: the last coupon stays, the new one is missing
+			if _, err := db.Exec(`DELETE FROM coupons WHERE coupon_code = $1`, fmt.Sprintf("C%d", n)); err != nil {
+				return err
+			}
+			if _, err := db.Exec(`UPDATE coupons SET coupon_code = 'NEW', coupon_code_norm = 'NEW' WHERE coupon_code = 'C2'`); err != nil {
+				return err
+			}
+			if !slices.Equal(assignedUsers, []string{"v1", "v2"}) {
+				t.Errorf("C1 assigned to %v", assignedUsers)
+			}
+		}
+		codes = append(codes, m.CouponCode)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != n || codes[1] != "C2" || codes[n-1] != fmt.Sprintf("C%d", n) {
+		t.Errorf("streamed %d coupons, second %s, last %s", len(codes), codes[1], codes[len(codes)-1])
+	}
+}
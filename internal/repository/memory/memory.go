@@ -0,0 +1,783 @@
+// Package memory implements the service repositories in process memory, for
+// tests without Postgres.
+//
+// The semantics follow the Postgres repositories: GetAndLockUsage creates the
+// usage row if needed and holds a row lock until the unit of work ends (like
+// SELECT ... FOR UPDATE), increments only become visible on Commit, and
+// non-transactional reads see committed data only.
+package memory
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/couponcode"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/uow"
+)
+
+var errNotLocked = errors.New("memory: usage row not locked by this transaction")
+
+// Store holds all data; the repositories are views onto one Store
+type Store struct {
+	mu       sync.Mutex
+	nextID   int
+	coupons  map[string]*models.CouponMeta // by normalized code
+	assigned map[int]map[string]bool       // coupon id -> user ids
+	usage    map[usageKey]*usageRow
+
+	nextCodeID int64
+	codes      map[string]*childCode // template child codes by normalized code
+	codeIDs    map[int64]*childCode
+	formats    map[int]couponcode.Format // template id -> code format
+
+	// coupon writes hold this token until their unit of work ends, so they are
+	// serialized like writers on one table
+	writes chan struct{}
+}
+
+type usageKey struct {
+	couponID int
+	userID   string
+}
+
+type childCode struct {
+	models.ChildCode
+	lock chan struct{} // like usageRow.lock, held while a redemption is pending
+}
+
+type usageRow struct {
+	count    int
+	lastUsed time.Time
+	lock     chan struct{} // holds a token while a unit of work has the row locked
+}
+
+func NewStore() *Store {
+	return &Store{
+		coupons:  make(map[string]*models.CouponMeta),
+		assigned: make(map[int]map[string]bool),
+		usage:    make(map[usageKey]*usageRow),
+		codes:    make(map[string]*childCode),
+		codeIDs:  make(map[int64]*childCode),
+		formats:  make(map[int]couponcode.Format),
+		writes:   make(chan struct{}, 1),
+	}
+}
+
+// --- units of work ---
+
+type tx struct {
+	s         *Store
+	held      []*usageRow
+	incs      map[*usageRow]int
+	heldCodes []*childCode
+	redeems   map[*childCode]string // child code -> redeeming user, applied on Commit
+	newCodes  map[string]bool       // normalized child codes inserted by this tx
+	writing   bool                  // holds s.writes
+	ops       []func()              // coupon writes, applied on Commit under s.mu
+	done      bool
+}
+
+// Begin starts a unit of work; see BeginSerializable
+func (s *Store) Begin(ctx context.Context) (uow.Tx, error) {
+	return s.BeginSerializable(ctx)
+}
+
+// BeginSerializable starts a unit of work. Isolation comes from the row locks
+// taken by GetAndLockUsage and the store-wide lock taken by coupon writes.
+func (s *Store) BeginSerializable(ctx context.Context) (uow.Tx, error) {
+	return &tx{
+		s:        s,
+		incs:     make(map[*usageRow]int),
+		redeems:  make(map[*childCode]string),
+		newCodes: make(map[string]bool),
+	}, nil
+}
+
+func (t *tx) Commit() error {
+	if t.done {
+		return nil
+	}
+	t.s.mu.Lock()
+	now := time.Now()
+	for row, n := range t.incs {
+		row.count += n
+		row.lastUsed = now
+	}
+	for cc, userID := range t.redeems {
+		cc.RedeemedAt, cc.RedeemedBy = &now, userID
+	}
+	for _, op := range t.ops {
+		op()
+	}
+	t.s.mu.Unlock()
+	t.release()
+	return nil
+}
+
+func (t *tx) Rollback() error {
+	if !t.done {
+		t.release()
+	}
+	return nil
+}
+
+func (t *tx) release() {
+	t.done = true
+	for _, row := range t.held {
+		<-row.lock
+	}
+	t.held = nil
+	for _, cc := range t.heldCodes {
+		<-cc.lock
+	}
+	t.heldCodes = nil
+	if t.writing {
+		<-t.s.writes
+		t.writing = false
+	}
+}
+
+// lockWrites waits until no other unit of work is writing coupons
+func (t *tx) lockWrites(ctx context.Context) error {
+	if t.writing {
+		return nil
+	}
+	select {
+	case t.s.writes <- struct{}{}:
+		t.writing = true
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *tx) holds(row *usageRow) bool {
+	return slices.Contains(t.held, row)
+}
+
+func (s *Store) txOf(utx uow.Tx) (*tx, error) {
+	t, ok := utx.(*tx)
+	if !ok || t.s != s {
+		return nil, uow.ErrForeignTx
+	}
+	return t, nil
+}
+
+// --- coupons ---
+
+type CouponRepo struct {
+	s *Store
+}
+
+func NewCouponRepo(s *Store) *CouponRepo {
+	return &CouponRepo{s: s}
+}
+
+// Put stores a coupon, replacing one with the same code, and assigns an id if
+// it has none. The store keeps its own copy.
+func (r *CouponRepo) Put(m *models.CouponMeta) *models.CouponMeta {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	c := stored(m)
+	key := models.NormalizeCouponCode(c.CouponCode)
+	if old, ok := r.s.coupons[key]; ok && c.ID == 0 {
+		c.ID = old.ID
+	}
+	if c.ID == 0 {
+		r.s.nextID++
+		c.ID = r.s.nextID
+	}
+	r.s.nextID = max(r.s.nextID, c.ID)
+	r.s.coupons[key] = c
+	return clone(c)
+}
+
+func (r *CouponRepo) GetCouponMeta(ctx context.Context, code string) (*models.CouponMeta, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	m, ok := r.s.coupons[models.NormalizeCouponCode(code)]
+	if !ok {
+		return nil, nil
+	}
+	return clone(m), nil
+}
+
+// ListApplicableCandidates applies the same prefilter as the SQL query:
+// expiry, window, min order value and audience
+func (r *CouponRepo) ListApplicableCandidates(ctx context.Context, userID string, now time.Time, orderTotal float64) ([]*models.CouponMeta, map[int]int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var out []*models.CouponMeta
+	usage := make(map[int]int)
+	for _, m := range r.s.coupons {
+		if m.IsTemplate || !activeAt(m, now) || m.MinOrderValue > orderTotal {
+			continue
+		}
+		if m.Audience != "public" && !r.s.assigned[m.ID][userID] {
+			continue
+		}
+		out = append(out, clone(m))
+		if row, ok := r.s.usage[usageKey{m.ID, userID}]; ok {
+			usage[m.ID] = row.count
+		}
+	}
+	sortByID(out)
+	return out, usage, nil
+}
+
+func (r *CouponRepo) ListActive(ctx context.Context, now time.Time) ([]*models.CouponMeta, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var out []*models.CouponMeta
+	for _, m := range r.s.coupons {
+		if !m.IsTemplate && !m.ExpiryDate.Before(now) {
+			out = append(out, clone(m))
+		}
+	}
+	sortByID(out)
+	return out, nil
+}
+
+// Create stores a new coupon and its initial assignments when tx commits.
+// The id is allocated immediately, like a sequence.
+func (r *CouponRepo) Create(ctx context.Context, utx uow.Tx, m *models.CouponMeta, assignedUsers []string) (int, error) {
+	t, err := r.s.txOf(utx)
+	if err != nil {
+		return 0, err
+	}
+	if err := t.lockWrites(ctx); err != nil {
+		return 0, err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	key := models.NormalizeCouponCode(m.CouponCode)
+	_, taken := r.s.coupons[key]
+	if _, ok := r.s.codes[key]; taken || ok {
+		return 0, fmt.Errorf("%w: %s", models.ErrDuplicateCouponCode, m.CouponCode)
+	}
+	r.s.nextID++
+	c := stored(m)
+	c.ID = r.s.nextID
+	c.CreatedAt, c.UpdatedAt = time.Now(), time.Now()
+	users := slices.Clone(assignedUsers)
+
+	t.ops = append(t.ops, func() {
+		r.s.coupons[key] = c
+		if len(users) > 0 {
+			set := make(map[string]bool, len(users))
+			for _, uid := range users {
+				set[uid] = true
+			}
+			r.s.assigned[c.ID] = set
+		}
+	})
+	return c.ID, nil
+}
+
+// Update replaces the definition of the coupon with m.CouponCode when tx
+// commits; returns its id, or 0 if it doesn't exist
+func (r *CouponRepo) Update(ctx context.Context, utx uow.Tx, m *models.CouponMeta) (int, error) {
+	t, err := r.s.txOf(utx)
+	if err != nil {
+		return 0, err
+	}
+	if err := t.lockWrites(ctx); err != nil {
+		return 0, err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	key := models.NormalizeCouponCode(m.CouponCode)
+	old, ok := r.s.coupons[key]
+	if !ok {
+		return 0, nil
+	}
+	c := stored(m)
+	c.ID, c.CouponCode, c.IsTemplate = old.ID, old.CouponCode, old.IsTemplate
+	c.CreatedAt, c.UpdatedAt = old.CreatedAt, time.Now()
+
+	t.ops = append(t.ops, func() {
+		r.s.coupons[key] = c
+	})
+	return c.ID, nil
+}
+
+// Delete removes the coupon with its usage and assignments when tx commits
+func (r *CouponRepo) Delete(ctx context.Context, utx uow.Tx, code string) (bool, error) {
+	t, err := r.s.txOf(utx)
+	if err != nil {
+		return false, err
+	}
+	if err := t.lockWrites(ctx); err != nil {
+		return false, err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	key := models.NormalizeCouponCode(code)
+	old, ok := r.s.coupons[key]
+	if !ok {
+		return false, nil
+	}
+
+	t.ops = append(t.ops, func() {
+		delete(r.s.coupons, key)
+		delete(r.s.assigned, old.ID)
+		for key := range r.s.usage {
+			if key.couponID == old.ID {
+				delete(r.s.usage, key)
+			}
+		}
+		for key, cc := range r.s.codes {
+			if cc.TemplateID == old.ID {
+				delete(r.s.codes, key)
+				delete(r.s.codeIDs, cc.ID)
+			}
+		}
+		delete(r.s.formats, old.ID)
+	})
+	return true, nil
+}
+
+// HasCodePrefix reports whether a coupon other than a template has a code
+// whose normalized form starts with prefix (normalized)
+func (r *CouponRepo) HasCodePrefix(ctx context.Context, prefix string) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for key, m := range r.s.coupons {
+		if !m.IsTemplate && strings.HasPrefix(key, prefix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TakenCodes returns which of the normalized codes are used by a coupon or by a
+// generated code
+func (r *CouponRepo) TakenCodes(ctx context.Context, norms []string) (map[string]bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	taken := make(map[string]bool)
+	for _, norm := range norms {
+		if _, ok := r.s.coupons[norm]; ok {
+			taken[norm] = true
+		} else if _, ok := r.s.codes[norm]; ok {
+			taken[norm] = true
+		}
+	}
+	return taken, nil
+}
+
+// List returns coupons ordered by id
+func (r *CouponRepo) List(ctx context.Context, limit, offset int) ([]*models.CouponMeta, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	all := make([]*models.CouponMeta, 0, len(r.s.coupons))
+	for _, m := range r.s.coupons {
+		all = append(all, m)
+	}
+	sortByID(all)
+
+	var out []*models.CouponMeta
+	for i := offset; i < len(all) && len(out) < limit; i++ {
+		out = append(out, clone(all[i]))
+	}
+	return out, nil
+}
+
+// EachCoupon calls fn for every coupon ordered by id with its assigned users,
+// sorted. Like the Postgres repo it reads one snapshot, taken before fn runs.
+func (r *CouponRepo) EachCoupon(ctx context.Context, fn func(m *models.CouponMeta, assignedUsers []string) error) error {
+	r.s.mu.Lock()
+	all := make([]*models.CouponMeta, 0, len(r.s.coupons))
+	for _, m := range r.s.coupons {
+		all = append(all, clone(m))
+	}
+	assigned := make(map[int][]string, len(r.s.assigned))
+	for id, users := range r.s.assigned {
+		assigned[id] = slices.Sorted(maps.Keys(users))
+	}
+	r.s.mu.Unlock()
+
+	sortByID(all)
+	for _, m := range all {
+		if err := fn(m, assigned[m.ID]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- template child codes ---
+
+type ChildCodeRepo struct {
+	s *Store
+}
+
+func NewChildCodeRepo(s *Store) *ChildCodeRepo {
+	return &ChildCodeRepo{s: s}
+}
+
+func (r *ChildCodeRepo) GetChildCode(ctx context.Context, code string) (*models.ChildCode, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	cc, ok := r.s.codes[models.NormalizeCouponCode(code)]
+	if !ok {
+		return nil, nil
+	}
+	c := cc.ChildCode
+	return &c, nil
+}
+
+// InsertChildCodes adds the codes when tx commits, skipping codes that are
+// taken by a coupon, another child code or an earlier insert of tx
+func (r *ChildCodeRepo) InsertChildCodes(ctx context.Context, utx uow.Tx, templateID int, batch string, codes []string) (int, error) {
+	t, err := r.s.txOf(utx)
+	if err != nil {
+		return 0, err
+	}
+	if err := t.lockWrites(ctx); err != nil {
+		return 0, err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	var template *models.CouponMeta
+	for _, m := range r.s.coupons {
+		if m.ID == templateID {
+			template = m
+		}
+	}
+	if template == nil {
+		return 0, nil
+	}
+
+	var added []*childCode
+	for _, code := range codes {
+		key := models.NormalizeCouponCode(code)
+		_, coupon := r.s.coupons[key]
+		_, child := r.s.codes[key]
+		if coupon || child || t.newCodes[key] {
+			continue
+		}
+		t.newCodes[key] = true
+		r.s.nextCodeID++
+		added = append(added, &childCode{
+			ChildCode: models.ChildCode{
+				ID:           r.s.nextCodeID,
+				TemplateID:   templateID,
+				TemplateCode: template.CouponCode,
+				Code:         code,
+				Batch:        batch,
+				CreatedAt:    time.Now(),
+			},
+			lock: make(chan struct{}, 1),
+		})
+	}
+
+	t.ops = append(t.ops, func() {
+		for _, cc := range added {
+			r.s.codes[models.NormalizeCouponCode(cc.Code)] = cc
+			r.s.codeIDs[cc.ID] = cc
+		}
+	})
+	return len(added), nil
+}
+
+// RedeemChildCode waits for the code's lock like UPDATE does for the row and
+// reports false if it was redeemed already; the redemption is applied on Commit
+func (r *ChildCodeRepo) RedeemChildCode(ctx context.Context, utx uow.Tx, id int64, userID string) (bool, error) {
+	t, err := r.s.txOf(utx)
+	if err != nil {
+		return false, err
+	}
+
+	r.s.mu.Lock()
+	cc, ok := r.s.codeIDs[id]
+	r.s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	if !slices.Contains(t.heldCodes, cc) {
+		select {
+		case cc.lock <- struct{}{}:
+			t.heldCodes = append(t.heldCodes, cc)
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if _, pending := t.redeems[cc]; pending || cc.RedeemedAt != nil {
+		return false, nil
+	}
+	t.redeems[cc] = userID
+	return true, nil
+}
+
+// EachChildCode calls fn for the template's codes in generation order, limited
+// to one batch unless batch is empty
+func (r *ChildCodeRepo) EachChildCode(ctx context.Context, templateID int, batch string, fn func(*models.ChildCode) error) error {
+	r.s.mu.Lock()
+	var out []models.ChildCode
+	for _, cc := range r.s.codeIDs {
+		if cc.TemplateID == templateID && (batch == "" || cc.Batch == batch) {
+			out = append(out, cc.ChildCode)
+		}
+	}
+	r.s.mu.Unlock()
+
+	slices.SortFunc(out, func(a, b models.ChildCode) int { return cmp.Compare(a.ID, b.ID) })
+	for i := range out {
+		if err := fn(&out[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *ChildCodeRepo) GetCodeFormat(ctx context.Context, templateID int) (*couponcode.Format, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	f, ok := r.s.formats[templateID]
+	if !ok {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+func (r *ChildCodeRepo) ListCodeFormats(ctx context.Context) (map[string]couponcode.Format, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	formats := make(map[string]couponcode.Format, len(r.s.formats))
+	for _, m := range r.s.coupons {
+		if f, ok := r.s.formats[m.ID]; ok {
+			formats[m.CouponCode] = f
+		}
+	}
+	return formats, nil
+}
+
+// CreateCodeFormat stores the format when tx commits, unless the template has
+// one already; then the stored format is returned with false
+func (r *ChildCodeRepo) CreateCodeFormat(ctx context.Context, utx uow.Tx, templateID int, f couponcode.Format) (couponcode.Format, bool, error) {
+	t, err := r.s.txOf(utx)
+	if err != nil {
+		return couponcode.Format{}, false, err
+	}
+	if err := t.lockWrites(ctx); err != nil {
+		return couponcode.Format{}, false, err
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if stored, ok := r.s.formats[templateID]; ok {
+		return stored, false, nil
+	}
+	t.ops = append(t.ops, func() {
+		r.s.formats[templateID] = f
+	})
+	return f, true, nil
+}
+
+// --- usage ---
+
+type UsageRepo struct {
+	s *Store
+}
+
+func NewUsageRepo(s *Store) *UsageRepo {
+	return &UsageRepo{s: s}
+}
+
+// GetAndLockUsage creates the row if needed and waits for its lock, like
+// INSERT ... ON CONFLICT DO NOTHING followed by SELECT ... FOR UPDATE. Unlike
+// Postgres it never fails with a serialization failure when another
+// transaction created the row concurrently.
+func (r *UsageRepo) GetAndLockUsage(ctx context.Context, utx uow.Tx, couponID int, userID string) (int, error) {
+	t, err := r.s.txOf(utx)
+	if err != nil {
+		return 0, err
+	}
+
+	r.s.mu.Lock()
+	key := usageKey{couponID, userID}
+	row, ok := r.s.usage[key]
+	if !ok {
+		row = &usageRow{lock: make(chan struct{}, 1)}
+		r.s.usage[key] = row
+	}
+	r.s.mu.Unlock()
+
+	if !t.holds(row) {
+		select {
+		case row.lock <- struct{}{}:
+			t.held = append(t.held, row)
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return row.count + t.incs[row], nil
+}
+
+// IncrementUsage requires the row to be locked by utx; it is applied on Commit
+func (r *UsageRepo) IncrementUsage(ctx context.Context, utx uow.Tx, couponID int, userID string) error {
+	t, err := r.s.txOf(utx)
+	if err != nil {
+		return err
+	}
+
+	r.s.mu.Lock()
+	row := r.s.usage[usageKey{couponID, userID}]
+	r.s.mu.Unlock()
+	if row == nil || !t.holds(row) {
+		return errNotLocked
+	}
+	t.incs[row]++
+	return nil
+}
+
+func (r *UsageRepo) GetUsageCount(ctx context.Context, couponID int, userID string) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if row, ok := r.s.usage[usageKey{couponID, userID}]; ok {
+		return row.count, nil
+	}
+	return 0, nil
+}
+
+func (r *UsageRepo) GetUsageCounts(ctx context.Context, userID string, couponIDs []int) (map[int]int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	counts := make(map[int]int)
+	for _, id := range couponIDs {
+		if row, ok := r.s.usage[usageKey{id, userID}]; ok {
+			counts[id] = row.count
+		}
+	}
+	return counts, nil
+}
+
+func (r *UsageRepo) CountUserRedemptions(ctx context.Context, userID string) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	n := 0
+	for key, row := range r.s.usage {
+		if key.userID == userID {
+			n += row.count
+		}
+	}
+	return n, nil
+}
+
+// --- assignments ---
+
+type AssignmentRepo struct {
+	s *Store
+}
+
+func NewAssignmentRepo(s *Store) *AssignmentRepo {
+	return &AssignmentRepo{s: s}
+}
+
+func (r *AssignmentRepo) AssignUsers(ctx context.Context, couponID int, userIDs []string) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	users, ok := r.s.assigned[couponID]
+	if !ok {
+		users = make(map[string]bool)
+		r.s.assigned[couponID] = users
+	}
+	added := 0
+	for _, uid := range userIDs {
+		if !users[uid] {
+			users[uid] = true
+			added++
+		}
+	}
+	return added, nil
+}
+
+func (r *AssignmentRepo) IsAssigned(ctx context.Context, couponID int, userID string) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	return r.s.assigned[couponID][userID], nil
+}
+
+func (r *AssignmentRepo) AssignedAmong(ctx context.Context, userID string, couponIDs []int) (map[int]bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	assigned := make(map[int]bool)
+	for _, id := range couponIDs {
+		if r.s.assigned[id][userID] {
+			assigned[id] = true
+		}
+	}
+	return assigned, nil
+}
+
+// --- helpers ---
+
+func activeAt(m *models.CouponMeta, now time.Time) bool {
+	if m.ExpiryDate.Before(now) {
+		return false
+	}
+	if m.ValidFrom != nil && now.Before(*m.ValidFrom) {
+		return false
+	}
+	return m.ValidTo == nil || !now.After(*m.ValidTo)
+}
+
+func sortByID(ms []*models.CouponMeta) {
+	sort.Slice(ms, func(i, j int) bool { return ms[i].ID < ms[j].ID })
+}
+
+// stored is the copy of m the store keeps, with tiers in threshold order like the SQL repository
+func stored(m *models.CouponMeta) *models.CouponMeta {
+	c := clone(m)
+	slices.SortStableFunc(c.Tiers, func(a, b models.DiscountTier) int { return cmp.Compare(a.Threshold, b.Threshold) })
+	return c
+}
+
+// clone copies m so callers can't mutate stored data; the compiled rule is
+// immutable and shared
+func clone(m *models.CouponMeta) *models.CouponMeta {
+	c := *m
+	c.ApplicableItems = slices.Clone(m.ApplicableItems)
+	c.ApplicableCategories = slices.Clone(m.ApplicableCategories)
+	c.RewardItems = slices.Clone(m.RewardItems)
+	c.RewardCategories = slices.Clone(m.RewardCategories)
+	c.Tiers = slices.Clone(m.Tiers)
+	c.Conditions = slices.Clone(m.Conditions)
+	return &c
+}
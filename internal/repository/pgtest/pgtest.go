@@ -0,0 +1,91 @@
+// Package pgtest gives tests a migrated Postgres schema of their own. Tests
+// using it are skipped unless COUPON_TEST_DSN points at a database they may
+// create schemas in, e.g.
+//
+//	COUPON_TEST_DSN="postgres://postgres@localhost/coupons_test?sslmode=disable" go test ./...
+package pgtest
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// Open creates a schema, applies the up migrations to it and returns a pool
+// whose connections use it. The schema is dropped when the test ends.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("COUPON_TEST_DSN")
+	if dsn == "" {
+		t.Skip("COUPON_TEST_DSN not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("coupon_test_%d_%d", os.Getpid(), time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("drop schema %s: %v", schema, err)
+		}
+		admin.Close()
+	})
+
+	db, err := sql.Open("postgres", withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// withSearchPath adds search_path to dsn; lib/pq sends unknown parameters to
+// the server as settings
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&search_path=" + schema
+	}
+	return dsn + "?search_path=" + schema
+}
+
+// migrate runs the "+goose Up" section of every migration in order
+func migrate(db *sql.DB) error {
+	_, file, _, _ := runtime.Caller(0)
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(file), "..", "..", "..", "migrations", "*.sql"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no migrations found")
+	}
+	for _, f := range files { // Glob sorts
+		src, err := os.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		up, _, _ := strings.Cut(string(src), "-- +goose Down")
+		// without arguments lib/pq uses the simple protocol, which runs several statements
+		if _, err := db.Exec(up); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+	}
+	return nil
+}
@@ -5,6 +5,10 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/uow"
 )
 
 type UsageRepo struct {
@@ -15,43 +19,97 @@ func NewUsageRepo(db *sql.DB) *UsageRepo {
 	return &UsageRepo{db: db}
 }
 
-// Get or create usage row AND lock it for update
-func (r *UsageRepo) GetAndLockUsage(ctx context.Context, tx *sql.Tx, couponID int, userID string) (int, error) {
-	var usageCount int
+// Get or create usage row AND lock it for update.
+// The row is created with ON CONFLICT DO NOTHING, so a first use never fails
+// with a unique violation. Concurrent first uses are not serialized by it,
+// though: redemptions run SERIALIZABLE, and when another transaction inserts
+// the row after this one's snapshot, the insert waits for it and then fails
+// with a serialization failure (40001). An existing row locked after another
+// transaction updated it fails the same way. Correctness rests on the service
+// retrying 40001 in a fresh transaction (see service/retry.go), and a burst of
+// n redemptions by one user costs up to n-1 retries. An advisory lock taken
+// here would not help; the snapshot predates the wait for it.
+func (r *UsageRepo) GetAndLockUsage(ctx context.Context, utx uow.Tx, couponID int, userID string) (int, error) {
+	tx, err := uow.SQLTx(utx)
+	if err != nil {
+		return 0, err
+	}
 
+	insert := `
+		INSERT INTO coupon_usage (coupon_id, user_id, usage_count, last_used)
+		VALUES ($1, $2, 0, NOW())
+		ON CONFLICT (coupon_id, user_id) DO NOTHING
+	`
+	if _, err := tx.ExecContext(ctx, insert, couponID, userID); err != nil {
+		return 0, err
+	}
+
+	var usageCount int
 	query := `
 		SELECT usage_count
 		FROM coupon_usage
 		WHERE coupon_id = $1 AND user_id = $2
 		FOR UPDATE
 	`
+	if err := tx.QueryRowContext(ctx, query, couponID, userID).Scan(&usageCount); err != nil {
+		return 0, err
+	}
+	return usageCount, nil
+}
 
-	err := tx.QueryRowContext(ctx, query, couponID, userID).Scan(&usageCount)
+// GetUsageCount reads the usage count without locking; 0 if the user never used the coupon
+func (r *UsageRepo) GetUsageCount(ctx context.Context, couponID int, userID string) (int, error) {
+	var n int
+	query := `SELECT usage_count FROM coupon_usage WHERE coupon_id = $1 AND user_id = $2`
+	err := r.db.QueryRowContext(ctx, query, couponID, userID).Scan(&n)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// GetUsageCounts reads the user's usage of several coupons at once without locking;
+// coupons the user never used are absent from the map
+func (r *UsageRepo) GetUsageCounts(ctx context.Context, userID string, couponIDs []int) (map[int]int, error) {
+	counts := make(map[int]int)
+	if len(couponIDs) == 0 {
+		return counts, nil
+	}
 
+	query := `SELECT coupon_id, usage_count FROM coupon_usage WHERE user_id = $1 AND coupon_id = ANY($2)`
+	rows, err := r.db.QueryContext(ctx, query, userID, pq.Array(couponIDs))
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			// Create new row
-			insert := `
-				INSERT INTO coupon_usage (coupon_id, user_id, usage_count, last_used)
-				VALUES ($1, $2, 0, NOW())
-				RETURNING usage_count
-			`
-
-			err := tx.QueryRowContext(ctx, insert, couponID, userID).Scan(&usageCount)
-			if err != nil {
-				return 0, err
-			}
-
-			return usageCount, nil
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
 		}
-		return 0, err
+		counts[id] = n
 	}
+	return counts, rows.Err()
+}
 
-	return usageCount, nil
+// CountUserRedemptions returns how many times the user redeemed any coupon
+func (r *UsageRepo) CountUserRedemptions(ctx context.Context, userID string) (int, error) {
+	var n int
+	query := `SELECT COALESCE(SUM(usage_count), 0) FROM coupon_usage WHERE user_id = $1`
+	if err := r.db.QueryRowContext(ctx, query, userID).Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
 }
 
 // Increment usage safely inside transaction
-func (r *UsageRepo) IncrementUsage(ctx context.Context, tx *sql.Tx, couponID int, userID string) error {
+func (r *UsageRepo) IncrementUsage(ctx context.Context, utx uow.Tx, couponID int, userID string) error {
+	tx, err := uow.SQLTx(utx)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE coupon_usage
 		SET usage_count = usage_count + 1,
@@ -59,6 +117,6 @@ func (r *UsageRepo) IncrementUsage(ctx context.Context, tx *sql.Tx, couponID int
 		WHERE coupon_id = $1 AND user_id = $2
 	`
 
-	_, err := tx.ExecContext(ctx, query, couponID, userID, time.Now())
+	_, err = tx.ExecContext(ctx, query, couponID, userID, time.Now())
 	return err
 }
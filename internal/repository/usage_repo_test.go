@@ -0,0 +1,89 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository/pgtest"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/uow"
+)
+
+// TestGetAndLockUsageConcurrentFirstUse shows what GetAndLockUsage's comment
+// describes: when another transaction creates the usage row after this one's
+// snapshot, the insert waits for it and then fails with a serialization
+// failure instead of doing nothing.
+func TestGetAndLockUsageConcurrentFirstUse(t *testing.T) {
+	ctx := context.Background()
+	db := pgtest.Open(t)
+	var couponID int
+	err := db.QueryRow(`
+		INSERT INTO coupons (coupon_code, coupon_code_norm, expiry_date, usage_type, discount_type, discount_value, target_type)
+		VALUES ('FIRST', 'FIRST', now() + interval '1 day', 'multi_use', 'percentage', 10, 'inventory')
+		RETURNING id`).Scan(&couponID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := repository.NewUsageRepo(db)
+	txs := uow.NewSQL(db)
+
+	first, err := txs.BeginSerializable(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Rollback()
+	second, err := txs.BeginSerializable(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Rollback()
+	// the second transaction's snapshot is taken by its first statement,
+	// before the row exists
+	secondTx, err := uow.SQLTx(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secondTx.Exec(`SELECT 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := usage.GetAndLockUsage(ctx, first, couponID, "u1"); err != nil || n != 0 {
+		t.Fatalf("first: %d, %v", n, err)
+	}
+	secondErr := make(chan error, 1)
+	go func() {
+		_, err := usage.GetAndLockUsage(ctx, second, couponID, "u1")
+		secondErr <- err
+	}()
+	select {
+	case err := <-secondErr:
+		t.Fatalf("second did not wait for the first: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := usage.IncrementUsage(ctx, first, couponID, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var pqErr *pq.Error
+	if err := <-secondErr; !errors.As(err, &pqErr) || pqErr.Code != "40001" {
+		t.Fatalf("second: %v, want a serialization failure", err)
+	}
+	second.Rollback()
+
+	// retried in a new transaction it sees the row
+	retry, err := txs.BeginSerializable(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer retry.Rollback()
+	if n, err := usage.GetAndLockUsage(ctx, retry, couponID, "u1"); err != nil || n != 1 {
+		t.Fatalf("retry: %d, %v; want 1", n, err)
+	}
+}
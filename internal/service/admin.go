@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+var (
+	ErrCouponNotFound      = errors.New("coupon_not_found")
+	ErrNotAssignedAudience = errors.New("coupon_not_assigned_audience")
+)
+
+// CreateCoupon validates and stores a new coupon with its initial assignments
+// and returns its id. A taken code fails with models.ErrDuplicateCouponCode.
+func (s *CouponService) CreateCoupon(ctx context.Context, m *models.CouponMeta, assignedUsers []string) (int, error) {
+	if errs := s.CheckNew(m, assignedUsers, time.Now()); len(errs) > 0 {
+		return 0, &InvalidCouponError{Errors: errs}
+	}
+
+	tx, err := s.txs.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	id, err := s.couponRepo.Create(ctx, tx, m, assignedUsers)
+	if err != nil {
+		return 0, fmt.Errorf("create coupon: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("tx commit: %w", err)
+	}
+
+	s.refresh(ctx, m.CouponCode)
+	return id, nil
+}
+
+// UpdateCoupon validates m and replaces the definition of the coupon with the
+// same code. Usage and assignments are kept. The expiry may stay in the past,
+// so expired coupons can still be corrected.
+func (s *CouponService) UpdateCoupon(ctx context.Context, m *models.CouponMeta) error {
+	if errs := CheckDefinition(m, nil, time.Time{}); len(errs) > 0 {
+		return &InvalidCouponError{Errors: errs}
+	}
+
+	tx, err := s.txs.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	id, err := s.couponRepo.Update(ctx, tx, m)
+	if err != nil {
+		return fmt.Errorf("update coupon: %w", err)
+	}
+	if id == 0 {
+		return ErrCouponNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("tx commit: %w", err)
+	}
+
+	s.refresh(ctx, m.CouponCode)
+	return nil
+}
+
+// DeleteCoupon removes a coupon together with its usage and assignments
+func (s *CouponService) DeleteCoupon(ctx context.Context, code string) error {
+	tx, err := s.txs.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	found, err := s.couponRepo.Delete(ctx, tx, code)
+	if err != nil {
+		return fmt.Errorf("delete coupon: %w", err)
+	}
+	if !found {
+		return ErrCouponNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("tx commit: %w", err)
+	}
+
+	s.refresh(ctx, code)
+	return nil
+}
+
+// GetCoupon returns the stored definition, bypassing the validation cache
+func (s *CouponService) GetCoupon(ctx context.Context, code string) (*models.CouponMeta, error) {
+	m, err := s.couponRepo.GetCouponMeta(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, ErrCouponNotFound
+	}
+	return m, nil
+}
+
+// ListCoupons pages through all coupons ordered by id
+func (s *CouponService) ListCoupons(ctx context.Context, limit, offset int) ([]*models.CouponMeta, error) {
+	return s.couponRepo.List(ctx, limit, offset)
+}
+
+// AssignUsers issues an assigned-audience coupon to more users and returns how
+// many were newly assigned
+func (s *CouponService) AssignUsers(ctx context.Context, code string, userIDs []string) (int, error) {
+	m, err := s.GetCoupon(ctx, code)
+	if err != nil {
+		return 0, err
+	}
+	if m.Audience != "assigned" {
+		return 0, ErrNotAssignedAudience
+	}
+	return s.assignRepo.AssignUsers(ctx, m.ID, userIDs)
+}
+
+// refresh propagates a committed write to the cache and index; the write
+// already succeeded, so a failure here is only logged
+func (s *CouponService) refresh(ctx context.Context, code string) {
+	if err := s.CouponChanged(ctx, code); err != nil {
+		log.Printf("coupon refresh %s: %v", code, err)
+	}
+}
+
+// CheckNew returns every problem CreateCoupon would reject m for: the checks of
+// CheckDefinition plus conflicts with the stored coupons' code formats
+func (s *CouponService) CheckNew(m *models.CouponMeta, assignedUsers []string, now time.Time) []FieldError {
+	errs := CheckDefinition(m, assignedUsers, now)
+	if !m.IsTemplate && s.formats.match(m.CouponCode) != nil {
+		// it would be taken for a template code and fail verification
+		errs = append(errs, FieldError{Field: "coupon_code", Code: CodeConflict, Message: "coupon_code starts with the prefix of a template's generated codes"})
+	}
+	return errs
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"slices"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// BogoLine is a cart line with the sides of a bogo coupon it matches.
+type BogoLine struct {
+	Item models.CartItem
+	Buy  bool
+	Get  bool
+}
+
+// BogoFreeItems works out which units a buy-X-get-Y coupon makes free.
+//
+// A line may count for both sides. Each set reserves BuyQty of the most expensive
+// remaining buy units and then GetQty of the cheapest remaining get units, so the
+// customer always gets the cheapest items free. Incomplete sets earn nothing.
+// The returned discount is DiscountValue percent (100 = free) of the free units.
+//
+// Runs of identical sets, each taken from one buy line and one get line, are
+// counted in one step, so the work grows with the number of lines, not units.
+func BogoFreeItems(meta *models.CouponMeta, lines []BogoLine) ([]models.FreeItem, float64) {
+	if meta.BuyQty <= 0 || meta.GetQty <= 0 {
+		return nil, 0
+	}
+
+	var buyOrder, getOrder []int
+	for i, l := range lines {
+		if l.Buy {
+			buyOrder = append(buyOrder, i)
+		}
+		if l.Get {
+			getOrder = append(getOrder, i)
+		}
+	}
+	slices.SortStableFunc(buyOrder, func(a, b int) int { return cmpPrice(lines[b].Item, lines[a].Item) })
+	slices.SortStableFunc(getOrder, func(a, b int) int { return cmpPrice(lines[a].Item, lines[b].Item) })
+
+	remaining := make([]int, len(lines))
+	for i, l := range lines {
+		remaining[i] = max(l.Item.Qty, 0)
+	}
+
+	free := make([]int, len(lines))
+	for {
+		if m, b, g := steadySets(meta, buyOrder, getOrder, remaining); m > 0 {
+			remaining[b] -= m * meta.BuyQty
+			remaining[g] -= m * meta.GetQty
+			free[g] += m * meta.GetQty
+			continue
+		}
+		// the next set spans several lines on a side, which it empties
+		rem := slices.Clone(remaining)
+		got := make([]int, len(lines))
+		if !takeUnits(buyOrder, rem, meta.BuyQty, nil) || !takeUnits(getOrder, rem, meta.GetQty, got) {
+			break
+		}
+		remaining = rem
+		for i, n := range got {
+			free[i] += n
+		}
+	}
+
+	var items []models.FreeItem
+	discount := 0.0
+	for i, n := range free {
+		if n == 0 {
+			continue
+		}
+		it := lines[i].Item
+		items = append(items, models.FreeItem{ItemID: it.ID, Qty: n, UnitPrice: it.Price})
+		discount += float64(n) * it.Price * (meta.DiscountValue / 100.0)
+	}
+	return items, discount
+}
+
+// steadySets returns how many of the next sets take all their buy units from
+// line b and all their get units from line g (which may be b), or 0 if the
+// next set does not.
+func steadySets(meta *models.CouponMeta, buyOrder, getOrder, remaining []int) (m, b, g int) {
+	b = firstRemaining(buyOrder, remaining, -1, 0)
+	if b < 0 || remaining[b] < meta.BuyQty {
+		return 0, 0, 0
+	}
+	g = firstRemaining(getOrder, remaining, b, meta.BuyQty)
+	if g < 0 {
+		return 0, 0, 0
+	}
+	if g == b {
+		return remaining[b] / (meta.BuyQty + meta.GetQty), b, g
+	}
+	if remaining[g] < meta.GetQty {
+		return 0, 0, 0
+	}
+	return min(remaining[b]/meta.BuyQty, remaining[g]/meta.GetQty), b, g
+}
+
+// firstRemaining returns the first line in order with units left once taken
+// units are removed from line skip, or -1.
+func firstRemaining(order, remaining []int, skip, taken int) int {
+	for _, i := range order {
+		n := remaining[i]
+		if i == skip {
+			n -= taken
+		}
+		if n > 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// takeUnits consumes n units from the lines in order, recording them in into when set.
+func takeUnits(order []int, remaining []int, n int, into []int) bool {
+	for _, i := range order {
+		if n == 0 {
+			break
+		}
+		k := min(n, remaining[i])
+		remaining[i] -= k
+		n -= k
+		if into != nil {
+			into[i] += k
+		}
+	}
+	return n == 0
+}
+
+func cmpPrice(a, b models.CartItem) int {
+	switch {
+	case a.Price < b.Price:
+		return -1
+	case a.Price > b.Price:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+func bogoMeta(buy, get int) *models.CouponMeta {
+	return &models.CouponMeta{Coupon: models.Coupon{DiscountType: "bogo", DiscountValue: 100, BuyQty: buy, GetQty: get}}
+}
+
+func line(id string, price float64, qty int, buy, get bool) BogoLine {
+	return BogoLine{Item: models.CartItem{ID: id, Price: price, Qty: qty}, Buy: buy, Get: get}
+}
+
+func TestBogoFreeItems(t *testing.T) {
+	tests := []struct {
+		name     string
+		meta     *models.CouponMeta
+		lines    []BogoLine
+		want     []models.FreeItem
+		discount float64
+	}{
+		{
+			name:     "buy 2 get 1 on one line",
+			meta:     bogoMeta(2, 1),
+			lines:    []BogoLine{line("a", 10, 3, true, true)},
+			want:     []models.FreeItem{{ItemID: "a", Qty: 1, UnitPrice: 10}},
+			discount: 10,
+		},
+		{
+			name:     "leftover units earn nothing",
+			meta:     bogoMeta(2, 1),
+			lines:    []BogoLine{line("a", 10, 8, true, true)},
+			want:     []models.FreeItem{{ItemID: "a", Qty: 2, UnitPrice: 10}},
+			discount: 20,
+		},
+		{
+			name:     "incomplete set",
+			meta:     bogoMeta(2, 1),
+			lines:    []BogoLine{line("a", 10, 2, true, true)},
+			want:     nil,
+			discount: 0,
+		},
+		{
+			name: "cheapest units are free across mixed prices",
+			meta: bogoMeta(2, 1),
+			lines: []BogoLine{
+				line("mid", 20, 2, true, true),
+				line("high", 30, 2, true, true),
+				line("low", 5, 2, true, true),
+			},
+			// sets: {high,high}+low, {mid,mid}+low
+			want:     []models.FreeItem{{ItemID: "low", Qty: 2, UnitPrice: 5}},
+			discount: 10,
+		},
+		{
+			name: "separate buy and get sides",
+			meta: bogoMeta(1, 1),
+			lines: []BogoLine{
+				line("buy", 50, 3, true, false),
+				line("get-cheap", 4, 1, false, true),
+				line("get-dear", 8, 5, false, true),
+			},
+			want: []models.FreeItem{
+				{ItemID: "get-cheap", Qty: 1, UnitPrice: 4},
+				{ItemID: "get-dear", Qty: 2, UnitPrice: 8},
+			},
+			discount: 20,
+		},
+		{
+			name:     "half price",
+			meta:     &models.CouponMeta{Coupon: models.Coupon{BuyQty: 1, GetQty: 1, DiscountValue: 50}},
+			lines:    []BogoLine{line("a", 10, 5, true, true)},
+			want:     []models.FreeItem{{ItemID: "a", Qty: 2, UnitPrice: 10}},
+			discount: 10,
+		},
+		{
+			name:     "no get units",
+			meta:     bogoMeta(1, 1),
+			lines:    []BogoLine{line("a", 10, 5, true, false)},
+			want:     nil,
+			discount: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, discount := BogoFreeItems(tt.meta, tt.lines)
+			if !reflect.DeepEqual(got, tt.want) || discount != tt.discount {
+				t.Errorf("got %+v, %v; want %+v, %v", got, discount, tt.want, tt.discount)
+			}
+		})
+	}
+}
+
+// naiveBogo takes one set at a time, as BogoFreeItems is specified
+func naiveBogo(meta *models.CouponMeta, lines []BogoLine) []int {
+	var buyOrder, getOrder []int
+	for i, l := range lines {
+		if l.Buy {
+			buyOrder = append(buyOrder, i)
+		}
+		if l.Get {
+			getOrder = append(getOrder, i)
+		}
+	}
+	slices.SortStableFunc(buyOrder, func(a, b int) int { return cmpPrice(lines[b].Item, lines[a].Item) })
+	slices.SortStableFunc(getOrder, func(a, b int) int { return cmpPrice(lines[a].Item, lines[b].Item) })
+	remaining := make([]int, len(lines))
+	for i, l := range lines {
+		remaining[i] = l.Item.Qty
+	}
+	free := make([]int, len(lines))
+	for {
+		rem := slices.Clone(remaining)
+		got := make([]int, len(lines))
+		if !takeUnits(buyOrder, rem, meta.BuyQty, nil) || !takeUnits(getOrder, rem, meta.GetQty, got) {
+			return free
+		}
+		remaining = rem
+		for i, n := range got {
+			free[i] += n
+		}
+	}
+}
+
+func TestBogoFreeItemsMatchesSetBySet(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 2000; n++ {
+		meta := bogoMeta(1+rng.Intn(4), 1+rng.Intn(3))
+		lines := make([]BogoLine, 1+rng.Intn(5))
+		for i := range lines {
+			side := rng.Intn(3)
+			lines[i] = line(string(rune('a'+i)), float64(1+rng.Intn(4)), rng.Intn(15), side != 1, side != 0)
+		}
+
+		want := naiveBogo(meta, lines)
+		items, _ := BogoFreeItems(meta, lines)
+		got := make([]int, len(lines))
+		for _, it := range items {
+			got[it.ItemID[0]-'a'] = it.Qty
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("buy %d get %d, lines %+v: free %v, want %v", meta.BuyQty, meta.GetQty, lines, got, want)
+		}
+	}
+}
+
+func TestBogoFreeItemsLargeQuantity(t *testing.T) {
+	start := time.Now()
+	items, _ := BogoFreeItems(bogoMeta(2, 1), []BogoLine{
+		line("a", 10, 1_000_000_000, true, true),
+		line("b", 3, 999_999_999, true, true),
+	})
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("took %v", elapsed)
+	}
+	total := 0
+	for _, it := range items {
+		total += it.Qty
+	}
+	if want := 1_999_999_999 / 3; total != want {
+		t.Errorf("free units %d, want %d", total, want)
+	}
+}
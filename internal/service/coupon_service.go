@@ -2,40 +2,177 @@ package service
 
 import (
 	"context"
-	"database/sql"
 	"fmt"
 	"time"
 
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/cache"
+	concurrency "github.com/Cheertaboi/Billing-system-coupon-microservice/internal/concurrrency"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/couponcode"
 	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/uow"
 )
 
 // Repos required by service (use interfaces to allow mocking)
 type CouponRepo interface {
 	GetCouponMeta(ctx context.Context, code string) (*models.CouponMeta, error)
+	ListApplicableCandidates(ctx context.Context, userID string, now time.Time, orderTotal float64) ([]*models.CouponMeta, map[int]int, error)
+	ListActive(ctx context.Context, now time.Time) ([]*models.CouponMeta, error)
+	List(ctx context.Context, limit, offset int) ([]*models.CouponMeta, error)
+	// EachCoupon calls fn for every coupon ordered by id with its assigned users, from one snapshot
+	EachCoupon(ctx context.Context, fn func(m *models.CouponMeta, assignedUsers []string) error) error
+	// HasCodePrefix reports whether a non-template coupon code starts with the normalized prefix
+	HasCodePrefix(ctx context.Context, prefix string) (bool, error)
+	// TakenCodes returns which of the normalized codes a coupon or a generated code uses
+	TakenCodes(ctx context.Context, norms []string) (map[string]bool, error)
+
+	// writes run inside tx; Update returns 0 and Delete false if the code doesn't exist
+	Create(ctx context.Context, tx uow.Tx, m *models.CouponMeta, assignedUsers []string) (int, error)
+	Update(ctx context.Context, tx uow.Tx, m *models.CouponMeta) (int, error)
+	Delete(ctx context.Context, tx uow.Tx, code string) (bool, error)
+}
+
+type AssignmentRepo interface {
+	IsAssigned(ctx context.Context, couponID int, userID string) (bool, error)
+	AssignedAmong(ctx context.Context, userID string, couponIDs []int) (map[int]bool, error)
+	AssignUsers(ctx context.Context, couponID int, userIDs []string) (int, error)
+}
+
+// ChildCodeRepo stores the generated codes of coupon templates
+type ChildCodeRepo interface {
+	GetChildCode(ctx context.Context, code string) (*models.ChildCode, error)
+	// InsertChildCodes returns how many codes were new; taken codes are skipped
+	InsertChildCodes(ctx context.Context, tx uow.Tx, templateID int, batch string, codes []string) (int, error)
+	// RedeemChildCode reports false if the code was already redeemed
+	RedeemChildCode(ctx context.Context, tx uow.Tx, id int64, userID string) (bool, error)
+	EachChildCode(ctx context.Context, templateID int, batch string, fn func(*models.ChildCode) error) error
+
+	// GetCodeFormat returns nil if the template has no format yet
+	GetCodeFormat(ctx context.Context, templateID int) (*couponcode.Format, error)
+	ListCodeFormats(ctx context.Context) (map[string]couponcode.Format, error) // by template code
+	// CreateCodeFormat stores f unless the template has a format already, and
+	// returns the format stored and whether it is f
+	CreateCodeFormat(ctx context.Context, tx uow.Tx, templateID int, f couponcode.Format) (couponcode.Format, bool, error)
 }
 
 type UsageRepo interface {
-	GetAndLockUsage(ctx context.Context, tx *sql.Tx, couponID int, userID string) (int, error)
-	IncrementUsage(ctx context.Context, tx *sql.Tx, couponID int, userID string) error
+	// GetAndLockUsage returns the usage count, creating the row if needed, and
+	// locks it until tx ends; IncrementUsage must be called in the same tx
+	GetAndLockUsage(ctx context.Context, tx uow.Tx, couponID int, userID string) (int, error)
+	IncrementUsage(ctx context.Context, tx uow.Tx, couponID int, userID string) error
+	GetUsageCount(ctx context.Context, couponID int, userID string) (int, error)
+	GetUsageCounts(ctx context.Context, userID string, couponIDs []int) (map[int]int, error)
+	CountUserRedemptions(ctx context.Context, userID string) (int, error)
 }
 
 type CouponService struct {
-	db         *sql.DB // used for transactions
+	txs        uow.Beginner // used for transactions
 	couponRepo CouponRepo
 	usageRepo  UsageRepo
-	// small in-memory cache (optional): map[coupon_code]*models.CouponMeta
-	cache map[string]*models.CouponMeta
+	assignRepo AssignmentRepo
+	codeRepo   ChildCodeRepo
+	rules      *concurrency.Pipeline
+	index      *CouponIndex       // used by ApplicableCoupons once loaded
+	formats    *codeFormats       // template code formats, checked before lookups
+	cache      *cache.CouponCache // coupon meta by normalized code for validate/quote
+	loads      loadGroup          // coalesces concurrent cache misses per normalized code
+	// well-formed child codes that don't exist; kept apart from cache, whose
+	// "not found" only covers coupon codes
+	childMisses *cache.CouponCache
 }
 
-func NewCouponService(db *sql.DB, cRepo CouponRepo, uRepo UsageRepo) *CouponService {
+// coupon meta cache bounds; edits are also invalidated explicitly via CouponChanged
+const (
+	metaCacheSize = 10000
+	metaCacheTTL  = 5 * time.Minute
+	// unknown codes (typos, enumeration) are remembered briefly so they don't
+	// all reach the database
+	notFoundTTL     = 30 * time.Second
+	childMissesSize = 10000
+	// a coalesced load serves many requests, so it gets its own deadline
+	metaLoadTimeout = 5 * time.Second
+)
+
+func NewCouponService(txs uow.Beginner, cRepo CouponRepo, uRepo UsageRepo, aRepo AssignmentRepo, ccRepo ChildCodeRepo) *CouponService {
 	return &CouponService{
-		db:         db,
-		couponRepo: cRepo,
-		usageRepo:  uRepo,
-		cache:      make(map[string]*models.CouponMeta),
+		txs:         txs,
+		couponRepo:  cRepo,
+		usageRepo:   uRepo,
+		assignRepo:  aRepo,
+		codeRepo:    ccRepo,
+		rules:       concurrency.NewPipeline(),
+		index:       NewCouponIndex(),
+		formats:     newCodeFormats(),
+		cache:       cache.NewCouponCache(metaCacheSize, metaCacheTTL),
+		childMisses: cache.NewCouponCache(childMissesSize, notFoundTTL),
 	}
 }
 
+// RegisterRule appends a custom eligibility rule to the pipeline used by both
+// validation and the applicable-coupons listing
+func (s *CouponService) RegisterRule(r concurrency.Rule) {
+	s.rules.Register(r)
+}
+
+// LoadIndex (re)builds the in-memory eligibility index from all active coupons,
+// and the template code formats. Until it succeeds ApplicableCoupons falls back
+// to the batched SQL path and codes are not checked against formats.
+func (s *CouponService) LoadIndex(ctx context.Context) error {
+	metas, err := s.couponRepo.ListActive(ctx, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("load index: %w", err)
+	}
+	formats, err := s.codeRepo.ListCodeFormats(ctx)
+	if err != nil {
+		return fmt.Errorf("load code formats: %w", err)
+	}
+	s.index.Replace(metas)
+	s.formats.replace(formats)
+	return nil
+}
+
+// CouponChanged must be called after a coupon was created, modified or deleted.
+// It evicts the cached meta and reloads the coupon into the eligibility index,
+// or for a template its code format; a coupon that no longer exists is dropped.
+func (s *CouponService) CouponChanged(ctx context.Context, code string) error {
+	code = models.NormalizeCouponCode(code)
+	s.cache.Invalidate(code)
+	s.loads.forget(code)
+
+	m, err := s.couponRepo.GetCouponMeta(ctx, code)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		s.index.Remove(code)
+		s.formats.remove(code)
+		return nil
+	}
+	if m.IsTemplate {
+		s.index.Remove(code)
+		f, err := s.codeRepo.GetCodeFormat(ctx, m.ID)
+		if err != nil {
+			return err
+		}
+		return s.formats.set(code, f)
+	}
+	s.index.Upsert(m)
+	return nil
+}
+
+// Flush drops all cached coupon meta and rebuilds the eligibility index; used when
+// change notifications may have been missed
+func (s *CouponService) Flush(ctx context.Context) error {
+	s.cache.Purge()
+	s.childMisses.Purge()
+	s.loads.forgetAll()
+	return s.LoadIndex(ctx)
+}
+
+// CacheStats reports hit/miss counters of the coupon meta cache
+func (s *CouponService) CacheStats() cache.Stats {
+	return s.cache.Stats()
+}
+
 // ValidateRequest and Response types -- reuse models.ValidationRequest/Response
 type ValidateRequest = models.ValidationRequest
 type ValidateResponse = models.ValidationResponse
@@ -46,211 +183,235 @@ func (s *CouponService) ValidateCoupon(ctx context.Context, req ValidateRequest)
 	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
 	defer cancel()
 
-	// 1) Load coupon meta (try cache first)
-	var couponMeta *models.CouponMeta
-	if cm, ok := s.cache[req.CouponCode]; ok {
-		couponMeta = cm
-	} else {
-		m, err := s.couponRepo.GetCouponMeta(ctx, req.CouponCode)
-		if err != nil {
-			return ValidateResponse{IsValid: false, Message: "internal_error"}, err
-		}
-		if m == nil {
-			return ValidateResponse{IsValid: false, Message: "coupon_not_found"}, nil
-		}
-		// store in cache (simple; you can add TTL/invalidation later)
-		s.cache[req.CouponCode] = m
-		couponMeta = m
+	// 1) Load coupon meta (try cache first), or the template of a child code
+	couponMeta, child, reason, err := s.resolve(ctx, req.CouponCode)
+	if err != nil {
+		return ValidateResponse{IsValid: false, Message: "internal_error"}, err
+	}
+	if reason != "" {
+		return ValidateResponse{IsValid: false, Message: reason}, nil
 	}
 
-	now := time.Now().UTC()
-	// 2) Basic validations
-	if couponMeta.ExpiryDate.Before(now) {
-		return ValidateResponse{IsValid: false, Message: "coupon_expired"}, nil
+	// 2) Eligibility + pricing (shared with quote and applicable)
+	cart, user := s.newEvaluation(req, time.Now().UTC())
+	resp, err := s.evaluate(ctx, couponMeta, cart, user)
+	if err != nil || !resp.IsValid {
+		return resp, err
 	}
-	if couponMeta.MinOrderValue > req.OrderTotal {
-		return ValidateResponse{IsValid: false, Message: "min_order_value_not_met"}, nil
+
+	// 3) Concurrency-safe usage increment, retried on serialization failures
+	reason, err = s.redeem(ctx, couponMeta, child, req.UserID)
+	if err != nil {
+		return ValidateResponse{IsValid: false, Message: "internal_error"}, err
 	}
-	if couponMeta.ValidFrom != nil && couponMeta.ValidTo != nil {
-		if now.Before(*couponMeta.ValidFrom) || now.After(*couponMeta.ValidTo) {
-			return ValidateResponse{IsValid: false, Message: "not_in_valid_window"}, nil
-		}
+	if reason != "" {
+		return ValidateResponse{IsValid: false, Message: reason}, nil
 	}
 
-	// 3) Parallel item applicability checks using worker pool
-	// Build a helper "isApplicable" that checks if an item matches coupon rules
-	applicableMap := make(map[string]bool)
-	for _, id := range couponMeta.ApplicableItems {
-		applicableMap[id] = true
-	}
-	categoryMap := make(map[string]bool)
-	for _, c := range couponMeta.ApplicableCategories {
-		categoryMap[c] = true
+	// Final response
+	return resp, nil
+}
+
+// redeemOnce consumes one usage in a serializable transaction + SELECT FOR UPDATE,
+// and for a template's child code also the code itself. It returns the rejection
+// reason if the locked usage count is already at the limit or the code was used.
+func (s *CouponService) redeemOnce(ctx context.Context, couponMeta *models.CouponMeta, child *models.ChildCode, userID string) (string, error) {
+	tx, err := s.txs.BeginSerializable(ctx)
+	if err != nil {
+		return "", fmt.Errorf("begin tx: %w", err)
 	}
+	// ensure rollback on any exit
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
 
-	// worker input: CartItem, output: discount contribution (float64)
-	type itemIn struct {
-		it models.CartItem
+	// Get and lock usage row
+	usageCount, err := s.usageRepo.GetAndLockUsage(ctx, tx, couponMeta.ID, userID)
+	if err != nil {
+		return "", fmt.Errorf("get lock: %w", err)
 	}
-	type itemOut struct {
-		discount float64
+
+	// Re-check user-based usage constraints now that the row is locked
+	if res := concurrency.CheckUsage(couponMeta, usageCount); !res.Pass {
+		return res.Reason, nil
 	}
 
-	// determine workerCount relative to cart size (but at least 2)
-	workerCount := 4
-	if len(req.CartItems) > 0 && len(req.CartItems) < workerCount {
-		workerCount = len(req.CartItems)
-		if workerCount == 0 {
-			workerCount = 1
+	// a child code is single-use across all users
+	if child != nil {
+		ok, err := s.codeRepo.RedeemChildCode(ctx, tx, child.ID, userID)
+		if err != nil {
+			return "", fmt.Errorf("redeem code: %w", err)
+		}
+		if !ok {
+			return "coupon_already_used", nil
 		}
 	}
 
-	inCh := make(chan itemIn)
-	outCh := make(chan itemOut)
-
-	// spawn workers
-	for i := 0; i < workerCount; i++ {
-		go func() {
-			for in := range inCh {
-				it := in.it
-				// check applicability
-				applies := false
-				if len(applicableMap) == 0 && len(categoryMap) == 0 {
-					// no restrictions -> applies to all items
-					applies = true
-				}
-				if applicableMap[it.ID] {
-					applies = true
-				}
-				if categoryMap[it.Category] {
-					applies = true
-				}
-				// compute discount contribution for items (inventory target)
-				discount := 0.0
-				if applies && couponMeta.TargetType == "inventory" {
-					if couponMeta.DiscountType == "percentage" {
-						discount = float64(it.Qty) * it.Price * (couponMeta.DiscountValue / 100.0)
-					} else { // flat
-						// flat discount: treat as per-order flat; to avoid double counting, let worker send zero
-						discount = 0.0
-					}
-				}
-				select {
-				case outCh <- itemOut{discount: discount}:
-				case <-ctx.Done():
-					return
-				}
-			}
-		}()
+	// At this point, we can increment usage (consume)
+	if err := s.usageRepo.IncrementUsage(ctx, tx, couponMeta.ID, userID); err != nil {
+		return "", fmt.Errorf("increment usage: %w", err)
 	}
 
-	// send items
-	go func() {
-		for _, it := range req.CartItems {
-			select {
-			case inCh <- itemIn{it: it}:
-			case <-ctx.Done():
-				break
-			}
-		}
-		close(inCh)
-	}()
+	// commit
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("tx commit: %w", err)
+	}
+	committed = true
+	return "", nil
+}
 
-	// collect results
-	totalItemsDiscount := 0.0
-	collectDone := make(chan struct{})
-	go func() {
-		for range req.CartItems {
-			select {
-			case o := <-outCh:
-				totalItemsDiscount += o.discount
-			case <-ctx.Done():
-				// exit early
-				break
-			}
-		}
-		// ensure any remaining outputs drained
-		close(collectDone)
-	}()
+// QuoteCoupon evaluates and prices a coupon exactly like ValidateCoupon but does
+// not consume a usage.
+func (s *CouponService) QuoteCoupon(ctx context.Context, req ValidateRequest, now time.Time) (ValidateResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 8*time.Second)
+	defer cancel()
 
-	// Wait until collectors finished or context done
-	select {
-	case <-collectDone:
-	case <-ctx.Done():
-		return ValidateResponse{IsValid: false, Message: "timeout_during_item_checks"}, ctx.Err()
+	couponMeta, _, reason, err := s.resolve(ctx, req.CouponCode)
+	if err != nil {
+		return ValidateResponse{IsValid: false, Message: "internal_error"}, err
+	}
+	if reason != "" {
+		return ValidateResponse{IsValid: false, Message: reason}, nil
 	}
 
-	// compute charges discount if target_type == "charges"
-	chargesDiscount := 0.0
-	if couponMeta.TargetType == "charges" {
-		if couponMeta.DiscountType == "percentage" {
-			chargesDiscount = req.OrderTotal * (couponMeta.DiscountValue / 100.0)
-		} else {
-			// flat on charges
-			chargesDiscount = couponMeta.DiscountValue
-		}
+	cart, user := s.newEvaluation(req, now)
+	return s.evaluate(ctx, couponMeta, cart, user)
+}
+
+// ApplicableCoupon is a coupon the cart qualifies for, priced
+type ApplicableCoupon struct {
+	CouponCode string            `json:"coupon_code"`
+	Discount   float64           `json:"discount"`
+	FreeItems  []models.FreeItem `json:"free_items,omitempty"`
+	NextTier   *models.NextTier  `json:"next_tier,omitempty"`
+}
+
+// RejectedCoupon is a candidate coupon the cart does not qualify for
+type RejectedCoupon struct {
+	CouponCode string `json:"coupon_code"`
+	Reason     string `json:"reason"`
+}
+
+// ApplicableCoupons evaluates every coupon visible to the user against the cart
+// with the same evaluator as ValidateCoupon. req.CouponCode is ignored.
+//
+// Expired, out-of-window, below-min-order and other users' assigned coupons are
+// dropped up front; only the remaining candidates are evaluated (and can show up
+// as rejected). Candidates come from the eligibility index when it is loaded,
+// otherwise from one batched repository call.
+func (s *CouponService) ApplicableCoupons(ctx context.Context, req ValidateRequest, now time.Time) ([]ApplicableCoupon, []RejectedCoupon, error) {
+	metas, usage, assigned, err := s.candidates(ctx, req, now)
+	if err != nil {
+		return nil, nil, fmt.Errorf("list coupons: %w", err)
 	}
 
-	// handle flat per-order inventory discounts:
-	flatInventoryDiscount := 0.0
-	if couponMeta.TargetType == "inventory" && couponMeta.DiscountType == "flat" {
-		flatInventoryDiscount = couponMeta.DiscountValue
+	// one cart/user for the whole listing so derived data and lookups are shared;
+	// usage and assignments were prefetched with the candidates
+	cart, user := s.newEvaluation(req, now)
+	facts := user.Facts.(*userFacts)
+	facts.usage, facts.assigned = usage, assigned
+
+	applicable := []ApplicableCoupon{}
+	var rejected []RejectedCoupon
+	for _, meta := range metas {
+		resp, err := s.evaluate(ctx, meta, cart, user)
+		if err != nil {
+			return nil, nil, fmt.Errorf("evaluate %s: %w", meta.CouponCode, err)
+		}
+		if !resp.IsValid {
+			rejected = append(rejected, RejectedCoupon{CouponCode: meta.CouponCode, Reason: resp.Message})
+			continue
+		}
+		applicable = append(applicable, ApplicableCoupon{
+			CouponCode: meta.CouponCode,
+			Discount:   resp.Discount,
+			FreeItems:  resp.FreeItems,
+			NextTier:   resp.NextTier,
+		})
 	}
+	return applicable, rejected, nil
+}
 
-	// choose final discount (simple strategy):
-	totalDiscount := totalItemsDiscount
-	if couponMeta.TargetType == "charges" {
-		totalDiscount = chargesDiscount
-	} else if couponMeta.TargetType == "inventory" {
-		// if flat discount, apply flat once; if percentage, totalItemsDiscount already set
-		if couponMeta.DiscountType == "flat" {
-			totalDiscount = flatInventoryDiscount
+// candidates returns the coupons worth evaluating for the listing, with the
+// user's usage counts and assignments for them
+func (s *CouponService) candidates(ctx context.Context, req ValidateRequest, now time.Time) ([]*models.CouponMeta, map[int]int, map[int]bool, error) {
+	if !s.index.Ready() {
+		metas, usage, err := s.couponRepo.ListApplicableCandidates(ctx, req.UserID, now, req.OrderTotal)
+		if err != nil {
+			return nil, nil, nil, err
 		}
+		// the query already kept only assigned coupons issued to this user
+		assigned := make(map[int]bool)
+		for _, m := range metas {
+			if m.Audience == "assigned" {
+				assigned[m.ID] = true
+			}
+		}
+		return metas, usage, assigned, nil
 	}
 
-	// 4) Concurrency-safe usage increment using DB transaction + SELECT FOR UPDATE
-	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	metas := s.index.Candidates(req.CartItems, now, req.OrderTotal)
+	var assignedIDs []int
+	for _, m := range metas {
+		if m.Audience == "assigned" {
+			assignedIDs = append(assignedIDs, m.ID)
+		}
+	}
+	assigned, err := s.assignRepo.AssignedAmong(ctx, req.UserID, assignedIDs)
 	if err != nil {
-		return ValidateResponse{IsValid: false, Message: "internal_error"}, fmt.Errorf("begin tx: %w", err)
+		return nil, nil, nil, err
 	}
-	// ensure rollback on any exit
-	committed := false
-	defer func() {
-		if !committed {
-			_ = tx.Rollback()
+	// drop assigned coupons not issued to this user, like the SQL path does
+	visible := metas[:0]
+	ids := make([]int, 0, len(metas))
+	for _, m := range metas {
+		if m.Audience != "assigned" || assigned[m.ID] {
+			visible = append(visible, m)
+			ids = append(ids, m.ID)
 		}
-	}()
-
-	// Get and lock usage row
-	usageCount, err := s.usageRepo.GetAndLockUsage(ctx, tx, couponMeta.ID, req.UserID)
+	}
+	usage, err := s.usageRepo.GetUsageCounts(ctx, req.UserID, ids)
 	if err != nil {
-		return ValidateResponse{IsValid: false, Message: "internal_error"}, fmt.Errorf("get lock: %w", err)
+		return nil, nil, nil, err
 	}
+	return visible, usage, assigned, nil
+}
 
-	// Check user-based usage constraints
-	if couponMeta.UsageType == "one_time" && usageCount >= 1 {
-		return ValidateResponse{IsValid: false, Message: "coupon_already_used"}, nil
-	}
-	if couponMeta.MaxUsagePerUser > 0 && usageCount >= couponMeta.MaxUsagePerUser {
-		return ValidateResponse{IsValid: false, Message: "usage_limit_reached"}, nil
+// loadMeta returns coupon meta from the cache or the repository; nil if the code doesn't exist.
+// Concurrent misses for the same code share one repository call, and "not found"
+// is cached for notFoundTTL. Codes are keyed by their normalized form.
+func (s *CouponService) loadMeta(ctx context.Context, code string) (*models.CouponMeta, error) {
+	code = models.NormalizeCouponCode(code)
+	if cm, ok := s.cache.Get(code); ok {
+		return cm, nil
 	}
+	return s.loads.do(ctx, code, func() (*models.CouponMeta, error) {
+		// taken before the query: an invalidation while it runs discards the result
+		gen := s.cache.Generation()
 
-	// At this point, we can increment usage (consume)
-	if err := s.usageRepo.IncrementUsage(ctx, tx, couponMeta.ID, req.UserID); err != nil {
-		return ValidateResponse{IsValid: false, Message: "internal_error"}, fmt.Errorf("increment usage: %w", err)
-	}
+		// not tied to the first caller, whose cancellation would fail everyone waiting
+		lctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), metaLoadTimeout)
+		defer cancel()
 
-	// commit
-	if err := tx.Commit(); err != nil {
-		return ValidateResponse{IsValid: false, Message: "internal_error"}, fmt.Errorf("tx commit: %w", err)
-	}
-	committed = true
+		m, err := s.couponRepo.GetCouponMeta(lctx, code)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			s.cache.SetNotFound(code, notFoundTTL, gen)
+			return nil, nil
+		}
+		s.cache.Set(code, m, gen)
+		return m, nil
+	})
+}
 
-	// Final response
-	resp := ValidateResponse{
-		IsValid:  true,
-		Discount: totalDiscount,
-		Message:  "coupon_applied",
-	}
-	return resp, nil
+// newEvaluation builds the cart and user the rule pipeline runs against
+func (s *CouponService) newEvaluation(req ValidateRequest, now time.Time) (*concurrency.Cart, *concurrency.User) {
+	cart := &concurrency.Cart{Items: req.CartItems, OrderTotal: req.OrderTotal, Now: now}
+	user := &concurrency.User{ID: req.UserID, Context: req.User, Facts: s.factsFor(req.UserID, req.User)}
+	return cart, user
 }
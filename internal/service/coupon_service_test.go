@@ -0,0 +1,277 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository/memory"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/service"
+)
+
+// fixture is a service on a fresh in-memory store
+type fixture struct {
+	store   *memory.Store
+	coupons *memory.CouponRepo
+	usage   *memory.UsageRepo
+	svc     *service.CouponService
+}
+
+func newFixture(t testing.TB) *fixture {
+	t.Helper()
+	store := memory.NewStore()
+	coupons, usage := memory.NewCouponRepo(store), memory.NewUsageRepo(store)
+	svc := service.NewCouponService(store, coupons, usage,
+		memory.NewAssignmentRepo(store), memory.NewChildCodeRepo(store))
+	if err := svc.LoadIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return &fixture{store: store, coupons: coupons, usage: usage, svc: svc}
+}
+
+// create stores m through the admin path, which validates it
+func (f *fixture) create(t testing.TB, m *models.CouponMeta, assignedUsers ...string) {
+	t.Helper()
+	if _, err := f.svc.CreateCoupon(context.Background(), m, assignedUsers); err != nil {
+		t.Fatalf("create %s: %v", m.CouponCode, err)
+	}
+}
+
+// percentCoupon is a valid multi-use percentage coupon on inventory
+func percentCoupon(code string, percent float64) *models.CouponMeta {
+	return &models.CouponMeta{Coupon: models.Coupon{
+		CouponCode:      code,
+		ExpiryDate:      time.Now().Add(30 * 24 * time.Hour),
+		UsageType:       "multi_use",
+		DiscountType:    "percentage",
+		DiscountValue:   percent,
+		MaxUsagePerUser: 1,
+		TargetType:      "inventory",
+	}}
+}
+
+func cartRequest(userID, code string, items ...models.CartItem) service.ValidateRequest {
+	total := 0.0
+	for _, it := range items {
+		total += it.Price * float64(it.Qty)
+	}
+	return service.ValidateRequest{UserID: userID, CouponCode: code, CartItems: items, OrderTotal: total}
+}
+
+func item(id, category string, price float64, qty int) models.CartItem {
+	return models.CartItem{ID: id, Category: category, Price: price, Qty: qty}
+}
+
+func TestValidateConsumesUsage(t *testing.T) {
+	f := newFixture(t)
+	m := percentCoupon("SAVE10", 10)
+	m.MaxUsagePerUser = 2
+	f.create(t, m)
+
+	ctx := context.Background()
+	req := cartRequest("u1", "save-10", item("med1", "painkillers", 50, 2))
+	for i := range 2 {
+		resp, err := f.svc.ValidateCoupon(ctx, req)
+		if err != nil || !resp.IsValid || resp.Discount != 10 {
+			t.Fatalf("use %d: %+v, %v", i+1, resp, err)
+		}
+	}
+	resp, err := f.svc.ValidateCoupon(ctx, req)
+	if err != nil || resp.IsValid || resp.Message != "usage_limit_reached" {
+		t.Fatalf("third use: %+v, %v", resp, err)
+	}
+
+	// other users have their own limit
+	resp, err = f.svc.ValidateCoupon(ctx, cartRequest("u2", "SAVE10", item("med1", "painkillers", 50, 2)))
+	if err != nil || !resp.IsValid {
+		t.Fatalf("other user: %+v, %v", resp, err)
+	}
+}
+
+func TestValidateRejections(t *testing.T) {
+	f := newFixture(t)
+	restricted := percentCoupon("VITAMINS", 10)
+	restricted.ApplicableCategories = []string{"vitamins"}
+	f.create(t, restricted)
+	minOrder := percentCoupon("BIGCART", 10)
+	minOrder.MinOrderValue = 500
+	f.create(t, minOrder)
+	expired := percentCoupon("OLD", 10)
+	expired.ExpiryDate = time.Now().Add(-time.Hour)
+	f.coupons.Put(expired) // CreateCoupon refuses a past expiry
+
+	cart := []models.CartItem{item("med1", "painkillers", 50, 1)}
+	tests := map[string]string{
+		"NOPE":     "coupon_not_found",
+		"VITAMINS": "no_applicable_items",
+		"BIGCART":  "min_order_value_not_met",
+		"OLD":      "coupon_expired",
+	}
+	for code, want := range tests {
+		resp, err := f.svc.ValidateCoupon(context.Background(), cartRequest("u1", code, cart...))
+		if err != nil || resp.IsValid || resp.Message != want {
+			t.Errorf("%s: %+v, %v; want %s", code, resp, err, want)
+		}
+	}
+}
+
+func TestQuoteDoesNotConsume(t *testing.T) {
+	f := newFixture(t)
+	f.create(t, percentCoupon("ONCE", 20))
+
+	ctx := context.Background()
+	req := cartRequest("u1", "ONCE", item("med1", "painkillers", 10, 1))
+	for range 3 {
+		resp, err := f.svc.QuoteCoupon(ctx, req, time.Now())
+		if err != nil || !resp.IsValid || resp.Discount != 2 {
+			t.Fatalf("quote: %+v, %v", resp, err)
+		}
+	}
+	if resp, err := f.svc.ValidateCoupon(ctx, req); err != nil || !resp.IsValid {
+		t.Fatalf("validate after quotes: %+v, %v", resp, err)
+	}
+}
+
+func TestTiersApplyInThresholdOrder(t *testing.T) {
+	f := newFixture(t)
+	m := percentCoupon("TIERS", 0)
+	m.DiscountType = "tiered"
+	m.TierBasis = "order_total"
+	m.Tiers = []models.DiscountTier{ // not in threshold order
+		{Threshold: 1200, DiscountType: "flat", DiscountValue: 150},
+		{Threshold: 500, DiscountType: "flat", DiscountValue: 50},
+	}
+	f.create(t, m)
+
+	resp, err := f.svc.QuoteCoupon(context.Background(), cartRequest("u1", "TIERS", item("med1", "painkillers", 700, 1)), time.Now())
+	if err != nil || !resp.IsValid || resp.Discount != 50 {
+		t.Fatalf("quote at 700: %+v, %v", resp, err)
+	}
+	if resp.NextTier == nil || resp.NextTier.Threshold != 1200 {
+		t.Errorf("next tier %+v, want 1200", resp.NextTier)
+	}
+}
+
+func TestPerUnitDiscounts(t *testing.T) {
+	f := newFixture(t)
+	tests := []struct {
+		code      string
+		typ       string
+		value     float64
+		maxUnits  int
+		items     []models.CartItem
+		wantTotal float64
+	}{
+		// a unit cheaper than the discount is discounted to zero, not below
+		{"UNIT5", "per_unit_flat", 5, 0, []models.CartItem{item("med1", "painkillers", 3, 2), item("med2", "painkillers", 10, 1)}, 6 + 5},
+		// a unit cheaper than the fixed price keeps its price
+		{"AT8", "fixed_price", 8, 0, []models.CartItem{item("med1", "painkillers", 5, 1), item("med2", "painkillers", 20, 2)}, 0 + 24},
+		// the cap takes the biggest unit discounts first, across lines
+		{"AT5MAX3", "fixed_price", 5, 3, []models.CartItem{item("med1", "painkillers", 10, 3), item("med2", "painkillers", 20, 2), item("med3", "painkillers", 8, 1)}, 15 + 15 + 5},
+		{"PCT10MAX2", "percentage", 10, 2, []models.CartItem{item("med1", "painkillers", 50, 3), item("med2", "painkillers", 100, 1)}, 10 + 5},
+		{"UNIT2MAX9", "per_unit_flat", 2, 9, []models.CartItem{item("med1", "painkillers", 10, 2), item("med2", "painkillers", 20, 1)}, 6},
+	}
+	for _, tt := range tests {
+		m := percentCoupon(tt.code, 0)
+		m.DiscountType = tt.typ
+		m.DiscountValue = tt.value
+		m.MaxUnitsDiscounted = tt.maxUnits
+		f.create(t, m)
+
+		resp, err := f.svc.QuoteCoupon(context.Background(), cartRequest("u1", tt.code, tt.items...), time.Now())
+		if err != nil || !resp.IsValid || resp.Discount != tt.wantTotal {
+			t.Errorf("%s: %+v, %v; want discount %v", tt.code, resp, err, tt.wantTotal)
+		}
+	}
+}
+
+func TestApplicableCoupons(t *testing.T) {
+	f := newFixture(t)
+	f.create(t, percentCoupon("ALL10", 10))
+	painkillers := percentCoupon("PAIN20", 20)
+	painkillers.ApplicableCategories = []string{"painkillers"}
+	f.create(t, painkillers)
+	vitamins := percentCoupon("VIT30", 30)
+	vitamins.ApplicableCategories = []string{"vitamins"}
+	f.create(t, vitamins)
+	minOrder := percentCoupon("BIGCART", 5)
+	minOrder.MinOrderValue = 1000
+	f.create(t, minOrder)
+	assigned := percentCoupon("VIP", 50)
+	assigned.Audience = "assigned"
+	f.create(t, assigned, "someone-else")
+
+	req := cartRequest("u1", "", item("med1", "painkillers", 100, 1))
+	applicable, rejected, err := f.svc.ApplicableCoupons(context.Background(), req, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	discounts := map[string]float64{}
+	for _, a := range applicable {
+		discounts[a.CouponCode] = a.Discount
+	}
+	if len(discounts) != 2 || discounts["ALL10"] != 10 || discounts["PAIN20"] != 20 {
+		t.Errorf("applicable %+v, want ALL10 and PAIN20", applicable)
+	}
+	for _, r := range rejected {
+		if r.CouponCode == "BIGCART" || r.CouponCode == "VIP" {
+			t.Errorf("%s should be dropped up front, got %+v", r.CouponCode, r)
+		}
+	}
+}
+
+func TestAdminCRUD(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+	f.create(t, percentCoupon("CRUD", 10))
+
+	if _, err := f.svc.CreateCoupon(ctx, percentCoupon("crud", 10), nil); !errors.Is(err, models.ErrDuplicateCouponCode) {
+		t.Errorf("duplicate create: %v", err)
+	}
+	invalid := percentCoupon("BAD", 150)
+	invalid.TargetType = "nowhere"
+	var invalidErr *service.InvalidCouponError
+	if _, err := f.svc.CreateCoupon(ctx, invalid, nil); !errors.As(err, &invalidErr) || len(invalidErr.Errors) != 2 {
+		t.Errorf("invalid create: %v", err)
+	}
+
+	got, err := f.svc.GetCoupon(ctx, "crud")
+	if err != nil || got.CouponCode != "CRUD" || got.DiscountValue != 10 {
+		t.Fatalf("get: %+v, %v", got, err)
+	}
+
+	// the cached definition is replaced on update
+	req := cartRequest("u1", "CRUD", item("med1", "painkillers", 100, 1))
+	if resp, _ := f.svc.QuoteCoupon(ctx, req, time.Now()); resp.Discount != 10 {
+		t.Fatalf("quote before update: %+v", resp)
+	}
+	if err := f.svc.UpdateCoupon(ctx, percentCoupon("CRUD", 25)); err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := f.svc.QuoteCoupon(ctx, req, time.Now()); resp.Discount != 25 {
+		t.Errorf("quote after update: %+v", resp)
+	}
+	if err := f.svc.UpdateCoupon(ctx, percentCoupon("MISSING", 25)); !errors.Is(err, service.ErrCouponNotFound) {
+		t.Errorf("update missing: %v", err)
+	}
+
+	list, err := f.svc.ListCoupons(ctx, 10, 0)
+	if err != nil || len(list) != 1 {
+		t.Errorf("list: %d coupons, %v", len(list), err)
+	}
+
+	if err := f.svc.DeleteCoupon(ctx, "CRUD"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.svc.GetCoupon(ctx, "CRUD"); !errors.Is(err, service.ErrCouponNotFound) {
+		t.Errorf("get after delete: %v", err)
+	}
+	if resp, _ := f.svc.QuoteCoupon(ctx, req, time.Now()); resp.Message != "coupon_not_found" {
+		t.Errorf("quote after delete: %+v", resp)
+	}
+	if err := f.svc.DeleteCoupon(ctx, "CRUD"); !errors.Is(err, service.ErrCouponNotFound) {
+		t.Errorf("delete twice: %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// Field error codes
+const (
+	CodeRequired   = "required"     // missing value
+	CodeInvalid    = "invalid"      // not one of the allowed values, or unparseable
+	CodeOutOfRange = "out_of_range" // number outside its bounds
+	CodeExpired    = "expired"      // date already in the past
+	CodeConflict   = "conflict"     // contradicts another field
+	CodeNotAllowed = "not_allowed"  // field set where it has no meaning
+)
+
+// FieldError is one problem with one field of a coupon definition. Field uses
+// the JSON names of the admin API, e.g. "tiers[1].discount_value".
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// InvalidCouponError rejects a coupon definition and lists every problem found
+type InvalidCouponError struct {
+	Errors []FieldError
+}
+
+func (e *InvalidCouponError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return "invalid coupon: " + strings.Join(msgs, "; ")
+}
+
+type fieldErrors []FieldError
+
+func (fe *fieldErrors) add(field, code, msg string) {
+	*fe = append(*fe, FieldError{Field: field, Code: code, Message: msg})
+}
+
+var (
+	usageTypes    = map[string]bool{"one_time": true, "multi_use": true, "time_based": true}
+	discountTypes = map[string]bool{"flat": true, "percentage": true, "bogo": true, "tiered": true, "per_unit_flat": true, "fixed_price": true}
+	targetTypes   = map[string]bool{"inventory": true, "charges": true}
+)
+
+// maxCouponCodeLen is the width of coupons.coupon_code
+const maxCouponCodeLen = 100
+
+// CheckDefinition applies defaults to m, compiles its rule and returns every
+// problem with the definition. The expiry must lie after now; pass the zero
+// time to skip that check, e.g. when editing a coupon that already expired.
+func CheckDefinition(m *models.CouponMeta, assignedUsers []string, now time.Time) []FieldError {
+	m.CouponCode = strings.TrimSpace(m.CouponCode)
+	m.RuleExpr = strings.TrimSpace(m.RuleExpr)
+	if m.Audience == "" {
+		m.Audience = "public"
+	}
+
+	var errs fieldErrors
+	checkBasics(&errs, m)
+	checkDates(&errs, m, now)
+	checkDiscount(&errs, m)
+	checkEligibility(&errs, m)
+
+	switch {
+	case m.Audience != "public" && m.Audience != "assigned":
+		errs.add("audience", CodeInvalid, "audience must be public or assigned")
+	case m.Audience == "public" && len(assignedUsers) > 0:
+		errs.add("assigned_user_ids", CodeNotAllowed, "assigned_user_ids needs audience assigned")
+	}
+
+	if m.RuleExpr != "" {
+		rule, err := models.CompileRule(m.RuleExpr)
+		if err != nil {
+			errs.add("rule_expression", CodeInvalid, "invalid rule_expression: "+err.Error())
+		} else {
+			m.Rule = rule
+		}
+	}
+	return errs
+}
+
+func checkBasics(errs *fieldErrors, m *models.CouponMeta) {
+	switch {
+	case models.NormalizeCouponCode(m.CouponCode) == "":
+		errs.add("coupon_code", CodeRequired, "coupon_code is required")
+	case len(m.CouponCode) > maxCouponCodeLen:
+		errs.add("coupon_code", CodeOutOfRange, fmt.Sprintf("coupon_code must be at most %d characters", maxCouponCodeLen))
+	}
+
+	switch {
+	case m.UsageType == "":
+		errs.add("usage_type", CodeRequired, "usage_type is required")
+	case !usageTypes[m.UsageType]:
+		errs.add("usage_type", CodeInvalid, "usage_type must be one_time, multi_use or time_based")
+	}
+	switch {
+	case m.TargetType == "":
+		errs.add("target_type", CodeRequired, "target_type is required")
+	case !targetTypes[m.TargetType]:
+		errs.add("target_type", CodeInvalid, "target_type must be inventory or charges")
+	}
+
+	if m.MinOrderValue < 0 {
+		errs.add("min_order_value", CodeOutOfRange, "min_order_value must not be negative")
+	}
+	if m.MaxUsagePerUser < 0 {
+		errs.add("max_usage_per_user", CodeOutOfRange, "max_usage_per_user must not be negative (0 = unlimited)")
+	} else if m.UsageType == "one_time" && m.MaxUsagePerUser > 1 {
+		errs.add("max_usage_per_user", CodeConflict, "one_time coupons can be used at most once per user")
+	}
+}
+
+// checkDates needs valid_from < valid_to <= expiry_date
+func checkDates(errs *fieldErrors, m *models.CouponMeta, now time.Time) {
+	if m.ExpiryDate.IsZero() {
+		errs.add("expiry_date", CodeRequired, "expiry_date is required")
+	} else if !now.IsZero() && !m.ExpiryDate.After(now) {
+		errs.add("expiry_date", CodeExpired, "expiry_date must be in the future")
+	}
+
+	if m.ValidFrom != nil && m.ValidTo != nil && !m.ValidFrom.Before(*m.ValidTo) {
+		errs.add("valid_to", CodeConflict, "valid_to must be after valid_from")
+	}
+	if !m.ExpiryDate.IsZero() {
+		if m.ValidTo != nil && m.ValidTo.After(m.ExpiryDate) {
+			errs.add("valid_to", CodeConflict, "valid_to must not be after expiry_date")
+		}
+		if m.ValidFrom != nil && !m.ValidFrom.Before(m.ExpiryDate) {
+			errs.add("valid_from", CodeConflict, "valid_from must be before expiry_date")
+		}
+	}
+	if m.UsageType == "time_based" && m.ValidFrom == nil && m.ValidTo == nil {
+		errs.add("valid_from", CodeRequired, "time_based coupons need valid_from or valid_to")
+	}
+}
+
+func checkDiscount(errs *fieldErrors, m *models.CouponMeta) {
+	if m.DiscountType == "" {
+		errs.add("discount_type", CodeRequired, "discount_type is required")
+		return
+	}
+	if !discountTypes[m.DiscountType] {
+		errs.add("discount_type", CodeInvalid, "discount_type must be flat, percentage, bogo, tiered, per_unit_flat or fixed_price")
+		return
+	}
+
+	switch m.DiscountType {
+	case "tiered":
+		// the tiers carry the discount
+	case "percentage", "bogo":
+		// bogo: the percentage taken off the "get" units (100 = free)
+		if m.DiscountValue <= 0 || m.DiscountValue > 100 {
+			errs.add("discount_value", CodeOutOfRange, m.DiscountType+" discount_value must be above 0 and at most 100")
+		}
+	default:
+		if m.DiscountValue <= 0 {
+			errs.add("discount_value", CodeOutOfRange, "discount_value must be positive")
+		}
+	}
+
+	if m.DiscountType == "bogo" {
+		if m.BuyQty <= 0 {
+			errs.add("buy_quantity", CodeRequired, "bogo needs a positive buy_quantity")
+		}
+		if m.GetQty <= 0 {
+			errs.add("get_quantity", CodeRequired, "bogo needs a positive get_quantity")
+		}
+	} else {
+		if m.BuyQty != 0 {
+			errs.add("buy_quantity", CodeNotAllowed, "buy_quantity is only valid for bogo")
+		}
+		if m.GetQty != 0 {
+			errs.add("get_quantity", CodeNotAllowed, "get_quantity is only valid for bogo")
+		}
+		if len(m.RewardItems) > 0 {
+			errs.add("reward_medicine_ids", CodeNotAllowed, "reward_medicine_ids are only valid for bogo")
+		}
+		if len(m.RewardCategories) > 0 {
+			errs.add("reward_categories", CodeNotAllowed, "reward_categories are only valid for bogo")
+		}
+	}
+
+	switch m.DiscountType {
+	case "bogo", "per_unit_flat", "fixed_price":
+		// per_unit_flat: discount_value off each unit; fixed_price: each unit sold at discount_value
+		if targetTypes[m.TargetType] && m.TargetType != "inventory" {
+			errs.add("target_type", CodeConflict, m.DiscountType+" needs target_type inventory")
+		}
+	}
+	if m.MaxUnitsDiscounted < 0 {
+		errs.add("max_units_discounted", CodeOutOfRange, "max_units_discounted must not be negative")
+	}
+
+	if m.DiscountType == "tiered" {
+		checkTiers(errs, m.TierBasis, m.Tiers)
+	} else {
+		if m.TierBasis != "" {
+			errs.add("tier_basis", CodeNotAllowed, "tier_basis is only valid for tiered")
+		}
+		if len(m.Tiers) > 0 {
+			errs.add("tiers", CodeNotAllowed, "tiers are only valid for tiered")
+		}
+	}
+}
+
+// checkTiers checks the tier list of a tiered coupon
+func checkTiers(errs *fieldErrors, basis string, tiers []models.DiscountTier) {
+	if basis != "order_total" && basis != "eligible_qty" {
+		errs.add("tier_basis", CodeInvalid, "tier_basis must be order_total or eligible_qty")
+	}
+	if len(tiers) == 0 {
+		errs.add("tiers", CodeRequired, "tiered coupons need at least one tier")
+	}
+	seen := make(map[float64]bool)
+	for i, t := range tiers {
+		field := fmt.Sprintf("tiers[%d].", i)
+		if t.Threshold < 0 {
+			errs.add(field+"threshold", CodeOutOfRange, "tier threshold must not be negative")
+		} else if seen[t.Threshold] {
+			errs.add(field+"threshold", CodeConflict, "tier thresholds must be unique")
+		}
+		seen[t.Threshold] = true
+		if t.DiscountType != "flat" && t.DiscountType != "percentage" {
+			errs.add(field+"discount_type", CodeInvalid, "tier discount_type must be flat or percentage")
+		}
+		if t.DiscountValue <= 0 || (t.DiscountType == "percentage" && t.DiscountValue > 100) {
+			errs.add(field+"discount_value", CodeOutOfRange, "tier discount_value must be positive (and at most 100 for percentage)")
+		}
+	}
+}
+
+func checkEligibility(errs *fieldErrors, m *models.CouponMeta) {
+	if m.MinEligibleQty < 0 {
+		errs.add("min_eligible_qty", CodeOutOfRange, "min_eligible_qty must not be negative")
+	}
+	if m.MinEligibleSubtotal < 0 {
+		errs.add("min_eligible_subtotal", CodeOutOfRange, "min_eligible_subtotal must not be negative")
+	}
+	for i, c := range m.Conditions {
+		if err := c.Validate(); err != nil {
+			errs.add(fmt.Sprintf("eligibility_conditions[%d]", i), CodeInvalid, "invalid eligibility condition: "+err.Error())
+		}
+	}
+
+	if m.MinPriorOrders != nil && *m.MinPriorOrders < 0 {
+		errs.add("min_prior_orders", CodeOutOfRange, "min_prior_orders must not be negative")
+	}
+	if m.MaxPriorOrders != nil && *m.MaxPriorOrders < 0 {
+		errs.add("max_prior_orders", CodeOutOfRange, "max_prior_orders must not be negative")
+	}
+	if m.MinPriorOrders != nil && m.MaxPriorOrders != nil && *m.MinPriorOrders > *m.MaxPriorOrders {
+		errs.add("max_prior_orders", CodeConflict, "max_prior_orders must not be below min_prior_orders")
+	}
+}
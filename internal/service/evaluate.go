@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	concurrency "github.com/Cheertaboi/Billing-system-coupon-microservice/internal/concurrrency"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// evaluate is the single evaluator behind validate, quote and applicable: it runs
+// the eligibility pipeline and prices the coupon, without consuming any usage.
+// A rejected coupon comes back with IsValid false and the rejection reason.
+func (s *CouponService) evaluate(ctx context.Context, couponMeta *models.CouponMeta, cart *concurrency.Cart, user *concurrency.User) (ValidateResponse, error) {
+	// Eligibility rules
+	if res := s.rules.Evaluate(ctx, couponMeta, cart, user); !res.Pass {
+		if res.Err != nil {
+			return ValidateResponse{IsValid: false, Message: "internal_error"}, fmt.Errorf("rule %s: %w", res.Rule, res.Err)
+		}
+		return ValidateResponse{IsValid: false, Message: res.Reason}, nil
+	}
+
+	eligibleQty, eligibleSubtotal := couponMeta.EligibleTotals(cart.Items)
+
+	// Parallel item applicability checks using worker pool
+	// worker input: CartItem, output: applicability flags + discount contribution
+	type itemIn struct {
+		idx int
+		it  models.CartItem
+	}
+	type itemOut struct {
+		idx      int
+		it       models.CartItem
+		applies  bool // matches the coupon's item/category filters (buy side for bogo)
+		reward   bool // matches the bogo "get" side
+		unitDisc float64
+	}
+
+	// determine workerCount relative to cart size (but at least 2)
+	workerCount := 4
+	if len(cart.Items) > 0 && len(cart.Items) < workerCount {
+		workerCount = len(cart.Items)
+		if workerCount == 0 {
+			workerCount = 1
+		}
+	}
+
+	inCh := make(chan itemIn)
+	outCh := make(chan itemOut)
+
+	// spawn workers
+	for i := 0; i < workerCount; i++ {
+		go func() {
+			for in := range inCh {
+				it := in.it
+				// check applicability
+				applies := couponMeta.Matches(it)
+				reward := couponMeta.DiscountType == "bogo" && couponMeta.MatchesReward(it)
+				// compute per-unit discount for items (inventory target)
+				unitDisc := 0.0
+				if applies && couponMeta.TargetType == "inventory" {
+					switch couponMeta.DiscountType {
+					case "percentage":
+						unitDisc = it.Price * (couponMeta.DiscountValue / 100.0)
+					case "per_unit_flat":
+						unitDisc = couponMeta.DiscountValue
+					case "fixed_price":
+						// DiscountValue is the price each unit is sold at
+						unitDisc = it.Price - couponMeta.DiscountValue
+					default: // flat / bogo / tiered
+						// these depend on the whole cart, so they are settled after collection
+						unitDisc = 0.0
+					}
+					// a line never goes negative (and never gets a surcharge)
+					unitDisc = min(max(unitDisc, 0), max(it.Price, 0))
+				}
+				select {
+				case outCh <- itemOut{idx: in.idx, it: it, applies: applies, reward: reward, unitDisc: unitDisc}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// send items
+	go func() {
+		defer close(inCh)
+		for i, it := range cart.Items {
+			select {
+			case inCh <- itemIn{idx: i, it: it}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// collect results
+	var matchedLines []itemOut
+	collectDone := make(chan struct{})
+	go func() {
+		defer close(collectDone)
+		for range cart.Items {
+			select {
+			case o := <-outCh:
+				if o.applies || o.reward {
+					matchedLines = append(matchedLines, o)
+				}
+			case <-ctx.Done():
+				// exit early
+				return
+			}
+		}
+	}()
+
+	// Wait until collectors finished or context done
+	select {
+	case <-collectDone:
+	case <-ctx.Done():
+		return ValidateResponse{IsValid: false, Message: "timeout_during_item_checks"}, ctx.Err()
+	}
+
+	// workers finish out of order; keep cart order so the result is stable
+	slices.SortFunc(matchedLines, func(a, b itemOut) int { return a.idx - b.idx })
+
+	// per-item discounts, limited to the best MaxUnitsDiscounted units when set
+	unitLines := make([]discountedLine, 0, len(matchedLines))
+	for _, o := range matchedLines {
+		if o.applies {
+			unitLines = append(unitLines, discountedLine{qty: o.it.Qty, unitDisc: o.unitDisc})
+		}
+	}
+	totalItemsDiscount := sumLineDiscounts(unitLines, couponMeta.MaxUnitsDiscounted)
+
+	// bogo: pick the free units from the matched lines
+	var freeItems []models.FreeItem
+	bogoDiscount := 0.0
+	if couponMeta.DiscountType == "bogo" {
+		lines := make([]BogoLine, 0, len(matchedLines))
+		for _, o := range matchedLines {
+			lines = append(lines, BogoLine{Item: o.it, Buy: o.applies, Get: o.reward})
+		}
+		freeItems, bogoDiscount = BogoFreeItems(couponMeta, lines)
+		if len(freeItems) == 0 {
+			return ValidateResponse{IsValid: false, Message: "bogo_quantity_not_met"}, nil
+		}
+	}
+
+	// tiered: price the highest tier reached
+	var nextTier *models.NextTier
+	tieredDiscount := 0.0
+	if couponMeta.DiscountType == "tiered" {
+		var reached bool
+		tieredDiscount, reached, nextTier = TieredDiscount(couponMeta, cart.OrderTotal, eligibleQty, eligibleSubtotal)
+		if !reached {
+			return ValidateResponse{IsValid: false, Message: "tier_threshold_not_met", NextTier: nextTier}, nil
+		}
+	}
+
+	// compute charges discount if target_type == "charges"
+	chargesDiscount := 0.0
+	if couponMeta.TargetType == "charges" {
+		if couponMeta.DiscountType == "percentage" {
+			chargesDiscount = cart.OrderTotal * (couponMeta.DiscountValue / 100.0)
+		} else {
+			// flat on charges
+			chargesDiscount = couponMeta.DiscountValue
+		}
+	}
+
+	// handle flat per-order inventory discounts:
+	flatInventoryDiscount := 0.0
+	if couponMeta.TargetType == "inventory" && couponMeta.DiscountType == "flat" {
+		flatInventoryDiscount = couponMeta.DiscountValue
+	}
+
+	// choose final discount (simple strategy):
+	totalDiscount := totalItemsDiscount
+	if couponMeta.TargetType == "charges" {
+		totalDiscount = chargesDiscount
+	} else if couponMeta.TargetType == "inventory" {
+		// if flat discount, apply flat once; per-item types already set totalItemsDiscount
+		if couponMeta.DiscountType == "flat" {
+			totalDiscount = flatInventoryDiscount
+		}
+		if couponMeta.DiscountType == "bogo" {
+			totalDiscount = bogoDiscount
+		}
+	}
+	if couponMeta.DiscountType == "tiered" {
+		totalDiscount = tieredDiscount
+	}
+
+	return ValidateResponse{
+		IsValid:   true,
+		Discount:  totalDiscount,
+		Message:   "coupon_applied",
+		FreeItems: freeItems,
+		NextTier:  nextTier,
+	}, nil
+}
+
+// discountedLine is a matched cart line with its per-unit discount
+type discountedLine struct {
+	qty      int
+	unitDisc float64
+}
+
+// sumLineDiscounts totals per-unit discounts. When maxUnits > 0 only that many
+// units are discounted, taking the units with the biggest discount first.
+func sumLineDiscounts(lines []discountedLine, maxUnits int) float64 {
+	if maxUnits > 0 {
+		lines = slices.Clone(lines)
+		slices.SortStableFunc(lines, func(a, b discountedLine) int {
+			switch {
+			case a.unitDisc > b.unitDisc:
+				return -1
+			case a.unitDisc < b.unitDisc:
+				return 1
+			}
+			return 0
+		})
+	}
+
+	total := 0.0
+	left := maxUnits
+	for _, l := range lines {
+		qty := max(l.qty, 0)
+		if maxUnits > 0 {
+			qty = min(qty, left)
+			left -= qty
+		}
+		total += float64(qty) * l.unitDisc
+	}
+	return total
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// userFacts looks up per-user facts for the rule pipeline on demand.
+// Reads are non-locking; the redemption path re-checks usage under lock.
+type userFacts struct {
+	s           *CouponService
+	userID      string
+	priorOrders *int // caller-supplied or cached after the first lookup
+
+	// set when facts were batch-loaded with the candidate list: usage per coupon
+	// (missing means unused) and the assigned coupons issued to the user
+	usage    map[int]int
+	assigned map[int]bool
+}
+
+func (s *CouponService) factsFor(userID string, u models.UserContext) *userFacts {
+	return &userFacts{s: s, userID: userID, priorOrders: u.PriorOrders}
+}
+
+func (f *userFacts) UsageCount(ctx context.Context, couponID int) (int, error) {
+	if f.usage != nil {
+		return f.usage[couponID], nil
+	}
+	return f.s.usageRepo.GetUsageCount(ctx, couponID, f.userID)
+}
+
+func (f *userFacts) IsAssigned(ctx context.Context, couponID int) (bool, error) {
+	if f.assigned != nil {
+		return f.assigned[couponID], nil
+	}
+	return f.s.assignRepo.IsAssigned(ctx, couponID, f.userID)
+}
+
+// PriorOrders prefers the caller-supplied order count and falls back to the
+// user's redemption history across all coupons
+func (f *userFacts) PriorOrders(ctx context.Context) (int, error) {
+	if f.priorOrders != nil {
+		return *f.priorOrders, nil
+	}
+	n, err := f.s.usageRepo.CountUserRedemptions(ctx, f.userID)
+	if err != nil {
+		return 0, err
+	}
+	f.priorOrders = &n
+	return n, nil
+}
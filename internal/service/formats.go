@@ -0,0 +1,101 @@
+package service
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/couponcode"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// codeFormats keeps the code formats of all templates in memory, so a code
+// carrying a template's prefix is verified without touching the database.
+// Prefixes never overlap, so at most one format matches a code. Until the
+// formats are loaded nothing matches and codes are looked up as usual.
+type codeFormats struct {
+	mu         sync.RWMutex
+	byTemplate map[string]*couponcode.Generator // normalized template code -> format
+	isLoaded   bool                             // set by the first replace
+}
+
+func newCodeFormats() *codeFormats {
+	return &codeFormats{byTemplate: make(map[string]*couponcode.Generator)}
+}
+
+// replace swaps in all formats, keyed by template code
+func (f *codeFormats) replace(formats map[string]couponcode.Format) {
+	byTemplate := make(map[string]*couponcode.Generator, len(formats))
+	for code, format := range formats {
+		g, err := couponcode.NewGenerator(format)
+		if err != nil {
+			log.Printf("code format of %s: %v", code, err)
+			continue
+		}
+		byTemplate[models.NormalizeCouponCode(code)] = g
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.byTemplate = byTemplate
+	f.isLoaded = true
+}
+
+// loaded reports whether all formats were loaded, so a code no format
+// matches can't be a child code
+func (f *codeFormats) loaded() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.isLoaded
+}
+
+// set stores the format of a template; nil removes it
+func (f *codeFormats) set(templateCode string, format *couponcode.Format) error {
+	key := models.NormalizeCouponCode(templateCode)
+	if format == nil {
+		f.remove(key)
+		return nil
+	}
+	g, err := couponcode.NewGenerator(*format)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.byTemplate[key] = g
+	return nil
+}
+
+func (f *codeFormats) remove(templateCode string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.byTemplate, models.NormalizeCouponCode(templateCode))
+}
+
+// match returns the format whose prefix code carries, or nil
+func (f *codeFormats) match(code string) *couponcode.Generator {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, g := range f.byTemplate {
+		if g.Matches(code) {
+			return g
+		}
+	}
+	return nil
+}
+
+// overlaps reports whether a format of another template has a prefix that
+// starts with prefix or that prefix starts with (both normalized)
+func (f *codeFormats) overlaps(templateCode, prefix string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	key := models.NormalizeCouponCode(templateCode)
+	for code, g := range f.byTemplate {
+		other := g.Spec().NormalizedPrefix()
+		if code != key && (strings.HasPrefix(other, prefix) || strings.HasPrefix(prefix, other)) {
+			return true
+		}
+	}
+	return false
+}
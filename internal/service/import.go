@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// MaxImportRows caps one ImportCoupons call; larger files are split by the caller
+const MaxImportRows = 10_000
+
+// ImportRow is one coupon of a bulk import. Errors holds problems found while
+// parsing the row; the service adds its own checks unless Coupon is nil, i.e.
+// the row could not be parsed at all.
+type ImportRow struct {
+	Line          int
+	Coupon        *models.CouponMeta
+	AssignedUsers []string
+	Errors        []FieldError
+}
+
+// RowError lists the problems of one import row
+type RowError struct {
+	Line       int          `json:"line"`
+	CouponCode string       `json:"coupon_code,omitempty"`
+	Errors     []FieldError `json:"errors"`
+}
+
+// InvalidImportError rejects a whole import and lists every invalid row
+type InvalidImportError struct {
+	Rows []RowError
+}
+
+func (e *InvalidImportError) Error() string {
+	return fmt.Sprintf("invalid import: %d invalid rows", len(e.Rows))
+}
+
+// ImportCoupons creates all rows as new coupons in one transaction, or none of
+// them if any row is invalid (*InvalidImportError). Rows get the same checks as
+// CreateCoupon, so the expiry must lie in the future. Codes may not exist yet
+// and must be unique within the import. With dryRun the rows are only checked.
+// It returns the number of valid rows.
+func (s *CouponService) ImportCoupons(ctx context.Context, rows []ImportRow, dryRun bool) (int, error) {
+	if len(rows) > MaxImportRows {
+		return 0, &InvalidImportError{Rows: []RowError{{
+			Line:   rows[MaxImportRows].Line,
+			Errors: []FieldError{{Field: "rows", Code: CodeOutOfRange, Message: fmt.Sprintf("at most %d coupons per import", MaxImportRows)}},
+		}}}
+	}
+
+	now := time.Now()
+	rowErrs := make([]fieldErrors, len(rows))
+	firstRow := make(map[string]int, len(rows)) // normalized code -> index of its first row
+	for i, row := range rows {
+		if row.Coupon == nil {
+			continue
+		}
+		errs := fieldErrors(row.Errors)
+		reported := make(map[string]bool, len(errs))
+		for _, fe := range errs {
+			reported[fe.Field] = true
+		}
+		for _, fe := range s.CheckNew(row.Coupon, row.AssignedUsers, now) {
+			if !reported[fe.Field] {
+				errs = append(errs, fe)
+				reported[fe.Field] = true
+			}
+		}
+
+		if key := models.NormalizeCouponCode(row.Coupon.CouponCode); key != "" && !reported["coupon_code"] {
+			if first, dup := firstRow[key]; dup {
+				errs.add("coupon_code", CodeConflict, fmt.Sprintf("coupon_code is imported on line %d already", rows[first].Line))
+			} else {
+				firstRow[key] = i
+			}
+		}
+		rowErrs[i] = errs
+	}
+
+	// one query for all codes
+	codes := make([]string, 0, len(firstRow))
+	for key := range firstRow {
+		codes = append(codes, key)
+	}
+	taken, err := s.couponRepo.TakenCodes(ctx, codes)
+	if err != nil {
+		return 0, err
+	}
+	for key := range taken {
+		if i, ok := firstRow[key]; ok {
+			rowErrs[i].add("coupon_code", CodeConflict, "coupon_code is taken")
+		}
+	}
+
+	var invalid []RowError
+	for i, row := range rows {
+		switch {
+		case row.Coupon == nil:
+			invalid = append(invalid, RowError{Line: row.Line, Errors: row.Errors})
+		case len(rowErrs[i]) > 0:
+			invalid = append(invalid, RowError{Line: row.Line, CouponCode: row.Coupon.CouponCode, Errors: rowErrs[i]})
+		}
+	}
+	if len(invalid) > 0 {
+		return len(rows) - len(invalid), &InvalidImportError{Rows: invalid}
+	}
+	if dryRun {
+		return len(rows), nil
+	}
+
+	tx, err := s.txs.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	for _, row := range rows {
+		if _, err := s.couponRepo.Create(ctx, tx, row.Coupon, row.AssignedUsers); err != nil {
+			if errors.Is(err, models.ErrDuplicateCouponCode) {
+				// created or generated since the check
+				return 0, &InvalidImportError{Rows: []RowError{{
+					Line: row.Line, CouponCode: row.Coupon.CouponCode,
+					Errors: []FieldError{{Field: "coupon_code", Code: CodeConflict, Message: "coupon_code is taken"}},
+				}}}
+			}
+			return 0, fmt.Errorf("create coupon %s: %w", row.Coupon.CouponCode, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("tx commit: %w", err)
+	}
+
+	for _, row := range rows {
+		s.refresh(ctx, row.Coupon.CouponCode)
+	}
+	return len(rows), nil
+}
+
+// EachCoupon streams the coupons ordered by id with their assigned users, from
+// one snapshot. With a non-zero now, coupons that expired by then are skipped,
+// as ImportCoupons would reject them.
+func (s *CouponService) EachCoupon(ctx context.Context, now time.Time, fn func(m *models.CouponMeta, assignedUsers []string) error) error {
+	return s.couponRepo.EachCoupon(ctx, func(m *models.CouponMeta, assignedUsers []string) error {
+		if !now.IsZero() && !m.ExpiryDate.After(now) {
+			return nil
+		}
+		return fn(m, assignedUsers)
+	})
+}
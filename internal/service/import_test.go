@@ -0,0 +1,81 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/couponcode"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository/memory"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/service"
+)
+
+// countingCoupons counts TakenCodes queries
+type countingCoupons struct {
+	*memory.CouponRepo
+	takenQueries int
+}
+
+func (c *countingCoupons) TakenCodes(ctx context.Context, norms []string) (map[string]bool, error) {
+	c.takenQueries++
+	return c.CouponRepo.TakenCodes(ctx, norms)
+}
+
+func TestImportReportsTakenCodes(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	coupons := &countingCoupons{CouponRepo: memory.NewCouponRepo(store)}
+	codes := memory.NewChildCodeRepo(store)
+	svc := service.NewCouponService(store, coupons, memory.NewUsageRepo(store), memory.NewAssignmentRepo(store), codes)
+
+	if _, err := svc.CreateCoupon(ctx, percentCoupon("EXISTING", 10), nil); err != nil {
+		t.Fatal(err)
+	}
+	template := percentCoupon("SMS", 10)
+	template.IsTemplate = true
+	if _, err := svc.CreateCoupon(ctx, template, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.GenerateCodes(ctx, "SMS", couponcode.Spec{Prefix: "SMS-"}, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	var generated string
+	_ = svc.EachChildCode(ctx, "SMS", "", func(cc *models.ChildCode) error {
+		generated = cc.Code
+		return nil
+	})
+
+	rows := []service.ImportRow{
+		{Line: 1, Coupon: percentCoupon("NEW1", 10)},
+		{Line: 2, Coupon: percentCoupon("existing", 10)},
+		{Line: 3, Coupon: percentCoupon("NEW-1", 10)},
+		{Line: 4, Coupon: percentCoupon("NEW2", 10)},
+	}
+	_, err := svc.ImportCoupons(ctx, rows, true)
+	var invalid *service.InvalidImportError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("import: %v", err)
+	}
+	lines := map[int]bool{}
+	for _, row := range invalid.Rows {
+		if len(row.Errors) != 1 || row.Errors[0].Field != "coupon_code" || row.Errors[0].Code != service.CodeConflict {
+			t.Errorf("line %d: %+v", row.Line, row.Errors)
+		}
+		lines[row.Line] = true
+	}
+	if len(lines) != 2 || !lines[2] || !lines[3] {
+		t.Errorf("invalid rows %+v, want lines 2 and 3", invalid.Rows)
+	}
+	if coupons.takenQueries != 1 {
+		t.Errorf("%d TakenCodes queries, want 1", coupons.takenQueries)
+	}
+
+	// generated codes carry a template prefix, which CheckNew reports first;
+	// they are taken all the same
+	norm := models.NormalizeCouponCode(generated)
+	taken, err := coupons.TakenCodes(ctx, []string{norm, "NEW2"})
+	if err != nil || len(taken) != 1 || !taken[norm] {
+		t.Errorf("TakenCodes: %v, %v", taken, err)
+	}
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// CouponIndex keeps active coupons in memory keyed by the medicine IDs and
+// categories they are restricted to, so the applicable listing only evaluates
+// coupons that can match something in the cart. Coupons without item/category
+// restrictions match every cart and are kept in a separate list.
+//
+// A restricted coupon with no matching cart line always fails the items rule,
+// so skipping it never changes the result.
+type CouponIndex struct {
+	mu           sync.RWMutex
+	ready        bool
+	byCode       map[string]*models.CouponMeta            // normalized code -> coupon
+	byItem       map[string]map[string]*models.CouponMeta // medicine id -> normalized code -> coupon
+	byCategory   map[string]map[string]*models.CouponMeta // category -> normalized code -> coupon
+	unrestricted map[string]*models.CouponMeta
+}
+
+func NewCouponIndex() *CouponIndex {
+	return &CouponIndex{
+		byCode:       make(map[string]*models.CouponMeta),
+		byItem:       make(map[string]map[string]*models.CouponMeta),
+		byCategory:   make(map[string]map[string]*models.CouponMeta),
+		unrestricted: make(map[string]*models.CouponMeta),
+	}
+}
+
+// Ready reports whether the index has been loaded and can serve lookups
+func (x *CouponIndex) Ready() bool {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.ready
+}
+
+// Len returns the number of indexed coupons
+func (x *CouponIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.byCode)
+}
+
+// Replace rebuilds the whole index from metas and marks it ready
+func (x *CouponIndex) Replace(metas []*models.CouponMeta) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.byCode = make(map[string]*models.CouponMeta, len(metas))
+	x.byItem = make(map[string]map[string]*models.CouponMeta)
+	x.byCategory = make(map[string]map[string]*models.CouponMeta)
+	x.unrestricted = make(map[string]*models.CouponMeta)
+	for _, m := range metas {
+		x.add(m)
+	}
+	x.ready = true
+}
+
+// Upsert adds or replaces a single coupon
+func (x *CouponIndex) Upsert(m *models.CouponMeta) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(models.NormalizeCouponCode(m.CouponCode))
+	x.add(m)
+}
+
+// Remove drops a coupon by code in any spelling; unknown codes are ignored
+func (x *CouponIndex) Remove(code string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.remove(models.NormalizeCouponCode(code))
+}
+
+// Candidates returns the indexed coupons that could apply to the cart, ordered
+// by coupon id. Coupons that are expired, outside their window or above the
+// order total at now are left out, mirroring CouponRepo.ListApplicableCandidates;
+// audience is checked by the caller.
+func (x *CouponIndex) Candidates(items []models.CartItem, now time.Time, orderTotal float64) []*models.CouponMeta {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	seen := make(map[string]*models.CouponMeta, len(x.unrestricted))
+	for code, m := range x.unrestricted {
+		seen[code] = m
+	}
+	for _, it := range items {
+		for code, m := range x.byItem[it.ID] {
+			seen[code] = m
+		}
+		for code, m := range x.byCategory[it.Category] {
+			seen[code] = m
+		}
+	}
+
+	out := make([]*models.CouponMeta, 0, len(seen))
+	for _, m := range seen {
+		if activeAt(m, now, orderTotal) {
+			out = append(out, m)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (x *CouponIndex) add(m *models.CouponMeta) {
+	code := models.NormalizeCouponCode(m.CouponCode)
+	x.byCode[code] = m
+	if !m.IsRestricted() {
+		x.unrestricted[code] = m
+		return
+	}
+	for _, id := range m.ApplicableItems {
+		addTo(x.byItem, id, code, m)
+	}
+	for _, c := range m.ApplicableCategories {
+		addTo(x.byCategory, c, code, m)
+	}
+}
+
+func (x *CouponIndex) remove(code string) {
+	m, ok := x.byCode[code]
+	if !ok {
+		return
+	}
+	delete(x.byCode, code)
+	delete(x.unrestricted, code)
+	for _, id := range m.ApplicableItems {
+		removeFrom(x.byItem, id, code)
+	}
+	for _, c := range m.ApplicableCategories {
+		removeFrom(x.byCategory, c, code)
+	}
+}
+
+func addTo(keyed map[string]map[string]*models.CouponMeta, key, code string, m *models.CouponMeta) {
+	set, ok := keyed[key]
+	if !ok {
+		set = make(map[string]*models.CouponMeta)
+		keyed[key] = set
+	}
+	set[code] = m
+}
+
+func removeFrom(keyed map[string]map[string]*models.CouponMeta, key, code string) {
+	set := keyed[key]
+	delete(set, code)
+	if len(set) == 0 {
+		delete(keyed, key)
+	}
+}
+
+// activeAt is the cheap prefilter the SQL candidate query applies
+func activeAt(m *models.CouponMeta, now time.Time, orderTotal float64) bool {
+	if m.ExpiryDate.Before(now) {
+		return false
+	}
+	if m.ValidFrom != nil && now.Before(*m.ValidFrom) {
+		return false
+	}
+	if m.ValidTo != nil && now.After(*m.ValidTo) {
+		return false
+	}
+	return m.MinOrderValue <= orderTotal
+}
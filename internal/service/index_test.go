@@ -0,0 +1,149 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository/memory"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/service"
+)
+
+// catalogue is a synthetic set of item/category-restricted coupons plus a share
+// of unrestricted ones, served from one store with and without the index
+type catalogue struct {
+	scan    *service.CouponService // every active coupon is evaluated
+	indexed *service.CouponService // only coupons keyed by the cart plus unrestricted ones
+	carts   []service.ValidateRequest
+	now     time.Time
+}
+
+const (
+	benchMedicines    = 5000
+	benchCategories   = 200
+	benchUnrestricted = 0.02
+	benchCartLines    = 5
+)
+
+func newCatalogue(tb testing.TB, coupons int) *catalogue {
+	tb.Helper()
+	rng := rand.New(rand.NewSource(1))
+	c := &catalogue{now: time.Now().UTC()}
+
+	store := memory.NewStore()
+	repo := memory.NewCouponRepo(store)
+	for i := range coupons {
+		m := benchCoupon(rng, i+1, c.now)
+		// Put skips validation; the generator must still produce valid coupons
+		if errs := service.CheckDefinition(m, nil, c.now); len(errs) > 0 {
+			tb.Fatalf("%s is invalid: %+v", m.CouponCode, errs)
+		}
+		repo.Put(m)
+	}
+	usage, assignments, childCodes := memory.NewUsageRepo(store), memory.NewAssignmentRepo(store), memory.NewChildCodeRepo(store)
+	c.scan = service.NewCouponService(store, repo, usage, assignments, childCodes)
+	c.indexed = service.NewCouponService(store, repo, usage, assignments, childCodes)
+	if err := c.indexed.LoadIndex(context.Background()); err != nil {
+		tb.Fatal(err)
+	}
+
+	c.carts = make([]service.ValidateRequest, 256)
+	for i := range c.carts {
+		c.carts[i] = benchCart(rng)
+	}
+	return c
+}
+
+func benchCoupon(rng *rand.Rand, id int, now time.Time) *models.CouponMeta {
+	m := &models.CouponMeta{Coupon: models.Coupon{
+		ID:            id,
+		CouponCode:    fmt.Sprintf("BENCH%06d", id),
+		ExpiryDate:    now.Add(30 * 24 * time.Hour),
+		UsageType:     "multi_use",
+		DiscountType:  "percentage",
+		DiscountValue: float64(5 + rng.Intn(20)),
+		Audience:      "public",
+		TargetType:    "inventory",
+	}}
+	switch r := rng.Float64(); {
+	case r < benchUnrestricted:
+	case r < 0.5:
+		for range 1 + rng.Intn(3) {
+			m.ApplicableItems = append(m.ApplicableItems, medicineID(rng.Intn(benchMedicines)))
+		}
+	default:
+		m.ApplicableCategories = []string{categoryName(rng.Intn(benchCategories))}
+	}
+	return m
+}
+
+func benchCart(rng *rand.Rand) service.ValidateRequest {
+	req := service.ValidateRequest{UserID: fmt.Sprintf("bench-user-%d", rng.Intn(1000))}
+	for range benchCartLines {
+		it := item(medicineID(rng.Intn(benchMedicines)), categoryName(rng.Intn(benchCategories)), float64(50+rng.Intn(500)), 1+rng.Intn(3))
+		req.CartItems = append(req.CartItems, it)
+		req.OrderTotal += it.Price * float64(it.Qty)
+	}
+	return req
+}
+
+func medicineID(i int) string   { return fmt.Sprintf("MED%05d", i) }
+func categoryName(i int) string { return fmt.Sprintf("Category%02d", i) }
+
+func TestApplicableIndexMatchesScan(t *testing.T) {
+	c := newCatalogue(t, 500)
+	matched := 0
+	for i, req := range c.carts {
+		scan, _, err := c.scan.ApplicableCoupons(context.Background(), req, c.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		indexed, _, err := c.indexed.ApplicableCoupons(context.Background(), req, c.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(codes(scan), codes(indexed)) {
+			t.Fatalf("cart %d: scan %v, index %v", i, codes(scan), codes(indexed))
+		}
+		matched += len(scan)
+	}
+	if matched == 0 {
+		t.Fatal("no cart matched any coupon")
+	}
+}
+
+func codes(cs []service.ApplicableCoupon) []string {
+	out := make([]string, len(cs))
+	for i, c := range cs {
+		out[i] = c.CouponCode
+	}
+	return out
+}
+
+// There is no database latency here: the scan only pays for copying every
+// candidate out of the in-memory store, so against Postgres the gap is wider.
+func benchmarkApplicable(b *testing.B, indexed bool) {
+	for _, n := range []int{1000, 20000} {
+		b.Run(fmt.Sprintf("coupons=%d", n), func(b *testing.B) {
+			c := newCatalogue(b, n)
+			s := c.scan
+			if indexed {
+				s = c.indexed
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := s.ApplicableCoupons(context.Background(), c.carts[i%len(c.carts)], c.now); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkApplicableScan(b *testing.B)  { benchmarkApplicable(b, false) }
+func BenchmarkApplicableIndex(b *testing.B) { benchmarkApplicable(b, true) }
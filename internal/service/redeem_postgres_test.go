@@ -0,0 +1,40 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository/pgtest"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/service"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/uow"
+)
+
+// TestValidateConcurrentFirstUsePostgres races a user's first redemptions
+// through the real statements. An attempt only fails when another worker's
+// redemption committed after it began, so no worker fails more often than
+// there are other workers; with as many workers as the service makes
+// attempts, none may run out of retries.
+func TestValidateConcurrentFirstUsePostgres(t *testing.T) {
+	const workers, limit = 5, 3 // workers: maxRedeemAttempts
+	db := pgtest.Open(t)
+	usage := repository.NewUsageRepo(db)
+	svc := service.NewCouponService(uow.NewSQL(db), repository.NewCouponRepo(db), usage, repository.NewAssignmentRepo(db), repository.NewChildCodeRepo(db))
+	m := percentCoupon("FIRST", 10)
+	m.MaxUsagePerUser = limit
+	if _, err := svc.CreateCoupon(context.Background(), m, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	redeemed, reasons := hammer(t, svc, cartRequest("new-user", "FIRST", item("med1", "painkillers", 40, 5)), workers, 1)
+	if len(redeemed) != limit || reasons["usage_limit_reached"] != workers-limit {
+		t.Errorf("%d redeemed, rejected %v; want %d and %d usage_limit_reached", len(redeemed), reasons, limit, workers-limit)
+	}
+	got, err := svc.GetCoupon(context.Background(), "FIRST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := usage.GetUsageCount(context.Background(), got.ID, "new-user"); err != nil || n != limit {
+		t.Errorf("usage count %d, %v; want %d", n, err, limit)
+	}
+}
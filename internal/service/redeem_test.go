@@ -0,0 +1,242 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository/memory"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/service"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/uow"
+)
+
+// hammer redeems req from workers goroutines, attempts times each, and returns
+// the successful responses and the rejection reasons
+func hammer(t *testing.T, svc *service.CouponService, req service.ValidateRequest, workers, attempts int) ([]service.ValidateResponse, map[string]int) {
+	t.Helper()
+	var (
+		mu       sync.Mutex
+		redeemed []service.ValidateResponse
+		reasons  = map[string]int{}
+		wg       sync.WaitGroup
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range attempts {
+				resp, err := svc.ValidateCoupon(context.Background(), req)
+				if err != nil {
+					// contention must be retried, never surface as internal_error
+					t.Errorf("validate: %v", err)
+					return
+				}
+				mu.Lock()
+				if resp.IsValid {
+					redeemed = append(redeemed, resp)
+				} else {
+					reasons[resp.Message]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return redeemed, reasons
+}
+
+// run with -race
+func TestValidateConcurrentRedemptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		usageType string
+		limit     int
+		reason    string
+	}{
+		{"multi_use", "multi_use", 3, "usage_limit_reached"},
+		{"one_time", "one_time", 1, "coupon_already_used"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			m := percentCoupon("HAMMER", 10)
+			m.UsageType = tt.usageType
+			m.MaxUsagePerUser = tt.limit
+			f.create(t, m)
+
+			const workers, attempts = 16, 8
+			req := cartRequest("u1", "HAMMER", item("med1", "painkillers", 40, 5))
+			redeemed, reasons := hammer(t, f.svc, req, workers, attempts)
+
+			if len(redeemed) != tt.limit {
+				t.Fatalf("%d successful redemptions, want %d; rejected %v", len(redeemed), tt.limit, reasons)
+			}
+			for _, resp := range redeemed {
+				if resp.Discount != 20 {
+					t.Errorf("discount %v, want 20", resp.Discount)
+				}
+			}
+			if reasons[tt.reason] != workers*attempts-tt.limit {
+				t.Errorf("rejected %v, want only %s", reasons, tt.reason)
+			}
+
+			got, err := f.svc.GetCoupon(context.Background(), "HAMMER")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, err := f.usage.GetUsageCount(context.Background(), got.ID, "u1"); err != nil || n != tt.limit {
+				t.Errorf("usage count %d, %v; want %d", n, err, tt.limit)
+			}
+		})
+	}
+}
+
+// snapshotUsage adds what the memory store lacks for first uses: like Postgres
+// under SERIALIZABLE, a transaction that locks a usage row committed after it
+// began fails with a serialization failure. The transaction creating a row
+// holds it until hold is closed.
+type snapshotUsage struct {
+	*memory.UsageRepo
+	store *memory.Store
+	hold  <-chan struct{}
+
+	mu        sync.Mutex
+	clock     int
+	begins    int
+	onBegin   func(begins int)
+	creating  map[usageKey]*snapshotTx
+	created   map[usageKey]int // clock at the creator's commit
+	conflicts int
+}
+
+type usageKey struct {
+	couponID int
+	userID   string
+}
+
+type snapshotTx struct {
+	uow.Tx
+	u       *snapshotUsage
+	begun   int
+	creates []usageKey
+}
+
+// Begin is used by admin writes, which hand the tx to the coupon repo
+func (u *snapshotUsage) Begin(ctx context.Context) (uow.Tx, error) {
+	return u.store.Begin(ctx)
+}
+
+func (u *snapshotUsage) BeginSerializable(ctx context.Context) (uow.Tx, error) {
+	tx, err := u.store.BeginSerializable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.clock++
+	u.begins++
+	u.onBegin(u.begins)
+	return &snapshotTx{Tx: tx, u: u, begun: u.clock}, nil
+}
+
+func (t *snapshotTx) Commit() error {
+	// recorded before the row lock is released, so waiters see it
+	t.u.mu.Lock()
+	t.u.clock++
+	for _, k := range t.creates {
+		t.u.created[k] = t.u.clock
+	}
+	t.u.mu.Unlock()
+	return t.Tx.Commit()
+}
+
+func (t *snapshotTx) Rollback() error {
+	t.u.mu.Lock()
+	for _, k := range t.creates {
+		if _, ok := t.u.created[k]; !ok {
+			delete(t.u.creating, k) // the insert is undone; the next caller creates the row
+		}
+	}
+	t.u.mu.Unlock()
+	return t.Tx.Rollback()
+}
+
+func (u *snapshotUsage) GetAndLockUsage(ctx context.Context, utx uow.Tx, couponID int, userID string) (int, error) {
+	t := utx.(*snapshotTx)
+	k := usageKey{couponID, userID}
+	u.mu.Lock()
+	_, exists := u.created[k]
+	creator := !exists && u.creating[k] == nil
+	if creator {
+		u.creating[k] = t
+		t.creates = append(t.creates, k)
+	}
+	u.mu.Unlock()
+
+	n, err := u.UsageRepo.GetAndLockUsage(ctx, t.Tx, couponID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if creator {
+		select {
+		case <-u.hold:
+		case <-time.After(5 * time.Second):
+			return 0, errors.New("other transactions never began")
+		}
+		return n, nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if at, ok := u.created[k]; ok && at > t.begun {
+		u.conflicts++
+		return 0, &pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}
+	}
+	return n, nil
+}
+
+func (u *snapshotUsage) IncrementUsage(ctx context.Context, utx uow.Tx, couponID int, userID string) error {
+	return u.UsageRepo.IncrementUsage(ctx, utx.(*snapshotTx).Tx, couponID, userID)
+}
+
+// TestValidateConcurrentFirstUse checks the retry loop against the failures
+// Postgres raises for concurrent first uses, without a database: the first
+// transaction creates the usage row and holds it until all others have begun,
+// so snapshotUsage fails each of them once and they must succeed on retry. The
+// statements themselves are tested in TestValidateConcurrentFirstUsePostgres.
+func TestValidateConcurrentFirstUse(t *testing.T) {
+	const workers, limit = 8, 3
+	store := memory.NewStore()
+	coupons := memory.NewCouponRepo(store)
+	hold := make(chan struct{})
+	usage := &snapshotUsage{
+		UsageRepo: memory.NewUsageRepo(store),
+		store:     store,
+		hold:      hold,
+		creating:  map[usageKey]*snapshotTx{},
+		created:   map[usageKey]int{},
+	}
+	usage.onBegin = func(begins int) {
+		if begins == workers {
+			close(hold)
+		}
+	}
+	svc := service.NewCouponService(usage, coupons, usage, memory.NewAssignmentRepo(store), memory.NewChildCodeRepo(store))
+	m := percentCoupon("FIRST", 10)
+	m.MaxUsagePerUser = limit
+	if _, err := svc.CreateCoupon(context.Background(), m, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	redeemed, reasons := hammer(t, svc, cartRequest("new-user", "FIRST", item("med1", "painkillers", 40, 5)), workers, 1)
+	if len(redeemed) != limit || reasons["usage_limit_reached"] != workers-limit {
+		t.Errorf("%d redeemed, rejected %v; want %d and %d usage_limit_reached", len(redeemed), reasons, limit, workers-limit)
+	}
+	if usage.conflicts != workers-1 {
+		t.Errorf("%d serialization failures, want %d", usage.conflicts, workers-1)
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// usage transaction retry policy; attempts are also bounded by the request deadline
+const (
+	maxRedeemAttempts = 5
+	redeemBackoffBase = 10 * time.Millisecond
+	redeemBackoffMax  = 250 * time.Millisecond
+)
+
+// metrics is served at /debug/vars under "coupon_service"
+var metrics = expvar.NewMap("coupon_service")
+
+// Postgres aborts these transactions under contention; running the whole
+// transaction again is safe
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+// retryableTxError reports whether err is a serialization failure or deadlock
+func retryableTxError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case sqlStateSerializationFailure:
+		metrics.Add("usage_tx_serialization_failures", 1)
+		return true
+	case sqlStateDeadlockDetected:
+		metrics.Add("usage_tx_deadlocks", 1)
+		return true
+	}
+	return false
+}
+
+// redeem runs redeemOnce, retrying serialization failures and deadlocks with
+// jittered exponential backoff while attempts and the request deadline allow
+func (s *CouponService) redeem(ctx context.Context, couponMeta *models.CouponMeta, child *models.ChildCode, userID string) (string, error) {
+	for attempt := 1; ; attempt++ {
+		reason, err := s.redeemOnce(ctx, couponMeta, child, userID)
+		if err == nil || !retryableTxError(err) {
+			return reason, err
+		}
+
+		wait := redeemBackoff(attempt)
+		if attempt == maxRedeemAttempts || !fitsDeadline(ctx, wait) {
+			metrics.Add("usage_tx_retries_exhausted", 1)
+			return "", err
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		metrics.Add("usage_tx_retries", 1)
+	}
+}
+
+// redeemBackoff is "full jitter": uniform in [0, min(max, base*2^attempt))
+func redeemBackoff(attempt int) time.Duration {
+	d := min(redeemBackoffBase<<attempt, redeemBackoffMax)
+	return rand.N(d)
+}
+
+// fitsDeadline reports whether waiting d still leaves time for another attempt
+func fitsDeadline(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > d
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// loadGroup collapses concurrent loads of the same coupon code into one call.
+type loadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*loadCall
+}
+
+type loadCall struct {
+	done chan struct{}
+	meta *models.CouponMeta
+	err  error
+}
+
+// do runs fn once for all callers asking for code at the same time. Each caller
+// stops waiting when its own ctx is done; fn itself keeps running for the rest.
+func (g *loadGroup) do(ctx context.Context, code string, fn func() (*models.CouponMeta, error)) (*models.CouponMeta, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*loadCall)
+	}
+	c, ok := g.calls[code]
+	if !ok {
+		c = &loadCall{done: make(chan struct{})}
+		g.calls[code] = c
+		go func() {
+			c.meta, c.err = fn()
+			g.mu.Lock()
+			if g.calls[code] == c {
+				delete(g.calls, code)
+			}
+			g.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.meta, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// forget makes the next do for code start a fresh load instead of joining one
+// that began before the coupon changed
+func (g *loadGroup) forget(code string) {
+	g.mu.Lock()
+	delete(g.calls, code)
+	g.mu.Unlock()
+}
+
+// forgetAll is forget for every code
+func (g *loadGroup) forgetAll() {
+	g.mu.Lock()
+	g.calls = nil
+	g.mu.Unlock()
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+// joinedCtx signals on joined when do starts waiting on it, i.e. once the
+// caller has joined or started a load
+type joinedCtx struct {
+	context.Context
+	joined chan<- struct{}
+}
+
+func (c joinedCtx) Done() <-chan struct{} {
+	c.joined <- struct{}{}
+	return c.Context.Done()
+}
+
+// blockingLoad counts its calls; call n returns coupon ID n (or err) once
+// release is closed
+type blockingLoad struct {
+	calls   atomic.Int64
+	release chan struct{}
+	err     error
+}
+
+func newBlockingLoad() *blockingLoad {
+	return &blockingLoad{release: make(chan struct{})}
+}
+
+func (l *blockingLoad) fn() (*models.CouponMeta, error) {
+	n := l.calls.Add(1)
+	<-l.release
+	if l.err != nil {
+		return nil, l.err
+	}
+	return &models.CouponMeta{Coupon: models.Coupon{ID: int(n)}}, nil
+}
+
+type loadResult struct {
+	meta *models.CouponMeta
+	err  error
+}
+
+// join starts n callers of g.do and returns once all of them wait for a load
+func join(g *loadGroup, n int, code string, fn func() (*models.CouponMeta, error)) <-chan loadResult {
+	joined := make(chan struct{})
+	results := make(chan loadResult, n)
+	for range n {
+		go func() {
+			meta, err := g.do(joinedCtx{context.Background(), joined}, code, fn)
+			results <- loadResult{meta, err}
+		}()
+	}
+	for range n {
+		<-joined
+	}
+	return results
+}
+
+// run with -race
+func TestLoadGroupCoalesces(t *testing.T) {
+	var g loadGroup
+	load := newBlockingLoad()
+	other := newBlockingLoad()
+	close(other.release)
+
+	results := join(&g, 20, "A", load.fn)
+	otherResult := join(&g, 1, "B", other.fn) // other codes load on their own
+	if r := <-otherResult; r.err != nil || r.meta.ID != 1 {
+		t.Fatalf("B: %+v", r)
+	}
+
+	close(load.release)
+	for range 20 {
+		if r := <-results; r.err != nil || r.meta.ID != 1 {
+			t.Errorf("A: %+v, want the first load's result", r)
+		}
+	}
+	if n := load.calls.Load(); n != 1 {
+		t.Errorf("%d loads, want 1", n)
+	}
+
+	// finished loads aren't cached
+	if meta, err := g.do(context.Background(), "A", load.fn); err != nil || meta.ID != 2 {
+		t.Errorf("after the load: %v, %v; want a new load", meta, err)
+	}
+}
+
+func TestLoadGroupSharesErrors(t *testing.T) {
+	var g loadGroup
+	load := newBlockingLoad()
+	load.err = errors.New("db down")
+
+	results := join(&g, 5, "A", load.fn)
+	close(load.release)
+	for range 5 {
+		if r := <-results; !errors.Is(r.err, load.err) || r.meta != nil {
+			t.Errorf("%+v, want the load's error", r)
+		}
+	}
+	load.err = nil
+	if meta, err := g.do(context.Background(), "A", load.fn); err != nil || meta.ID != 2 {
+		t.Errorf("after a failed load: %v, %v; want a new load", meta, err)
+	}
+}
+
+// a caller whose context ends stops waiting; the load finishes for the others
+func TestLoadGroupCallerCancel(t *testing.T) {
+	var g loadGroup
+	load := newBlockingLoad()
+	results := join(&g, 3, "A", load.fn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.do(ctx, "A", load.fn); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller: %v", err)
+	}
+
+	close(load.release)
+	for range 3 {
+		if r := <-results; r.err != nil || r.meta.ID != 1 {
+			t.Errorf("%+v", r)
+		}
+	}
+	if n := load.calls.Load(); n != 1 {
+		t.Errorf("%d loads, want 1", n)
+	}
+}
+
+func TestLoadGroupForget(t *testing.T) {
+	for name, forget := range map[string]func(g *loadGroup){
+		"forget":    func(g *loadGroup) { g.forget("A") },
+		"forgetAll": func(g *loadGroup) { g.forgetAll() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var g loadGroup
+			stale := newBlockingLoad()
+			staleResults := join(&g, 2, "A", stale.fn)
+
+			// the coupon changed: later callers must not get the running load
+			forget(&g)
+			fresh := newBlockingLoad()
+			fresh.calls.Store(10)
+			freshResults := join(&g, 2, "A", fresh.fn)
+
+			// the stale load finishing must not drop the fresh one, so this
+			// caller still joins it
+			close(stale.release)
+			for range 2 {
+				if r := <-staleResults; r.err != nil || r.meta.ID != 1 {
+					t.Errorf("stale: %+v", r)
+				}
+			}
+			lateResults := join(&g, 1, "A", fresh.fn)
+
+			close(fresh.release)
+			for range 2 {
+				if r := <-freshResults; r.err != nil || r.meta.ID != 11 {
+					t.Errorf("fresh: %+v", r)
+				}
+			}
+			if r := <-lateResults; r.err != nil || r.meta.ID != 11 {
+				t.Errorf("late: %+v, want the fresh load", r)
+			}
+			if stale.calls.Load() != 1 || fresh.calls.Load() != 11 {
+				t.Errorf("loads: stale %d, fresh %d", stale.calls.Load(), fresh.calls.Load()-10)
+			}
+		})
+	}
+}
+
+// run with -race: many callers and codes at once
+func TestLoadGroupConcurrent(t *testing.T) {
+	var g loadGroup
+	fn := func(code string) func() (*models.CouponMeta, error) {
+		return func() (*models.CouponMeta, error) {
+			return &models.CouponMeta{Coupon: models.Coupon{CouponCode: code}}, nil
+		}
+	}
+
+	var wg sync.WaitGroup
+	codes := []string{"A", "B", "C"}
+	for i := range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 200 {
+				code := codes[(i+j)%len(codes)]
+				if j%50 == 0 {
+					g.forget(code)
+				}
+				meta, err := g.do(context.Background(), code, fn(code))
+				if err != nil || meta.CouponCode != code {
+					t.Errorf("%s: %v, %v", code, meta, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
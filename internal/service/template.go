@@ -0,0 +1,275 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/couponcode"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+)
+
+var ErrNotTemplate = errors.New("coupon_not_template")
+
+const (
+	// per GenerateCodes call, which must finish within the server's 15s
+	// WriteTimeout; larger runs are split into several batches
+	maxGeneratedCodes = 100_000
+	codeInsertBatch   = 5000 // codes per InsertChildCodes
+	// the spec must allow this many times more codes than requested, so
+	// random codes rarely collide
+	minKeyspaceFactor = 100
+	// rounds in a row that may lose more than half their codes to collisions
+	maxCollisionRounds = 5
+	maxBatchLen        = 100 // coupon_codes.batch
+)
+
+// GeneratedCodes is the outcome of one GenerateCodes run
+type GeneratedCodes struct {
+	Batch     string `json:"batch"`
+	Generated int    `json:"generated"`
+}
+
+// GenerateCodes creates count unique child codes for a template in one
+// transaction, labelled batch (default: the UTC time). Codes that collide with
+// existing codes are replaced by new ones.
+//
+// The first batch fixes the template's code format from spec and draws its
+// secret; later batches reuse it and may leave spec empty. Of concurrent first
+// batches one fixes the format and the others use it.
+func (s *CouponService) GenerateCodes(ctx context.Context, templateCode string, spec couponcode.Spec, count int, batch string) (GeneratedCodes, error) {
+	template, err := s.GetCoupon(ctx, templateCode)
+	if err != nil {
+		return GeneratedCodes{}, err
+	}
+	if !template.IsTemplate {
+		return GeneratedCodes{}, ErrNotTemplate
+	}
+
+	var errs fieldErrors
+	if count <= 0 || count > maxGeneratedCodes {
+		errs.add("count", CodeOutOfRange, fmt.Sprintf("count must be between 1 and %d", maxGeneratedCodes))
+	}
+	batch = strings.TrimSpace(batch)
+	if batch == "" {
+		batch = time.Now().UTC().Format("20060102T150405")
+	} else if len(batch) > maxBatchLen {
+		errs.add("batch", CodeOutOfRange, fmt.Sprintf("batch must be at most %d characters", maxBatchLen))
+	}
+
+	format, isNew, err := s.codeFormat(ctx, template, spec, &errs)
+	if err != nil {
+		return GeneratedCodes{}, err
+	}
+	var gen *couponcode.Generator
+	if len(errs) == 0 {
+		if gen, err = newCodeGenerator(format, count, &errs); err != nil {
+			return GeneratedCodes{}, err
+		}
+	}
+	if len(errs) > 0 {
+		return GeneratedCodes{}, &InvalidCouponError{Errors: errs}
+	}
+
+	tx, err := s.txs.Begin(ctx)
+	if err != nil {
+		return GeneratedCodes{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if isNew {
+		stored, created, err := s.codeRepo.CreateCodeFormat(ctx, tx, template.ID, format)
+		if err != nil {
+			return GeneratedCodes{}, fmt.Errorf("create code format: %w", err)
+		}
+		if !created {
+			// a concurrent first batch fixed the format; go on like a later batch
+			if !spec.IsZero() && spec.WithDefaults() != stored.Spec {
+				errs.add("prefix", CodeConflict, "the code format is fixed by the first batch of the template; omit it or repeat it")
+			} else if gen, err = newCodeGenerator(stored, count, &errs); err != nil {
+				return GeneratedCodes{}, err
+			}
+			if len(errs) > 0 {
+				return GeneratedCodes{}, &InvalidCouponError{Errors: errs}
+			}
+			isNew = false
+		}
+	}
+
+	generated, collisionRounds := 0, 0
+	for generated < count {
+		n := min(count-generated, codeInsertBatch)
+		codes := make([]string, 0, n)
+		seen := make(map[string]bool, n)
+		for len(codes) < n {
+			code, err := gen.Next()
+			if err != nil {
+				return GeneratedCodes{}, fmt.Errorf("generate code: %w", err)
+			}
+			if !seen[code] {
+				seen[code] = true
+				codes = append(codes, code)
+			}
+		}
+
+		added, err := s.codeRepo.InsertChildCodes(ctx, tx, template.ID, batch, codes)
+		if err != nil {
+			return GeneratedCodes{}, fmt.Errorf("insert codes: %w", err)
+		}
+		generated += added
+		if added < n/2 {
+			collisionRounds++
+			if collisionRounds == maxCollisionRounds {
+				return GeneratedCodes{}, &InvalidCouponError{Errors: []FieldError{{
+					Field: "length", Code: CodeOutOfRange, Message: "too many generated codes are taken already; use a longer length or another prefix",
+				}}}
+			}
+		} else {
+			collisionRounds = 0
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return GeneratedCodes{}, fmt.Errorf("tx commit: %w", err)
+	}
+	// other instances forget misses when they expire
+	s.childMisses.Purge()
+	if isNew {
+		if err := s.formats.set(template.CouponCode, &format); err != nil {
+			return GeneratedCodes{}, err
+		}
+	}
+	return GeneratedCodes{Batch: batch, Generated: generated}, nil
+}
+
+// newCodeGenerator returns the generator of format, adding an error to errs if
+// its keyspace is too small for count codes
+func newCodeGenerator(format couponcode.Format, count int, errs *fieldErrors) (*couponcode.Generator, error) {
+	gen, err := couponcode.NewGenerator(format)
+	if err != nil {
+		return nil, err
+	}
+	if gen.Spec().Keyspace() < minKeyspaceFactor*float64(count) {
+		errs.add("length", CodeOutOfRange, "alphabet and length allow too few codes for count; use a longer length")
+	}
+	return gen, nil
+}
+
+// codeFormat returns the template's stored format, or a new one built from
+// spec (isNew). Problems with spec are added to errs.
+func (s *CouponService) codeFormat(ctx context.Context, template *models.CouponMeta, spec couponcode.Spec, errs *fieldErrors) (couponcode.Format, bool, error) {
+	stored, err := s.codeRepo.GetCodeFormat(ctx, template.ID)
+	if err != nil {
+		return couponcode.Format{}, false, err
+	}
+	if stored != nil {
+		if !spec.IsZero() && spec.WithDefaults() != stored.Spec {
+			errs.add("prefix", CodeConflict, "the code format is fixed by the first batch of the template; omit it or repeat it")
+		}
+		return *stored, false, nil
+	}
+
+	format, err := couponcode.NewFormat(spec)
+	var specErr *couponcode.SpecError
+	if errors.As(err, &specErr) {
+		errs.add(specErr.Field, CodeInvalid, specErr.Message)
+		return format, true, nil
+	}
+	if err != nil {
+		return format, true, err
+	}
+
+	// codes are told apart by prefix, so it must be unique to the template
+	prefix := format.NormalizedPrefix()
+	if s.formats.overlaps(template.CouponCode, prefix) {
+		errs.add("prefix", CodeConflict, "prefix overlaps the prefix of another template")
+	}
+	taken, err := s.couponRepo.HasCodePrefix(ctx, prefix)
+	if err != nil {
+		return format, true, err
+	}
+	if taken {
+		errs.add("prefix", CodeConflict, "existing coupon codes start with this prefix")
+	}
+	return format, true, nil
+}
+
+// EachChildCode streams the generated codes of a template, limited to one
+// batch unless batch is empty
+func (s *CouponService) EachChildCode(ctx context.Context, templateCode, batch string, fn func(*models.ChildCode) error) error {
+	template, err := s.GetCoupon(ctx, templateCode)
+	if err != nil {
+		return err
+	}
+	if !template.IsTemplate {
+		return ErrNotTemplate
+	}
+	return s.codeRepo.EachChildCode(ctx, template.ID, batch, fn)
+}
+
+// resolve finds the coupon a code redeems: a regular coupon, or the template
+// of a child code. Otherwise it returns the rejection reason: the code carries
+// a template's prefix but fails its format check (no repository is queried),
+// is unknown or a template's own code, or is a child code used already.
+//
+// Only codes that pass a template's format check are looked up as child codes;
+// no other coupon can carry a template's prefix (see CheckNew). Until the
+// formats are loaded, unknown coupon codes are looked up as child codes too.
+func (s *CouponService) resolve(ctx context.Context, code string) (*models.CouponMeta, *models.ChildCode, string, error) {
+	if g := s.formats.match(code); g != nil {
+		if !g.Verify(code) {
+			metrics.Add("invalid_code_format", 1)
+			return nil, nil, "invalid_code_format", nil
+		}
+		return s.resolveChild(ctx, code)
+	}
+
+	m, err := s.loadMeta(ctx, code)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if m != nil {
+		if m.IsTemplate {
+			return nil, nil, "coupon_not_found", nil
+		}
+		return m, nil, "", nil
+	}
+	if s.formats.loaded() {
+		return nil, nil, "coupon_not_found", nil
+	}
+	return s.resolveChild(ctx, code)
+}
+
+// resolveChild is resolve for a child code. Codes that don't exist are
+// remembered for notFoundTTL, like unknown coupon codes.
+func (s *CouponService) resolveChild(ctx context.Context, code string) (*models.CouponMeta, *models.ChildCode, string, error) {
+	norm := models.NormalizeCouponCode(code)
+	if _, ok := s.childMisses.Get(norm); ok {
+		return nil, nil, "coupon_not_found", nil
+	}
+	gen := s.childMisses.Generation()
+	child, err := s.codeRepo.GetChildCode(ctx, code)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if child == nil {
+		s.childMisses.SetNotFound(norm, notFoundTTL, gen)
+		return nil, nil, "coupon_not_found", nil
+	}
+	if child.RedeemedAt != nil {
+		return nil, nil, "coupon_already_used", nil
+	}
+	// the template meta is cached once for all its codes
+	m, err := s.loadMeta(ctx, child.TemplateCode)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if m == nil {
+		return nil, nil, "coupon_not_found", nil
+	}
+	return m, child, "", nil
+}
@@ -0,0 +1,187 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/couponcode"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/repository/memory"
+	"github.com/Cheertaboi/Billing-system-coupon-microservice/internal/service"
+)
+
+// countingCodes counts child code lookups
+type countingCodes struct {
+	*memory.ChildCodeRepo
+	lookups atomic.Int64
+}
+
+func (c *countingCodes) GetChildCode(ctx context.Context, code string) (*models.ChildCode, error) {
+	c.lookups.Add(1)
+	return c.ChildCodeRepo.GetChildCode(ctx, code)
+}
+
+func TestResolveLooksUpOnlyWellFormedChildCodes(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	codes := &countingCodes{ChildCodeRepo: memory.NewChildCodeRepo(store)}
+	svc := service.NewCouponService(store, memory.NewCouponRepo(store), memory.NewUsageRepo(store), memory.NewAssignmentRepo(store), codes)
+	if err := svc.LoadIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	template := percentCoupon("SMS", 10)
+	template.IsTemplate = true
+	id, err := svc.CreateCoupon(ctx, template, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.GenerateCodes(ctx, "SMS", couponcode.Spec{Prefix: "SMS-"}, 3, ""); err != nil {
+		t.Fatal(err)
+	}
+	var generated []string
+	if err := svc.EachChildCode(ctx, "SMS", "", func(cc *models.ChildCode) error {
+		generated = append(generated, cc.Code)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a code that passes the format check but was never generated
+	format, err := codes.GetCodeFormat(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err := couponcode.NewGenerator(*format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := gen.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := func(code, want string, lookups int64) {
+		t.Helper()
+		codes.lookups.Store(0)
+		resp, err := svc.ValidateCoupon(ctx, cartRequest("u1", code, item("med1", "painkillers", 50, 1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.IsValid != (want == "") || (want != "" && resp.Message != want) {
+			t.Errorf("%s: %+v, want %q", code, resp, want)
+		}
+		if n := codes.lookups.Load(); n != lookups {
+			t.Errorf("%s: %d child code lookups, want %d", code, n, lookups)
+		}
+	}
+	validate("NOPE", "coupon_not_found", 0)
+	validate("NOPE", "coupon_not_found", 0)
+	validate("SMS-SHORT", "invalid_code_format", 0)
+	validate(generated[0], "", 1)
+	validate(generated[0], "coupon_already_used", 1)
+	validate(forged, "coupon_not_found", 1)
+	validate(forged, "coupon_not_found", 0) // the miss is cached
+
+	// a new batch may contain a cached miss
+	if _, err := svc.GenerateCodes(ctx, "SMS", couponcode.Spec{}, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	validate(forged, "coupon_not_found", 1)
+}
+
+// racingFormats holds the next readers GetCodeFormat calls until all of them
+// have read, so each GenerateCodes call sees no format and tries to create one
+type racingFormats struct {
+	*memory.ChildCodeRepo
+	readers atomic.Int64
+	read    chan struct{}
+}
+
+func (r *racingFormats) GetCodeFormat(ctx context.Context, templateID int) (*couponcode.Format, error) {
+	f, err := r.ChildCodeRepo.GetCodeFormat(ctx, templateID)
+	switch n := r.readers.Add(-1); {
+	case n == 0:
+		close(r.read)
+	case n > 0:
+		<-r.read
+	}
+	return f, err
+}
+
+func TestGenerateCodesConcurrentFirstBatch(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	codes := &racingFormats{ChildCodeRepo: memory.NewChildCodeRepo(store), read: make(chan struct{})}
+	svc := service.NewCouponService(store, memory.NewCouponRepo(store), memory.NewUsageRepo(store), memory.NewAssignmentRepo(store), codes)
+	template := percentCoupon("SMS", 10)
+	template.IsTemplate = true
+	id, err := svc.CreateCoupon(ctx, template, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []couponcode.Spec{{Prefix: "SMS-"}, {Prefix: "SMS-"}, {Prefix: "TXT-"}}
+	codes.readers.Store(int64(len(specs)))
+	errs := make([]error, len(specs))
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = svc.GenerateCodes(ctx, "SMS", spec, 2, "")
+		}()
+	}
+	wg.Wait()
+
+	format, err := codes.ChildCodeRepo.GetCodeFormat(ctx, id)
+	if err != nil || format == nil {
+		t.Fatalf("stored format: %v %v", format, err)
+	}
+	generated := 0
+	for i, spec := range specs {
+		var invalid *service.InvalidCouponError
+		switch {
+		case spec.WithDefaults() == format.Spec:
+			if errs[i] != nil {
+				t.Errorf("batch %d with %+v: %v", i, spec, errs[i])
+			}
+			generated += 2
+		case !errors.As(errs[i], &invalid) || invalid.Errors[0].Code != service.CodeConflict:
+			t.Errorf("batch %d with %+v against %+v: %v", i, spec, format.Spec, errs[i])
+		}
+	}
+
+	gen, err := couponcode.NewGenerator(*format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	if err := svc.EachChildCode(ctx, "SMS", "", func(cc *models.ChildCode) error {
+		n++
+		if !gen.Verify(cc.Code) {
+			t.Errorf("%s does not match the stored format", cc.Code)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n != generated {
+		t.Errorf("%d codes, want %d", n, generated)
+	}
+}
+
+func TestGenerateCodesLimit(t *testing.T) {
+	f := newFixture(t)
+	template := percentCoupon("BULK", 10)
+	template.IsTemplate = true
+	f.create(t, template)
+
+	var invalid *service.InvalidCouponError
+	_, err := f.svc.GenerateCodes(context.Background(), "BULK", couponcode.Spec{Prefix: "B-"}, 100_001, "")
+	if !errors.As(err, &invalid) || invalid.Errors[0].Field != "count" {
+		t.Errorf("generate 100001: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package service
+
+import "github.com/Cheertaboi/Billing-system-coupon-microservice/internal/models"
+
+// TieredDiscount picks the highest tier the cart qualifies for and prices it.
+//
+// Tiers are keyed by order total or by eligible quantity (TierBasis). Percentage
+// tiers apply to the order total for "charges" coupons and to the eligible subtotal
+// for "inventory" coupons. ok is false when no tier is reached yet. next describes
+// the following tier, if any, so callers can tell the user how much more to add.
+func TieredDiscount(meta *models.CouponMeta, orderTotal float64, eligibleQty int, eligibleSubtotal float64) (discount float64, ok bool, next *models.NextTier) {
+	basis := orderTotal
+	if meta.TierBasis == "eligible_qty" {
+		basis = float64(eligibleQty)
+	}
+
+	current, upcoming := meta.TierFor(basis)
+	if upcoming != nil {
+		next = &models.NextTier{
+			Basis:         meta.TierBasis,
+			Threshold:     upcoming.Threshold,
+			Remaining:     upcoming.Threshold - basis,
+			DiscountType:  upcoming.DiscountType,
+			DiscountValue: upcoming.DiscountValue,
+		}
+	}
+	if current == nil {
+		return 0, false, next
+	}
+
+	if current.DiscountType == "percentage" {
+		base := eligibleSubtotal
+		if meta.TargetType == "charges" {
+			base = orderTotal
+		}
+		return base * (current.DiscountValue / 100.0), true, next
+	}
+	return current.DiscountValue, true, next
+}
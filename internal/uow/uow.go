@@ -0,0 +1,66 @@
+// Package uow is the unit-of-work abstraction the service uses for transactions,
+// so it doesn't need a *sql.DB and can run against in-memory repositories.
+package uow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Tx is one unit of work. Repository methods that take a Tx run inside it;
+// nothing they write is visible to others until Commit. Rollback after Commit
+// is a no-op, so it can always be deferred.
+type Tx interface {
+	Commit() error
+	Rollback() error
+}
+
+// Beginner starts units of work
+type Beginner interface {
+	// Begin starts a unit of work with the store's default isolation
+	Begin(ctx context.Context) (Tx, error)
+	// BeginSerializable starts a unit of work with serializable isolation
+	BeginSerializable(ctx context.Context) (Tx, error)
+}
+
+// ErrForeignTx is returned by a repository handed a Tx from another store
+var ErrForeignTx = errors.New("uow: transaction belongs to a different store")
+
+// SQL begins database/sql transactions
+type SQL struct {
+	db *sql.DB
+}
+
+func NewSQL(db *sql.DB) *SQL {
+	return &SQL{db: db}
+}
+
+func (s *SQL) Begin(ctx context.Context) (Tx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sqlTx{tx}, nil
+}
+
+func (s *SQL) BeginSerializable(ctx context.Context) (Tx, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return nil, err
+	}
+	return sqlTx{tx}, nil
+}
+
+type sqlTx struct {
+	*sql.Tx
+}
+
+// SQLTx returns the *sql.Tx behind a Tx begun by SQL
+func SQLTx(tx Tx) (*sql.Tx, error) {
+	t, ok := tx.(sqlTx)
+	if !ok {
+		return nil, ErrForeignTx
+	}
+	return t.Tx, nil
+}
@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE coupons
+    ADD COLUMN min_eligible_qty INT NOT NULL DEFAULT 0,
+    ADD COLUMN min_eligible_subtotal NUMERIC(12,2) NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE coupons
+    DROP COLUMN IF EXISTS min_eligible_subtotal,
+    DROP COLUMN IF EXISTS min_eligible_qty;
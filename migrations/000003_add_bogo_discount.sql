@@ -0,0 +1,30 @@
+-- +goose Up
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_discount_type_check;
+ALTER TABLE coupons
+    ADD CONSTRAINT coupons_discount_type_check CHECK (discount_type IN ('flat','percentage','bogo')),
+    ADD COLUMN buy_quantity INT NOT NULL DEFAULT 0,
+    ADD COLUMN get_quantity INT NOT NULL DEFAULT 0;
+
+-- items/categories handed out on the "get" side of a bogo coupon;
+-- when none are set the get side is the coupon's applicable items/categories
+CREATE TABLE coupon_reward_items (
+    id SERIAL PRIMARY KEY,
+    coupon_id INT NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+    medicine_id VARCHAR(100) NOT NULL
+);
+
+CREATE TABLE coupon_reward_categories (
+    id SERIAL PRIMARY KEY,
+    coupon_id INT NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+    category_name VARCHAR(100) NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS coupon_reward_categories;
+DROP TABLE IF EXISTS coupon_reward_items;
+ALTER TABLE coupons
+    DROP COLUMN IF EXISTS get_quantity,
+    DROP COLUMN IF EXISTS buy_quantity,
+    DROP CONSTRAINT IF EXISTS coupons_discount_type_check;
+ALTER TABLE coupons
+    ADD CONSTRAINT coupons_discount_type_check CHECK (discount_type IN ('flat','percentage'));
@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_discount_type_check;
+ALTER TABLE coupons
+    ADD CONSTRAINT coupons_discount_type_check CHECK (discount_type IN ('flat','percentage','bogo','tiered')),
+    ADD COLUMN tier_basis VARCHAR(20) CHECK (tier_basis IN ('order_total','eligible_qty'));
+
+CREATE TABLE coupon_discount_tiers (
+    id SERIAL PRIMARY KEY,
+    coupon_id INT NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+    threshold NUMERIC(12,2) NOT NULL,
+    discount_type VARCHAR(20) NOT NULL CHECK (discount_type IN ('flat','percentage')),
+    discount_value NUMERIC(12,2) NOT NULL,
+    UNIQUE (coupon_id, threshold)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS coupon_discount_tiers;
+ALTER TABLE coupons
+    DROP COLUMN IF EXISTS tier_basis,
+    DROP CONSTRAINT IF EXISTS coupons_discount_type_check;
+ALTER TABLE coupons
+    ADD CONSTRAINT coupons_discount_type_check CHECK (discount_type IN ('flat','percentage','bogo'));
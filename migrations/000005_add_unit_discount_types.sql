@@ -0,0 +1,13 @@
+-- +goose Up
+ALTER TABLE coupons DROP CONSTRAINT IF EXISTS coupons_discount_type_check;
+ALTER TABLE coupons
+    ADD CONSTRAINT coupons_discount_type_check
+        CHECK (discount_type IN ('flat','percentage','bogo','tiered','per_unit_flat','fixed_price')),
+    ADD COLUMN max_units_discounted INT NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE coupons
+    DROP COLUMN IF EXISTS max_units_discounted,
+    DROP CONSTRAINT IF EXISTS coupons_discount_type_check;
+ALTER TABLE coupons
+    ADD CONSTRAINT coupons_discount_type_check CHECK (discount_type IN ('flat','percentage','bogo','tiered'));
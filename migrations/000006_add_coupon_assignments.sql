@@ -0,0 +1,17 @@
+-- +goose Up
+ALTER TABLE coupons
+    ADD COLUMN audience VARCHAR(20) NOT NULL DEFAULT 'public' CHECK (audience IN ('public','assigned'));
+
+-- users an "assigned" coupon was issued to
+CREATE TABLE coupon_assigned_users (
+    coupon_id INT NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+    user_id VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    PRIMARY KEY (coupon_id, user_id)
+);
+
+CREATE INDEX idx_coupon_assigned_users_user ON coupon_assigned_users (user_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS coupon_assigned_users;
+ALTER TABLE coupons DROP COLUMN IF EXISTS audience;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- conditions over the caller-supplied user context; all must hold
+CREATE TABLE coupon_eligibility_conditions (
+    id SERIAL PRIMARY KEY,
+    coupon_id INT NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+    attribute VARCHAR(50) NOT NULL,
+    operator VARCHAR(20) NOT NULL CHECK (operator IN ('eq','neq','in','not_in','gt','gte','lt','lte')),
+    vals TEXT[] NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS coupon_eligibility_conditions;
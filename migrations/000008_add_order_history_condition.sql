@@ -0,0 +1,11 @@
+-- +goose Up
+-- prior-order window: max_prior_orders = 0 means first order only;
+-- min = max = N-1 means the user's Nth order only
+ALTER TABLE coupons
+    ADD COLUMN min_prior_orders INT CHECK (min_prior_orders >= 0),
+    ADD COLUMN max_prior_orders INT CHECK (max_prior_orders >= 0);
+
+-- +goose Down
+ALTER TABLE coupons
+    DROP COLUMN IF EXISTS max_prior_orders,
+    DROP COLUMN IF EXISTS min_prior_orders;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- optional rule written in the internal/expr language, validated on create
+ALTER TABLE coupons ADD COLUMN rule_expression TEXT;
+
+-- +goose Down
+ALTER TABLE coupons DROP COLUMN IF EXISTS rule_expression;
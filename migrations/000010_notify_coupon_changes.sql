@@ -0,0 +1,77 @@
+-- +goose Up
+-- every write to a coupon or its rule tables publishes the coupon code on the
+-- coupon_changed channel so each service instance can evict its cached copy.
+-- Postgres folds duplicate payloads within one transaction into one notification.
+
+-- +goose StatementBegin
+CREATE FUNCTION notify_coupon_changed() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM pg_notify('coupon_changed', OLD.coupon_code);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM pg_notify('coupon_changed', NEW.coupon_code);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- child rows carry only coupon_id; rows removed by ON DELETE CASCADE find no
+-- parent, which already notified
+-- +goose StatementBegin
+CREATE FUNCTION notify_coupon_child_changed() RETURNS trigger AS $$
+DECLARE
+    code TEXT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        SELECT coupon_code INTO code FROM coupons WHERE id = OLD.coupon_id;
+    ELSE
+        SELECT coupon_code INTO code FROM coupons WHERE id = NEW.coupon_id;
+    END IF;
+    IF code IS NOT NULL THEN
+        PERFORM pg_notify('coupon_changed', code);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER coupons_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON coupons
+    FOR EACH ROW EXECUTE FUNCTION notify_coupon_changed();
+
+CREATE TRIGGER coupon_applicable_items_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON coupon_applicable_items
+    FOR EACH ROW EXECUTE FUNCTION notify_coupon_child_changed();
+
+CREATE TRIGGER coupon_applicable_categories_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON coupon_applicable_categories
+    FOR EACH ROW EXECUTE FUNCTION notify_coupon_child_changed();
+
+CREATE TRIGGER coupon_reward_items_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON coupon_reward_items
+    FOR EACH ROW EXECUTE FUNCTION notify_coupon_child_changed();
+
+CREATE TRIGGER coupon_reward_categories_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON coupon_reward_categories
+    FOR EACH ROW EXECUTE FUNCTION notify_coupon_child_changed();
+
+CREATE TRIGGER coupon_discount_tiers_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON coupon_discount_tiers
+    FOR EACH ROW EXECUTE FUNCTION notify_coupon_child_changed();
+
+CREATE TRIGGER coupon_eligibility_conditions_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON coupon_eligibility_conditions
+    FOR EACH ROW EXECUTE FUNCTION notify_coupon_child_changed();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS coupon_eligibility_conditions_notify_changed ON coupon_eligibility_conditions;
+DROP TRIGGER IF EXISTS coupon_discount_tiers_notify_changed ON coupon_discount_tiers;
+DROP TRIGGER IF EXISTS coupon_reward_categories_notify_changed ON coupon_reward_categories;
+DROP TRIGGER IF EXISTS coupon_reward_items_notify_changed ON coupon_reward_items;
+DROP TRIGGER IF EXISTS coupon_applicable_categories_notify_changed ON coupon_applicable_categories;
+DROP TRIGGER IF EXISTS coupon_applicable_items_notify_changed ON coupon_applicable_items;
+DROP TRIGGER IF EXISTS coupons_notify_changed ON coupons;
+DROP FUNCTION IF EXISTS notify_coupon_child_changed();
+DROP FUNCTION IF EXISTS notify_coupon_changed();
@@ -0,0 +1,60 @@
+-- +goose Up
+-- codes are looked up by their normalized form: ASCII whitespace, and '-' and
+-- '_' unless code_normalization says otherwise, removed, then upper-cased. Must
+-- match models.NormalizeCouponCode. The whitespace is spelled out because
+-- [[:space:]] depends on the database locale.
+--
+-- Whether separators are stripped is stored here, with the codes normalized by
+-- it, and read by the service at startup. Change it only with
+-- set_code_separator_stripping.
+CREATE TABLE code_normalization (
+    only_row BOOLEAN PRIMARY KEY DEFAULT true CHECK (only_row),
+    strip_separators BOOLEAN NOT NULL
+);
+INSERT INTO code_normalization (strip_separators) VALUES (true);
+
+ALTER TABLE coupons ADD COLUMN coupon_code_norm VARCHAR(100);
+UPDATE coupons SET coupon_code_norm = upper(regexp_replace(coupon_code, '[ \t\n\v\f\r_-]', '', 'g'));
+
+-- existing codes that only differ in case or separators can no longer be told
+-- apart; fail with the list so they can be renamed before migrating again
+-- +goose StatementBegin
+DO $$
+DECLARE
+    collisions TEXT;
+BEGIN
+    SELECT string_agg(codes, '; ') INTO collisions
+    FROM (
+        SELECT coupon_code_norm || ' <- ' || string_agg(coupon_code, ', ' ORDER BY id) AS codes
+        FROM coupons
+        GROUP BY coupon_code_norm
+        HAVING count(*) > 1
+    ) c;
+    IF collisions IS NOT NULL THEN
+        RAISE EXCEPTION 'coupon codes collide after normalization: %', collisions;
+    END IF;
+END;
+$$;
+-- +goose StatementEnd
+
+ALTER TABLE coupons ALTER COLUMN coupon_code_norm SET NOT NULL;
+ALTER TABLE coupons ADD CONSTRAINT coupons_coupon_code_norm_key UNIQUE (coupon_code_norm);
+
+-- set_code_separator_stripping renormalizes every stored code and records the
+-- setting; codes that would collide fail it on the unique constraint. Restart
+-- the service afterwards, it reads the setting at startup.
+-- +goose StatementBegin
+CREATE FUNCTION set_code_separator_stripping(strip BOOLEAN) RETURNS void AS $$
+DECLARE
+    pattern TEXT := CASE WHEN strip THEN '[ \t\n\v\f\r_-]' ELSE '[ \t\n\v\f\r]' END;
+BEGIN
+    UPDATE code_normalization SET strip_separators = strip;
+    UPDATE coupons SET coupon_code_norm = upper(regexp_replace(coupon_code, pattern, '', 'g'));
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP FUNCTION IF EXISTS set_code_separator_stripping(BOOLEAN);
+ALTER TABLE coupons DROP COLUMN IF EXISTS coupon_code_norm;
+DROP TABLE IF EXISTS code_normalization;
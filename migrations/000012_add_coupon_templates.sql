@@ -0,0 +1,51 @@
+-- +goose Up
+-- a template coupon holds the rules shared by many generated single-use codes;
+-- the template's own code is never redeemable
+ALTER TABLE coupons ADD COLUMN is_template BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE coupon_codes (
+    id BIGSERIAL PRIMARY KEY,
+    coupon_id INT NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+    code VARCHAR(100) NOT NULL,
+    code_norm VARCHAR(100) NOT NULL UNIQUE, -- see coupons.coupon_code_norm
+    batch VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    redeemed_at TIMESTAMP WITH TIME ZONE,
+    redeemed_by VARCHAR(100)
+);
+
+CREATE INDEX idx_coupon_codes_coupon_batch ON coupon_codes(coupon_id, batch, id);
+
+-- generated codes are renormalized too, and must not collide with coupon codes
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_code_separator_stripping(strip BOOLEAN) RETURNS void AS $$
+DECLARE
+    pattern TEXT := CASE WHEN strip THEN '[ \t\n\v\f\r_-]' ELSE '[ \t\n\v\f\r]' END;
+    collisions TEXT;
+BEGIN
+    UPDATE code_normalization SET strip_separators = strip;
+    UPDATE coupons SET coupon_code_norm = upper(regexp_replace(coupon_code, pattern, '', 'g'));
+    UPDATE coupon_codes SET code_norm = upper(regexp_replace(code, pattern, '', 'g'));
+
+    SELECT string_agg(cc.code || ' <- ' || c.coupon_code, '; ') INTO collisions
+    FROM coupon_codes cc JOIN coupons c ON c.coupon_code_norm = cc.code_norm;
+    IF collisions IS NOT NULL THEN
+        RAISE EXCEPTION 'generated codes collide with coupon codes: %', collisions;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_code_separator_stripping(strip BOOLEAN) RETURNS void AS $$
+DECLARE
+    pattern TEXT := CASE WHEN strip THEN '[ \t\n\v\f\r_-]' ELSE '[ \t\n\v\f\r]' END;
+BEGIN
+    UPDATE code_normalization SET strip_separators = strip;
+    UPDATE coupons SET coupon_code_norm = upper(regexp_replace(coupon_code, pattern, '', 'g'));
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+DROP TABLE IF EXISTS coupon_codes;
+ALTER TABLE coupons DROP COLUMN IF EXISTS is_template;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- code format of a template, fixed by its first generated batch; see
+-- internal/couponcode. The secret keys the HMAC fragment of its codes and never
+-- leaves the service.
+CREATE TABLE coupon_code_formats (
+    coupon_id INT PRIMARY KEY REFERENCES coupons(id) ON DELETE CASCADE,
+    alphabet VARCHAR(64) NOT NULL,
+    length INT NOT NULL,
+    prefix VARCHAR(100) NOT NULL,
+    check_char BOOLEAN NOT NULL,
+    mac_length INT NOT NULL,
+    secret BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- instances keep formats in memory; a new format is announced like a coupon change
+CREATE TRIGGER coupon_code_formats_notify_changed
+    AFTER INSERT OR UPDATE OR DELETE ON coupon_code_formats
+    FOR EACH ROW EXECUTE FUNCTION notify_coupon_child_changed();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS coupon_code_formats_notify_changed ON coupon_code_formats;
+DROP TABLE IF EXISTS coupon_code_formats;
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 )
@@ -26,3 +27,11 @@ func LoadPostgresConfig() (PostgresConfig, error) {
 		SSLMode:  os.Getenv("DB_SSLMODE"),
 	}, nil
 }
+
+// DSN returns the connection URL for cfg
+func (cfg PostgresConfig) DSN() string {
+	return fmt.Sprintf(
+		"postgres://%s:%s@%s:%d/%s?sslmode=%s",
+		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.DBName, cfg.SSLMode,
+	)
+}